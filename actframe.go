@@ -0,0 +1,285 @@
+package cyw43439
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"log/slog"
+	"time"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+var (
+	errActionFramesDisabled = errors.New("action frames not enabled")
+	errActionFrameTooLarge  = errors.New("action frame payload too large")
+	errActionFrameNoAck     = errors.New("action frame not acknowledged")
+	errActionFrameTimeout   = errors.New("action frame tx timeout")
+	errPeerListFull         = errors.New("peer list full")
+)
+
+const (
+	// MaxActionFramePayload is the maximum payload size of a vendor action frame
+	// sent with [Device.SendActionFrame].
+	MaxActionFramePayload = 1024
+	// maxActionFramePeers is the maximum amount of peers in the peer list.
+	maxActionFramePeers = 16
+
+	afCategoryVendor = 127 // 802.11 vendor specific action frame category.
+	afType           = 0xcb
+	afFlagAuth       = 1 << 0 // Frame carries an authentication tag.
+	afHeaderLen      = 1 + 3 + 1 + 1
+	afTagLen         = 8 // Truncated HMAC-SHA256 tag length.
+	afDwellTime      = 40 * time.Millisecond
+	afTxTimeout      = time.Second
+	// wl_af_params_t: channel, dwell_time, BSSID, then wl_action_frame_t: da, len, packetId and data.
+	afParamsDataOffset = 4 + 4 + 6 + 6 + 2 + 4
+	afParamsLen        = afParamsDataOffset + 1800
+)
+
+// defaultActionFrameOUI is the OUI identifying action frames sent by this package when none is configured.
+var defaultActionFrameOUI = [3]byte{0xb8, 0x27, 0xeb}
+
+// ActionFrameConfig configures connectionless vendor action frame messaging
+// enabled with [Device.EnableActionFrames].
+type ActionFrameConfig struct {
+	// Channel is the channel frames are sent and received on. All devices
+	// exchanging frames must use the same channel. Zero selects channel 1.
+	// If the device is associated frames are received on the network's channel.
+	Channel uint8
+	// OUI identifies the vendor action frames of the application. Frames with a different
+	// OUI are ignored. The zero value selects a default OUI.
+	OUI [3]byte
+	// Key enables payload authentication with a truncated HMAC-SHA256 tag when set.
+	// Unauthenticated frames or frames with an invalid tag are dropped.
+	// Authentication does not protect against replayed frames.
+	Key []byte
+}
+
+type actionFrameState struct {
+	enabled  bool
+	channel  uint8
+	oui      [3]byte
+	mac      hash.Hash
+	handler  func(src [6]byte, payload []byte)
+	npeers   uint8
+	peers    [maxActionFramePeers][6]byte
+	packetID uint32
+	txDone   bool
+	txStatus whd.EStatus
+	tag      [sha256.Size]byte
+}
+
+// EnableActionFrames enables connectionless messaging between devices using vendor specific
+// 802.11 action frames, no access point is needed. handler is called with the sender's address
+// and payload of every frame received. The payload is only valid during the handler call.
+// If peers were added with [Device.AddPeer] only frames from peers are delivered.
+//
+// Frames are received during [Device.PollOne]. The handler is called with the
+// device lock held so it must not call methods on the Device.
+func (d *Device) EnableActionFrames(cfg ActionFrameConfig, handler func(src [6]byte, payload []byte)) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	if handler == nil {
+		return errors.New("nil action frame handler")
+	} else if cfg.Channel > maxChannel2G {
+		return errScanChannel
+	}
+	if cfg.Channel == 0 {
+		cfg.Channel = 1
+	}
+	if cfg.OUI == [3]byte{} {
+		cfg.OUI = defaultActionFrameOUI
+	}
+	d.info("EnableActionFrames", slog.Int("channel", int(cfg.Channel)), slog.Bool("auth", len(cfg.Key) > 0))
+	if d.state != linkStateUp && !d.apUp {
+		// Tune to the channel to receive frames while idle.
+		err = d.set_ioctl(whd.WLC_SET_CHANNEL, whd.IF_STA, uint32(cfg.Channel))
+		if err != nil {
+			return err
+		}
+	}
+	af := &d.actframe
+	af.enabled = true
+	af.channel = cfg.Channel
+	af.oui = cfg.OUI
+	af.handler = handler
+	af.mac = nil
+	if len(cfg.Key) > 0 {
+		af.mac = hmac.New(sha256.New, cfg.Key)
+	}
+	d.eventmask.Enable(whd.EvACTION_FRAME_RX)
+	return nil
+}
+
+// DisableActionFrames disables vendor action frame messaging and clears the peer list.
+func (d *Device) DisableActionFrames() {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		d.logerr("cyw:disableactionframes", slog.String("err", err.Error()))
+		return
+	}
+	d.actframe = actionFrameState{}
+	d.eventmask.Disable(whd.EvACTION_FRAME_RX)
+}
+
+// AddPeer adds a device to the peer list. Once a peer is added only frames
+// from devices in the peer list are delivered to the handler.
+func (d *Device) AddPeer(mac [6]byte) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	af := &d.actframe
+	if af.peerIndex(mac) >= 0 {
+		return nil
+	} else if af.npeers >= maxActionFramePeers {
+		return errPeerListFull
+	}
+	af.peers[af.npeers] = mac
+	af.npeers++
+	return nil
+}
+
+// RemovePeer removes a device from the peer list.
+func (d *Device) RemovePeer(mac [6]byte) {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		d.logerr("cyw:removepeer", slog.String("err", err.Error()))
+		return
+	}
+	af := &d.actframe
+	idx := af.peerIndex(mac)
+	if idx < 0 {
+		return
+	}
+	af.npeers--
+	af.peers[idx] = af.peers[af.npeers]
+	af.peers[af.npeers] = [6]byte{}
+}
+
+// SendActionFrame sends payload to dst in a vendor action frame and waits until the frame is
+// acknowledged. Use the broadcast address ff:ff:ff:ff:ff:ff to send to all devices, broadcast
+// frames are not acknowledged.
+func (d *Device) SendActionFrame(dst [6]byte, payload []byte) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	af := &d.actframe
+	if !af.enabled {
+		return errActionFramesDisabled
+	} else if len(payload) > MaxActionFramePayload {
+		return errActionFrameTooLarge
+	}
+	channel := af.channel
+	if d.state == linkStateUp || d.apUp {
+		channel, err = d.staChannel()
+		if err != nil {
+			return err
+		}
+	}
+	af.packetID++
+	// Build "actframe" iovar in place to avoid copying the large wl_af_params_t.
+	buf8 := u32AsU8(d._iovarBuf[:])
+	const iovar = "actframe\x00"
+	n := copy(buf8, iovar)
+	params := buf8[n : n+afParamsLen]
+	clear(params)
+	_busOrder.PutUint32(params[0:4], uint32(channel))
+	_busOrder.PutUint32(params[4:8], uint32(afDwellTime/time.Millisecond))
+	copy(params[8:14], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) // Wildcard BSSID.
+	copy(params[14:20], dst[:])
+	_busOrder.PutUint32(params[22:26], af.packetID)
+	body := params[afParamsDataOffset:]
+	body[0] = afCategoryVendor
+	copy(body[1:4], af.oui[:])
+	body[4] = afType
+	flen := afHeaderLen + copy(body[afHeaderLen:], payload)
+	if af.mac != nil {
+		body[5] = afFlagAuth
+		flen += copy(body[flen:], af.authTag(d.mac, body[:flen]))
+	}
+	_busOrder.PutUint16(params[20:22], uint16(flen))
+
+	d.debug("SendActionFrame", slog.Int("len", len(payload)), slog.Int("ch", int(channel)))
+	af.txDone = false
+	d.eventmask.Enable(whd.EvACTION_FRAME_COMPLETE)
+	defer d.eventmask.Disable(whd.EvACTION_FRAME_COMPLETE)
+	err = d.doIoctlSet(whd.WLC_SET_VAR, whd.IF_STA, buf8[:n+afParamsLen])
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(afTxTimeout)
+	for !af.txDone {
+		if time.Since(deadline) >= 0 {
+			return errActionFrameTimeout
+		}
+		time.Sleep(time.Millisecond)
+		err = d.check_status(d._sendIoctlBuf[:])
+		if err != nil {
+			return err
+		}
+	}
+	if af.txStatus != whd.EStatusSuccess && dst != [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff} {
+		return errActionFrameNoAck
+	}
+	return nil
+}
+
+// rxActionFrame processes ACTION_FRAME_RX event data which is made up of a
+// wl_event_rx_frame_data header followed by the action frame body.
+func (d *Device) rxActionFrame(src [6]byte, data []byte) error {
+	af := &d.actframe
+	const rxFrameDataLen = 16
+	if !af.enabled || len(data) < rxFrameDataLen+afHeaderLen {
+		return nil
+	}
+	body := data[rxFrameDataLen:]
+	if body[0] != afCategoryVendor || [3]byte(body[1:4]) != af.oui || body[4] != afType {
+		return nil // Not our action frame.
+	}
+	if af.npeers > 0 && af.peerIndex(src) < 0 {
+		return nil // Not a peer.
+	}
+	payload := body[afHeaderLen:]
+	if af.mac != nil {
+		if body[5]&afFlagAuth == 0 || len(payload) < afTagLen {
+			d.debug("actframe:unauthenticated")
+			return nil
+		}
+		payload = payload[:len(payload)-afTagLen]
+		gotTag := body[afHeaderLen+len(payload):]
+		if !hmac.Equal(gotTag, af.authTag(src, body[:afHeaderLen+len(payload)])) {
+			d.debug("actframe:bad-tag")
+			return nil
+		}
+	}
+	af.handler(src, payload)
+	return nil
+}
+
+// authTag returns the truncated HMAC-SHA256 tag of the frame sent by src.
+func (af *actionFrameState) authTag(src [6]byte, frame []byte) []byte {
+	af.mac.Reset()
+	af.mac.Write(src[:])
+	af.mac.Write(frame)
+	return af.mac.Sum(af.tag[:0])[:afTagLen]
+}
+
+func (af *actionFrameState) peerIndex(mac [6]byte) int {
+	for i := 0; i < int(af.npeers); i++ {
+		if af.peers[i] == mac {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,165 @@
+package cyw43439
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+// actionFrames emulates the firmware's "actframe" iovar. Sent frames are
+// recorded and completed with status.
+type actionFrames struct {
+	chip    *cywemu.Chip
+	status  whd.EStatus
+	channel uint32
+	dst     [6]byte
+	body    []byte
+}
+
+func (af *actionFrames) onIoctl(io *cywemu.Ioctl) bool {
+	if io.Cmd != whd.WLC_SET_VAR || io.Name != "actframe" {
+		return false
+	}
+	order := binary.LittleEndian
+	af.channel = order.Uint32(io.Data[0:4])
+	af.dst = [6]byte(io.Data[14:20])
+	n := int(order.Uint16(io.Data[20:22]))
+	af.body = append([]byte(nil), io.Data[afParamsDataOffset:afParamsDataOffset+n]...)
+	af.chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvACTION_FRAME_COMPLETE, Status: uint32(af.status)}, nil)
+	return true
+}
+
+func TestActionFrames(t *testing.T) {
+	var emu actionFrames
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{Networks: []cywemu.Network{testWPA2Net}, OnIoctl: emu.onIoctl}, testConfig())
+	emu.chip = chip
+	peer := [6]byte{0x02, 0xcc, 0, 0, 0, 1}
+	bcast := [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if err := dev.SendActionFrame(peer, []byte("hello")); !errors.Is(err, errActionFramesDisabled) {
+		t.Fatalf("want action frames disabled error, got %v", err)
+	}
+	type rxFrame struct {
+		src     [6]byte
+		payload []byte
+	}
+	var rx []rxFrame
+	handler := func(src [6]byte, payload []byte) {
+		rx = append(rx, rxFrame{src: src, payload: append([]byte(nil), payload...)})
+	}
+	err := dev.EnableActionFrames(ActionFrameConfig{Channel: 3, Key: []byte("secret")}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_CHANNEL, ""); got != 3 {
+		t.Errorf("tuned to channel %d, want 3", got)
+	}
+
+	// Sent frames are authenticated vendor action frames.
+	payload := []byte("hello")
+	err = dev.SendActionFrame(peer, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHdr := []byte{afCategoryVendor, 0xb8, 0x27, 0xeb, afType, afFlagAuth}
+	if emu.channel != 3 || emu.dst != peer || len(emu.body) != afHeaderLen+len(payload)+afTagLen ||
+		!bytes.Equal(emu.body[:afHeaderLen], wantHdr) || !bytes.Equal(emu.body[afHeaderLen:afHeaderLen+len(payload)], payload) {
+		t.Fatalf("sent frame to %x on channel %d: %x", emu.dst, emu.channel, emu.body)
+	}
+	if err := dev.SendActionFrame(peer, make([]byte, MaxActionFramePayload+1)); !errors.Is(err, errActionFrameTooLarge) {
+		t.Errorf("want too large error, got %v", err)
+	}
+	emu.status = whd.EStatusNoAck
+	if err := dev.SendActionFrame(peer, payload); !errors.Is(err, errActionFrameNoAck) {
+		t.Errorf("want no ack error, got %v", err)
+	}
+	if err := dev.SendActionFrame(bcast, payload); err != nil {
+		t.Errorf("broadcast frames are not acknowledged, got %v", err)
+	}
+	emu.status = whd.EStatusSuccess
+
+	// Received frames are checked for OUI, peer and authentication tag. The frame
+	// sent by the device is received back so it is tagged with the device address.
+	receive := func(src [6]byte, body []byte) {
+		data := append(make([]byte, 16), body...) // wl_event_rx_frame_data precedes body.
+		chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvACTION_FRAME_RX, Addr: src}, data)
+		pollAll(t, dev, chip)
+	}
+	err = dev.SendActionFrame(peer, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := emu.body
+	receive(dev.mac, sent)
+	if len(rx) != 1 || rx[0].src != dev.mac || !bytes.Equal(rx[0].payload, payload) {
+		t.Fatalf("want frame received, got %+v", rx)
+	}
+	rx = rx[:0]
+	tampered := bytes.Clone(sent)
+	tampered[afHeaderLen] ^= 1
+	receive(dev.mac, tampered)
+	otherOUI := bytes.Clone(sent)
+	otherOUI[1] ^= 1
+	receive(dev.mac, otherOUI)
+	receive(peer, sent) // Tag does not match sender.
+	if len(rx) != 0 {
+		t.Fatalf("want invalid frames dropped, got %+v", rx)
+	}
+
+	err = dev.AddPeer(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(dev.mac, sent)
+	if len(rx) != 0 {
+		t.Fatal("frame from device not in peer list delivered")
+	}
+	err = dev.AddPeer(dev.mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(dev.mac, sent)
+	if len(rx) != 1 {
+		t.Fatal("frame from peer not delivered")
+	}
+	dev.RemovePeer(dev.mac)
+	receive(dev.mac, sent)
+	if len(rx) != 1 {
+		t.Fatal("frame from removed peer delivered")
+	}
+	dev.RemovePeer(peer)
+	for i := 0; i < maxActionFramePeers; i++ {
+		if err := dev.AddPeer([6]byte{0x02, 0xdd, 0, 0, 0, byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dev.AddPeer(peer); !errors.Is(err, errPeerListFull) {
+		t.Errorf("want peer list full error, got %v", err)
+	}
+
+	// Associated devices send on the network's channel.
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: testWPA2Net.Passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.SendActionFrame(bcast, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emu.channel != uint32(testWPA2Net.Channel) {
+		t.Errorf("sent on channel %d, want network channel %d", emu.channel, testWPA2Net.Channel)
+	}
+
+	dev.DisableActionFrames()
+	rx = rx[:0]
+	receive(dev.mac, sent)
+	if len(rx) != 0 {
+		t.Error("frame delivered with action frames disabled")
+	}
+	if err := dev.SendActionFrame(peer, payload); !errors.Is(err, errActionFramesDisabled) {
+		t.Errorf("want action frames disabled error, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package cyw43439
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+var errAPDown = errors.New("access point not started")
+
+const (
+	// maxAPStations is the maximum amount of stations returned by [Device.APStations].
+	maxAPStations = 16
+	// defaultAPChannel is the channel used by access points if none is specified. Same as pico-sdk's default.
+	defaultAPChannel = 3
+)
+
+// APStations appends the MAC addresses of the stations associated with the access point
+// started with [Device.StartAP] to dst and returns the result.
+func (d *Device) APStations(dst [][6]byte) ([][6]byte, error) {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return dst, err
+	}
+	if !d.apUp {
+		return dst, errAPDown
+	}
+	// maclist: uint32 count followed by count MAC addresses. Count is set to
+	// the buffer capacity on request.
+	var buf [4 + 6*maxAPStations]byte
+	_busOrder.PutUint32(buf[:4], maxAPStations)
+	_, err = d.doIoctlGet(whd.WLC_GET_ASSOCLIST, d.apIface(), buf[:])
+	if err != nil {
+		return dst, err
+	}
+	count := _busOrder.Uint32(buf[:4])
+	if count > maxAPStations {
+		d.warn("APStations:truncated", slog.Uint64("count", uint64(count)))
+		count = maxAPStations
+	}
+	for i := uint32(0); i < count; i++ {
+		dst = append(dst, [6]byte(buf[4+6*i:10+6*i]))
+	}
+	return dst, nil
+}
+
+// APStationRSSI returns the received signal strength in dBm of a station
+// associated with the access point.
+func (d *Device) APStationRSSI(mac [6]byte) (int16, error) {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return 0, err
+	}
+	if !d.apUp {
+		return 0, errAPDown
+	}
+	// scb_val_t: int32 value followed by station address.
+	var buf [12]byte
+	copy(buf[4:10], mac[:])
+	_, err = d.doIoctlGet(whd.WLC_GET_RSSI, d.apIface(), buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return int16(int32(_busOrder.Uint32(buf[:4]))), nil
+}
+
+// APDeauth deauthenticates a station associated with the access point with an 802.11 reason code.
+// Reason code 1 (unspecified) or 3 (station leaving) are a sensible default.
+func (d *Device) APDeauth(mac [6]byte, reason uint16) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	if !d.apUp {
+		return errAPDown
+	}
+	d.info("APDeauth", slog.String("mac", net.HardwareAddr(mac[:]).String()), slog.Int("reason", int(reason)))
+	// scb_val_t: int32 reason followed by station address.
+	var buf [12]byte
+	_busOrder.PutUint32(buf[:4], uint32(reason))
+	copy(buf[4:10], mac[:])
+	return d.doIoctlSet(whd.WLC_SCB_DEAUTHENTICATE_FOR_REASON, d.apIface(), buf[:])
+}
+
+// apIface returns the interface the access point operates on.
+func (d *Device) apIface() whd.IoctlInterface {
+	if d.apConcurrent {
+		return whd.IF_AP
+	}
+	return whd.IF_STA
+}
+
+// isAPEvent returns true if the event was received on the interface the access point operates on.
+func (d *Device) isAPEvent(msg *whd.EventMessage) bool {
+	return d.apUp && whd.IoctlInterface(msg.IFIdx) == d.apIface()
+}
+
+// enableAPEvents enables events of stations joining and leaving the access point.
+func (d *Device) enableAPEvents(enable bool) {
+	evs := [...]whd.AsyncEventType{whd.EvASSOC_IND, whd.EvREASSOC_IND, whd.EvDISASSOC_IND, whd.EvDEAUTH_IND}
+	for _, ev := range evs {
+		if enable {
+			d.eventmask.Enable(ev)
+		} else {
+			d.eventmask.Disable(ev)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package cyw43439
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+// apStations emulates the firmware's table of stations associated with the access point.
+type apStations struct {
+	macs   [][6]byte
+	rssi   int16
+	iface  whd.IoctlInterface // Interface the ioctls are expected on.
+	kicked [6]byte
+	reason uint32
+}
+
+func (st *apStations) onIoctl(io *cywemu.Ioctl) bool {
+	order := binary.LittleEndian
+	switch io.Cmd {
+	case whd.WLC_GET_ASSOCLIST:
+		if io.Iface != st.iface {
+			return false
+		}
+		resp := order.AppendUint32(nil, uint32(len(st.macs)))
+		for _, mac := range st.macs {
+			resp = append(resp, mac[:]...)
+		}
+		io.Response = resp
+	case whd.WLC_GET_RSSI:
+		if io.Iface != st.iface || len(io.Data) < 10 {
+			return false
+		}
+		io.Response = order.AppendUint32(nil, uint32(int32(st.rssi)))
+	case whd.WLC_SCB_DEAUTHENTICATE_FOR_REASON:
+		st.reason = order.Uint32(io.Data)
+		st.kicked = [6]byte(io.Data[4:10])
+	default:
+		return false
+	}
+	return true
+}
+
+func TestAPStations(t *testing.T) {
+	sta1 := [6]byte{0x02, 0xaa, 0, 0, 0, 1}
+	sta2 := [6]byte{0x02, 0xaa, 0, 0, 0, 2}
+	stations := apStations{rssi: -47, iface: whd.IF_STA}
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{OnIoctl: stations.onIoctl}, testConfig())
+	if _, err := dev.APStations(nil); !errors.Is(err, errAPDown) {
+		t.Fatalf("want access point down error, got %v", err)
+	}
+	var events []Event
+	err := dev.SetEventHandler(func(ev Event) { events = append(events, ev) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.StartAP("emu-ap", "password123", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events = events[:0]
+	for _, mac := range [][6]byte{sta1, sta2} {
+		stations.macs = append(stations.macs, mac)
+		chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvASSOC_IND, Addr: mac}, nil)
+	}
+	pollAll(t, dev, chip)
+	if len(events) != 2 || events[0].Kind != EventStationJoined || events[1].Message.Addr != sta2 {
+		t.Fatalf("want 2 station joined events, got %+v", events)
+	}
+	got, err := dev.APStations(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != sta1 || got[1] != sta2 {
+		t.Errorf("got stations %x, want %x and %x", got, sta1, sta2)
+	}
+	rssi, err := dev.APStationRSSI(sta2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rssi != stations.rssi {
+		t.Errorf("got station rssi %d, want %d", rssi, stations.rssi)
+	}
+
+	err = dev.APDeauth(sta1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stations.kicked != sta1 || stations.reason != 3 {
+		t.Errorf("deauthenticated %x reason %d, want %x reason 3", stations.kicked, stations.reason, sta1)
+	}
+	events = events[:0]
+	stations.macs = stations.macs[1:]
+	chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvDEAUTH_IND, Addr: sta1, Reason: 3}, nil)
+	pollAll(t, dev, chip)
+	if len(events) != 1 || events[0].Kind != EventStationLeft || events[0].Message.Addr != sta1 || events[0].Reason() != 3 {
+		t.Fatalf("want station left event, got %+v", events)
+	}
+	if !dev.IsLinkUp() {
+		t.Error("station leaving took access point link down")
+	}
+}
+
+// bsscfgValue returns the value of the last bsscfg iovar name set for bsscfg index bsscfg.
+func bsscfgValue(t *testing.T, chip *cywemu.Chip, name string, bsscfg uint32) uint32 {
+	t.Helper()
+	ioctls := chip.Ioctls()
+	for i := len(ioctls) - 1; i >= 0; i-- {
+		io := &ioctls[i]
+		if io.Cmd == whd.WLC_SET_VAR && io.Name == name && len(io.Data) >= 8 && binary.LittleEndian.Uint32(io.Data) == bsscfg {
+			return binary.LittleEndian.Uint32(io.Data[4:])
+		}
+	}
+	t.Fatalf("iovar %q for bsscfg %d not set", name, bsscfg)
+	return 0
+}
+
+func TestStartAPOptions(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	for _, opts := range []APOptions{
+		{Auth: JoinAuthOpen, Passphrase: "password123"},
+		{Auth: JoinAuthWPA2, Passphrase: "short"},
+		{Auth: JoinAuthWPA3},
+		{MulticastRate: 1200},
+	} {
+		if err := dev.StartAPWithOptions("emu-ap", opts); err == nil {
+			t.Errorf("invalid options accepted: %+v", opts)
+		}
+	}
+	if err := dev.StopAP(); !errors.Is(err, errAPDown) {
+		t.Errorf("want access point down error, got %v", err)
+	}
+
+	err := dev.StartAPWithOptions("wpa3-ap", APOptions{
+		Auth:            JoinAuthWPA3,
+		Passphrase:      "sae-password",
+		Channel:         11,
+		Hidden:          true,
+		BeaconPeriod:    200,
+		DTIMPeriod:      3,
+		MaxAssociations: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssid, up := chip.AP(); !up || ssid != "wpa3-ap" {
+		t.Fatalf("access point not up: ssid=%q up=%v", ssid, up)
+	}
+	for _, test := range []struct {
+		cmd  whd.SDPCMCommand
+		name string
+		want uint32
+	}{
+		{cmd: whd.WLC_SET_CHANNEL, want: 11},
+		{cmd: whd.WLC_SET_BCNPRD, want: 200},
+		{cmd: whd.WLC_SET_DTIMPRD, want: 3},
+		{cmd: whd.WLC_SET_VAR, name: "maxassoc", want: 4},
+		{cmd: whd.WLC_SET_VAR, name: "mfp", want: whd.MFP_REQUIRED},
+		{cmd: whd.WLC_SET_VAR, name: "2g_mrate", want: 22},
+	} {
+		if got := lastIoctlValue(t, chip, test.cmd, test.name); got != test.want {
+			t.Errorf("%s %q=%d, want %d", test.cmd.String(), test.name, got, test.want)
+		}
+	}
+	if got := bsscfgValue(t, chip, "bsscfg:closednet", 0); got != 1 {
+		t.Error("hidden SSID not set")
+	}
+	if got := bsscfgValue(t, chip, "bsscfg:wpa_auth", 0); got != whd.WPA_AUTH_WPA3_SAE_PSK {
+		t.Errorf("got wpa_auth %#x, want SAE", got)
+	}
+	if countIoctls(chip, whd.WLC_SET_VAR, "sae_password") != 1 || countIoctls(chip, whd.WLC_SET_WSEC_PMK, "") != 0 {
+		t.Error("WPA3 access point must only set the SAE password")
+	}
+	if !dev.IsLinkUp() {
+		t.Error("link not up with access point started")
+	}
+
+	err = dev.StopAP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, up := chip.AP(); up {
+		t.Error("access point up after stop")
+	}
+	if dev.IsLinkUp() {
+		t.Error("link up after access point stopped")
+	}
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_VAR, "apsta"); got != 1 {
+		t.Error("station mode not restored")
+	}
+}
+
+func TestConcurrentAP(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet, testWPA2Net)
+	err := dev.Join(testOpenNet.SSID, JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.StartAPWithOptions("emu-ap", APOptions{Passphrase: "password123", Channel: 1, Concurrent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_CHANNEL, ""); got != uint32(testOpenNet.Channel) {
+		t.Errorf("access point on channel %d, want station channel %d", got, testOpenNet.Channel)
+	}
+	if got := bsscfgValue(t, chip, "bss", 1); got != 1 {
+		t.Error("access point BSS not up")
+	}
+
+	var events []Event
+	err = dev.SetEventHandler(func(ev Event) { events = append(events, ev) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sta := [6]byte{0x02, 0xaa, 0, 0, 0, 1}
+	chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvDEAUTH_IND, Addr: testOpenNet.BSSID}, nil)
+	pollAll(t, dev, chip)
+	for _, ev := range events {
+		if ev.Kind == EventStationLeft {
+			t.Fatalf("station interface event reported as station leaving the access point: %+v", ev)
+		}
+	}
+	events = events[:0]
+	chip.QueueEvent(whd.IF_AP, whd.EventMessage{EventType: whd.EvDEAUTH_IND, Addr: sta}, nil)
+	pollAll(t, dev, chip)
+	if len(events) != 1 || events[0].Kind != EventStationLeft || events[0].Message.Addr != sta {
+		t.Fatalf("want station left event, got %+v", events)
+	}
+	if !dev.IsLinkUp() {
+		t.Error("access point events took station link down")
+	}
+
+	// Ethernet frames are routed by interface.
+	frame := bytes.Repeat([]byte{0xee}, 60)
+	err = dev.SendEthIface(whd.IF_AP, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := chip.Frames()
+	if last := frames[len(frames)-1]; last.Iface != whd.IF_AP || !bytes.Equal(last.Data, frame) {
+		t.Errorf("sent frame on interface %d: %x", last.Iface, last.Data)
+	}
+	var staRx, apRx [][]byte
+	dev.RecvEthHandle(func(pkt []byte) error { staRx = append(staRx, pkt); return nil })
+	err = dev.RecvEthHandleIface(whd.IF_AP, func(pkt []byte) error {
+		apRx = append(apRx, append([]byte(nil), pkt...))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip.InjectEthernet(whd.IF_AP, frame)
+	pollAll(t, dev, chip)
+	if len(staRx) != 0 || len(apRx) != 1 || !bytes.Equal(apRx[0], frame) {
+		t.Errorf("access point frame received by station=%d access point=%d handlers", len(staRx), len(apRx))
+	}
+
+	// Access point follows the station to the channel of another network.
+	err = dev.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: testWPA2Net.Passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollAll(t, dev, chip)
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_CHANNEL, ""); got != uint32(testWPA2Net.Channel) {
+		t.Errorf("access point on channel %d, want station channel %d", got, testWPA2Net.Channel)
+	}
+	if got := bsscfgValue(t, chip, "bss", 1); got != 1 {
+		t.Error("access point BSS not restarted")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/soypat/cyw43439/cywtrace"
+	"github.com/soypat/saleae"
+)
+
+// conform compares the driver trace in file drvTrace against a reference and writes
+// the missing, extra and differing accesses to w. The reference is the cywtrace file
+// refTrace if set, otherwise the capture files. Captures with only the chip select
+// channel carry no command data so accesses can't be aligned, only the amount of
+// transactions is compared.
+func (bus *BusCtl) conform(w io.Writer, drvTrace, refTrace, sdio, enable, clk string) (ok bool, err error) {
+	got, err := readTrace(drvTrace)
+	if err != nil {
+		return false, err
+	}
+	var ref []cywtrace.Record
+	switch {
+	case refTrace != "":
+		ref, err = readTrace(refTrace)
+		if err != nil {
+			return false, err
+		}
+	case sdio == "" || clk == "":
+		df, err := opendigital(enable)
+		if err != nil {
+			return false, err
+		}
+		nref, ngot := enableFrames(df), 0
+		for i := range got {
+			if got[i].Op != cywtrace.OpPower {
+				ngot++
+			}
+		}
+		_, err = fmt.Fprintf(w, "reference has chip select only, comparing transaction count: ref=%d driver=%d diff=%+d\n", nref, ngot, ngot-nref)
+		return ngot == nref, err
+	default:
+		commands, err := bus.processSpiFiles(sdio, clk, enable)
+		if err != nil {
+			return false, err
+		}
+		ref = bus.records(commands)
+	}
+	mismatches, err := cywtrace.Diff(ref, got)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range mismatches {
+		_, err = fmt.Fprintln(w, m.String())
+		if err != nil {
+			return false, err
+		}
+	}
+	fmt.Fprintf(w, "%d mismatches: ref=%d driver=%d accesses\n", len(mismatches), len(cywtrace.Accesses(ref)), len(cywtrace.Accesses(got)))
+	return len(mismatches) == 0, nil
+}
+
+func readTrace(filename string) ([]cywtrace.Record, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return cywtrace.Decode(fp)
+}
+
+// records converts capture transactions to trace records so they can be compared
+// against a driver trace. The capture is assumed to start at power on. Commands
+// sent before the bus is set to 32 bit words are kept as captured since
+// [cywtrace.Accesses] unswaps them, as it does for driver traces. The trailing
+// status word is kept, only data covered by the command size is compared.
+func (bus *BusCtl) records(txs []cywtx) []cywtrace.Record {
+	records := []cywtrace.Record{{Op: cywtrace.OpPower, On: true}}
+	for _, tx := range txs {
+		rec := cywtrace.Record{
+			Op:  cywtrace.OpRead,
+			Cmd: b2u32(tx.Cmd.Write)<<31 | b2u32(tx.Cmd.AutoInc)<<30 | uint32(tx.Cmd.Fn&0b11)<<28 | (tx.Cmd.Addr&0x1ffff)<<11 | tx.Cmd.Size&0x7ff,
+		}
+		if tx.Cmd.Write {
+			rec.Op = cywtrace.OpWrite
+		}
+		rec.Data = make([]uint32, (len(tx.Data)+3)/4)
+		for i := range rec.Data {
+			var word [4]byte
+			copy(word[:], tx.Data[4*i:])
+			rec.Data[i] = bus.WordInterpreter.Uint32(word[:])
+		}
+		for i := 0; i < tx.Num; i++ {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// enableFrames returns the amount of transactions, i.e. active low periods, in a chip select capture.
+func enableFrames(enable *saleae.DigitalFile) (n int) {
+	low := enable.Header.InitialState == 0
+	if low {
+		n++
+	}
+	for range enable.Data {
+		low = !low
+		if low {
+			n++
+		}
+	}
+	return n
+}
+
+func b2u32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 
 	"log/slog"
 
+	"github.com/soypat/cyw43439/cywtrace"
 	"github.com/soypat/saleae"
 	"github.com/soypat/saleae/analyzers"
 	"golang.org/x/exp/constraints"
@@ -22,7 +24,8 @@ import (
 
 // Optional flags.
 var (
-	timingsOutput string
+	timingsOutput  string
+	protocolOutput string
 )
 
 type BusCtl struct {
@@ -38,6 +41,11 @@ type BusCtl struct {
 	OmitIneffectual bool
 	PadDataToWord   bool
 	OmitAddrs       []uint32
+	// Format of the command output, one of text, jsonl or csv.
+	Format string
+	Filter txFilter
+	// Stats enables writing summary statistics of the selected commands to stdout.
+	Stats bool
 }
 
 func main() {
@@ -54,6 +62,8 @@ func main() {
 	output := flag.String("o-cmd", "commands.txt", "Output filename of CYW43439 command transactions.")
 
 	flag.StringVar(&timingsOutput, "o-time", "", "Output timing data to a file corresponding to output command history line-by-line.")
+	flag.StringVar(&protocolOutput, "o-proto", "", "Output SDPCM frames reassembled from WLAN transfers to a file, decoding ioctls, async events and ethernet frames.")
+	traceInput := flag.String("f-trace", "", "Input filename: cywtrace driver trace. Used instead of the capture files.")
 	const defaultOrdering = "le"
 	flagInterpretWords := flag.String("interpret-words", "", "Interpret byte data as uint32 words based on bctl-le. Accepts 'be' or 'le'.")
 	flagBCTLLE := flag.String("bctl-order", defaultOrdering, "Bus Control register in little endian mode.")
@@ -65,12 +75,20 @@ func main() {
 	omitIneffectual := flag.Bool("omit-inef", false, "Omit data after the command size.")
 	omitAddrs := flag.String("omit-addrs", "", "Omit commands with these addresses. Comma separated list of hex addresses.")
 	padDataToWord := flag.Bool("pad-data", false, "Pad data to word size (4 bytes).")
+	conformDrv := flag.String("conform", "", "Driver trace file recorded with cywtrace. Reports missing, extra and differing accesses against the reference capture files or -conform-ref. Leave -f-sd or -f-clk empty to compare against a chip select only capture, which only compares the amount of transactions since accesses cannot be aligned without data.")
+	format := flag.String("o-format", formatText, "Output format of command transactions: text, jsonl or csv.")
+	filterExpr := flag.String("filter", "", "Select commands with comma separated terms: fn=bus|backplane|wlan|dma2, dir=r|w, addr=0x1000a[-0x1000e] (hex, inclusive) and t=start-end (seconds, either bound optional).")
+	stats := flag.Bool("stats", false, "Print summary statistics of the selected commands: counts and bytes per function, bus utilisation and gaps.")
+	conformRef := flag.String("conform-ref", "", "Reference trace file recorded with cywtrace, used instead of capture files by -conform.")
 	flag.Parse()
 	if *flagInterpretWords == "" {
 		*flagInterpretWords = *flagBCTLLE
 	}
 	var addrs []uint32
 	for i, addr := range strings.Split(*omitAddrs, ",") {
+		if addr == "" {
+			continue
+		}
 		addr = strings.TrimPrefix(addr, "0x")
 		v, err := strconv.ParseUint(addr, 16, 32)
 		if err != nil {
@@ -78,6 +96,10 @@ func main() {
 		}
 		addrs = append(addrs, uint32(v))
 	}
+	filter, err := parseFilter(*filterExpr)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	getOrder := func(s string) binary.ByteOrder {
 		switch s {
 		case "be":
@@ -99,22 +121,54 @@ func main() {
 		PadDataToWord:   *padDataToWord,
 		OmitIneffectual: *omitIneffectual,
 		OmitAddrs:       addrs,
+		Format:          *format,
+		Filter:          filter,
+		Stats:           *stats,
 	}
 	if BUS.OmitRead && BUS.OmitWrite {
 		log.Fatal("cannot omit both read and write commands")
 	}
 	start := time.Now()
-	if err := BUS.run(*sdio, *enable, *clk, *output); err != nil {
+	if *conformDrv != "" {
+		ok, err := BUS.conform(os.Stdout, *conformDrv, *conformRef, *sdio, *enable, *clk)
+		if err != nil {
+			log.Fatal(err.Error())
+		} else if !ok {
+			os.Exit(1)
+		}
+		log.Println("finished in", time.Since(start))
+		return
+	}
+	var commands []cywtx
+	if *traceInput != "" {
+		var records []cywtrace.Record
+		records, err = readTrace(*traceInput)
+		commands = transactions(records)
+	} else {
+		commands, err = BUS.processSpiFiles(*sdio, *clk, *enable)
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := BUS.run(commands, *output); err != nil {
 		log.Fatal(err.Error())
 	}
 	log.Println("finished in", time.Since(start))
 }
 
-func (bus *BusCtl) run(sdio, enable, clk, output string) error {
-	const fmtMsg = "cmd×%2d %s data=%#x"
-	commands, err := bus.processSpiFiles(sdio, clk, enable)
-	if err != nil {
-		return err
+func (bus *BusCtl) run(commands []cywtx, output string) error {
+	if protocolOutput != "" {
+		// Decode before command data is modified by output options.
+		log.Println("creating protocol file", protocolOutput)
+		proto, err := os.Create(protocolOutput)
+		if err != nil {
+			return err
+		}
+		defer proto.Close()
+		err = decodeFrames(proto, commands)
+		if err != nil {
+			return err
+		}
 	}
 	fp, err := os.Create(output)
 	if err != nil {
@@ -122,18 +176,41 @@ func (bus *BusCtl) run(sdio, enable, clk, output string) error {
 	}
 	defer fp.Close()
 
-	var timings *os.File
+	var timings io.Writer
 	if timingsOutput != "" {
 		log.Println("creating timings file", timingsOutput)
-		timings, err = os.Create(timingsOutput)
+		ft, err := os.Create(timingsOutput)
 		if err != nil {
 			return err
 		}
-		defer timings.Close()
+		defer ft.Close()
+		timings = ft
+	}
+	selected, err := bus.writeCommands(fp, timings, commands)
+	if err != nil {
+		return err
 	}
+	if bus.Stats {
+		return writeStats(os.Stdout, selected)
+	}
+	return nil
+}
 
-	for _, action := range commands {
-		if (bus.OmitRead && !action.Cmd.Write) || (bus.OmitWrite && action.Cmd.Write) {
+// writeCommands writes the commands selected by the filter and omit options to w in
+// the output format and their timings to timings if not nil. It returns the selected commands.
+func (bus *BusCtl) writeCommands(w, timings io.Writer, commands []cywtx) (selected []cywtx, err error) {
+	const fmtMsg = "cmd×%2d %s data=%#x"
+	var tw *txWriter
+	if bus.Format != "" && bus.Format != formatText {
+		tw, err = newTxWriter(w, bus.Format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, action := range commands {
+		if !bus.Filter.match(&action) {
+			continue
+		} else if (bus.OmitRead && !action.Cmd.Write) || (bus.OmitWrite && action.Cmd.Write) {
 			continue
 		} else if bus.OmitReadData && !action.Cmd.Write {
 			action.Data = []byte{}
@@ -151,23 +228,28 @@ func (bus *BusCtl) run(sdio, enable, clk, output string) error {
 		if bus.OmitIneffectual && action.Cmd.Size < uint32(len(action.Data)) {
 			action.Data = action.Data[:action.Cmd.Size]
 		}
-		if action.Cmd.Size < uint32(len(action.Data)) {
+		selected = append(selected, action)
+		if tw != nil {
+			err = tw.write(i, &action)
+		} else if action.Cmd.Size < uint32(len(action.Data)) {
 			// Print a space demarcating end of the command data.
 			// Anything after space is "garbage" data and not actually part of the command.
-			fmt.Fprintf(fp, fmtMsg, action.Num, action.Cmd.String(), action.Data[:action.Cmd.Size])
-			_, err = fmt.Fprintf(fp, " %x", action.Data[action.Cmd.Size:])
+			fmt.Fprintf(w, fmtMsg, action.Num, action.Cmd.String(), action.Data[:action.Cmd.Size])
+			_, err = fmt.Fprintf(w, " %x\n", action.Data[action.Cmd.Size:])
 		} else {
-			_, err = fmt.Fprintf(fp, fmtMsg, action.Num, action.Cmd.String(), action.Data)
+			_, err = fmt.Fprintf(w, fmtMsg+"\n", action.Num, action.Cmd.String(), action.Data)
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
-		fmt.Fprintln(fp)
 		if timings != nil {
 			fmt.Fprintf(timings, "t=%f\tdata=%#x\n", action.Start, action.Data)
 		}
 	}
-	return nil
+	if tw != nil {
+		err = tw.flush()
+	}
+	return selected, err
 }
 
 func (bus *BusCtl) processSpiFiles(fsdio, fclk, fenable string) ([]cywtx, error) {
@@ -242,11 +324,28 @@ func (bus *BusCtl) CommandFromBytes(b []byte) (cmd CYW43439Cmd, data []byte) {
 	return cmd, data
 }
 
+// statusFromBytes returns the gSPI status word following the command data in b
+// when the transaction is 4 bytes longer than the command size.
+func (bus *BusCtl) statusFromBytes(b []byte) (status uint32, ok bool) {
+	raw := BusCtl{Order: bus.Order}
+	cmd, data := raw.CommandFromBytes(b)
+	if cmd.Fn == funcInvalid || uint32(len(data)) != cmd.Size+4 {
+		return 0, false
+	}
+	return bus.Order.Uint32(data[cmd.Size:]), true
+}
+
 type cywtx struct {
 	Num   int
 	Cmd   CYW43439Cmd
 	Data  []byte
 	Start float64
+	End   float64
+	// Busy is the sum of the durations of the Num merged transactions.
+	Busy float64
+	// Status is the gSPI status word following the data, set if HasStatus.
+	Status    uint32
+	HasStatus bool
 }
 
 func (bus *BusCtl) process(txs []analyzers.TxSPI) (cytxs []cywtx) {
@@ -257,20 +356,27 @@ func (bus *BusCtl) process(txs []analyzers.TxSPI) (cytxs []cywtx) {
 		if slices.Contains(bus.OmitAddrs, cmd.Addr) {
 			continue
 		}
+		status, hasStatus := bus.statusFromBytes(tx.SDO)
+		busy := tx.EndTime() - tx.StartTime()
 		for j := i + 1; j < len(txs); j++ {
 			nextcmd, nextdata := bus.CommandFromBytes(txs[j].SDO)
 			if nextcmd != cmd || !bytes.Equal(data, nextdata) {
 				break
 			}
 			accumulativeResults++
+			busy += txs[j].EndTime() - txs[j].StartTime()
 			i = j
 		}
 		bus.interpretBytes(data)
 		cytxs = append(cytxs, cywtx{
-			Num:   accumulativeResults,
-			Cmd:   cmd,
-			Data:  data,
-			Start: tx.StartTime(),
+			Num:       accumulativeResults,
+			Cmd:       cmd,
+			Data:      data,
+			Start:     tx.StartTime(),
+			End:       txs[i].EndTime(),
+			Busy:      busy,
+			Status:    status,
+			HasStatus: hasStatus,
 		})
 		accumulativeResults = 1
 	}
@@ -3,7 +3,16 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/soypat/cyw43439"
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/cywtrace"
+	"github.com/soypat/cyw43439/whd"
+	"github.com/soypat/saleae"
 )
 
 func TestInterpretBytes(t *testing.T) {
@@ -44,3 +53,284 @@ func TestInterpretBytes(t *testing.T) {
 		t.Fatal("expected big endian", data)
 	}
 }
+
+var testNet = cywemu.Network{SSID: "analyze-net", BSSID: [6]byte{0x02, 1, 2, 3, 4, 5}, Channel: 6}
+
+// recordInit records the driver initializing an emulated chip.
+func recordInit(t *testing.T) (*cyw43439.Device, *cywtrace.Recorder, *bytes.Buffer) {
+	t.Helper()
+	chip := cywemu.New(cywemu.Config{Networks: []cywemu.Network{testNet}})
+	trace := new(bytes.Buffer)
+	rec := cywtrace.NewRecorder(chip, trace)
+	dev := cyw43439.New(rec.Power(chip.Power), func(bool) {}, rec)
+	err := dev.Init(cyw43439.DefaultWifiConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev, rec, trace
+}
+
+func TestConform(t *testing.T) {
+	_, _, trace := recordInit(t)
+	filename := filepath.Join(t.TempDir(), "init.cywt")
+	err := os.WriteFile(filename, trace.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := BusCtl{Order: binary.LittleEndian, WordInterpreter: binary.LittleEndian}
+	var out bytes.Buffer
+	ok, err := bus.conform(&out, filename, filename, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("trace does not conform to itself:", out.String())
+	}
+	out.Reset()
+	ok, err = bus.conform(&out, filename, "", "", "ref/ref-enable.bin", "")
+	if err != nil {
+		t.Fatal(err)
+	} else if ok || !strings.Contains(out.String(), "ref=3695") {
+		t.Error("unexpected chip select comparison:", out.String())
+	}
+}
+
+// writeCapture writes the SPI signals of the trace records as Saleae digital
+// files in dir, as captured with a bus driven by the signal-analyze example
+// SPI implementation: little endian command, data and status words on a shared data line.
+func writeCapture(t *testing.T, dir string, records []cywtrace.Record) (sdio, enable, clk string) {
+	t.Helper()
+	const halfPeriod = 1e-6
+	var (
+		sd       = saleae.DigitalFile{Header: saleae.DigitalHeader{InitialState: 0}}
+		cs       = saleae.DigitalFile{Header: saleae.DigitalHeader{InitialState: 1}}
+		sck      = saleae.DigitalFile{Header: saleae.DigitalHeader{InitialState: 0}}
+		level    bool
+		now      float64
+		transfer []byte
+	)
+	for _, rec := range records {
+		if rec.Op == cywtrace.OpPower {
+			continue
+		}
+		transfer = binary.LittleEndian.AppendUint32(transfer[:0], rec.Cmd)
+		for _, word := range rec.Data {
+			transfer = binary.LittleEndian.AppendUint32(transfer, word)
+		}
+		transfer = binary.LittleEndian.AppendUint32(transfer, rec.Status)
+		cs.Data = append(cs.Data, now)
+		now += halfPeriod
+		for _, b := range transfer {
+			for bit := 7; bit >= 0; bit-- {
+				if bitLevel := b&(1<<bit) != 0; bitLevel != level {
+					sd.Data = append(sd.Data, now)
+					level = bitLevel
+				}
+				now += halfPeriod / 2
+				sck.Data = append(sck.Data, now, now+halfPeriod)
+				now += 2 * halfPeriod
+			}
+		}
+		cs.Data = append(cs.Data, now)
+		now += halfPeriod
+	}
+	// Trailing transitions so the analyzer reaches the last edges.
+	sd.Data = append(sd.Data, now, now+halfPeriod)
+	write := func(name string, df *saleae.DigitalFile) string {
+		df.Header.Begin, df.Header.End = 0, now+halfPeriod
+		df.Header.NumTransitions = uint64(len(df.Data))
+		filename := filepath.Join(dir, name)
+		fp, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fp.Close()
+		if _, err = df.WriteTo(fp); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	return write("sdio.bin", &sd), write("enable.bin", &cs), write("clk.bin", &sck)
+}
+
+func TestConformCapture(t *testing.T) {
+	_, _, trace := recordInit(t)
+	records, err := cywtrace.Decode(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "init.cywt")
+	err = os.WriteFile(filename, trace.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdio, enable, clk := writeCapture(t, dir, records)
+	bus := BusCtl{Order: binary.LittleEndian, WordInterpreter: binary.LittleEndian}
+
+	// Commands sent before the bus is set to 32 bit words are unswapped once.
+	commands, err := bus.processSpiFiles(sdio, clk, enable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accs := cywtrace.Accesses(bus.records(commands))
+	if len(accs) == 0 || accs[0].Fn != 0 || accs[0].Addr != whd.SPI_READ_TEST_REGISTER || accs[0].Write {
+		t.Fatalf("want test register read first, got %+v", accs[:min(len(accs), 1)])
+	}
+
+	var out bytes.Buffer
+	ok, err := bus.conform(&out, filename, "", sdio, enable, clk)
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("trace does not conform to its capture:", out.String())
+	}
+	out.Reset()
+	ok, err = bus.conform(&out, filename, "", "", enable, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("transaction count differs from capture:", out.String())
+	}
+
+	// A differing write is reported.
+	for i := range records {
+		rec := &records[i]
+		if rec.Op == cywtrace.OpWrite && rec.Cmd>>28&0b11 == 2 && len(rec.Data) > 4 {
+			rec.Data[4] ^= 1
+			break
+		}
+	}
+	sdio, enable, clk = writeCapture(t, dir, records)
+	out.Reset()
+	ok, err = bus.conform(&out, filename, "", sdio, enable, clk)
+	if err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("modified capture conforms to trace")
+	}
+}
+
+func TestDecodeFrames(t *testing.T) {
+	dev, rec, trace := recordInit(t)
+	err := dev.Join(testNet.SSID, cyw43439.JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 60)
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x43, 0x94, 0x39, 0x08, 0x06})
+	err = dev.SendEth(frame)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := cywtrace.Decode(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = decodeFrames(&out, transactions(records))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		`host>chip seq=`,
+		`ioctl set SET_VAR id=`,
+		`iovar="bsscfg:event_msgs"`,
+		`resp  get GET_VAR`,
+		`event SET_SSID status=SUCCESS`,
+		`event LINK status=SUCCESS`,
+		`eth 02:00:00:43:94:39>ff:ff:ff:ff:ff:ff ARP len=60`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in decoded frames", want)
+		}
+	}
+	if strings.Contains(got, "invalid") || strings.Contains(got, "short") {
+		t.Error("undecoded frames:\n", got)
+	}
+
+	// Header length past the end of a frame with a valid size.
+	bad := sdpcmFrame{Data: make([]byte, 20)}
+	hdr := whd.SDPCMHeader{Size: 20, SizeCom: ^uint16(20), HeaderLength: 200}
+	hdr.Put(binary.LittleEndian, bad.Data)
+	if got := decodeFrame(&bad); !strings.Contains(got, "invalid sdpcm header length 200") {
+		t.Errorf("want invalid header length, got %q", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tx := func(fn Function, write bool, addr uint32, start float64) cywtx {
+		return cywtx{Num: 1, Cmd: CYW43439Cmd{Fn: fn, Write: write, Addr: addr, Size: 4}, Start: start}
+	}
+	for _, test := range []struct {
+		expr string
+		tx   cywtx
+		want bool
+	}{
+		{expr: "", tx: tx(FuncBus, false, 0x14, 1), want: true},
+		{expr: "fn=wlan", tx: tx(FuncBus, false, 0x14, 1), want: false},
+		{expr: "fn=bus|wlan", tx: tx(FuncBus, false, 0x14, 1), want: true},
+		{expr: "dir=w", tx: tx(FuncBus, false, 0x14, 1), want: false},
+		{expr: "addr=0x1000a-0x1000e", tx: tx(FuncBackplane, true, 0x1000e, 1), want: true},
+		{expr: "addr=0x1000a", tx: tx(FuncBackplane, true, 0x1000e, 1), want: false},
+		{expr: "t=0.5-1.5, fn=backplane", tx: tx(FuncBackplane, true, 0x1000e, 1), want: true},
+		{expr: "t=1.5-", tx: tx(FuncBackplane, true, 0x1000e, 1), want: false},
+	} {
+		f, err := parseFilter(test.expr)
+		if err != nil {
+			t.Fatalf("%q: %s", test.expr, err)
+		}
+		if got := f.match(&test.tx); got != test.want {
+			t.Errorf("%q: got match %v, want %v", test.expr, got, test.want)
+		}
+	}
+	for _, expr := range []string{"fn=sdio", "dir=x", "addr=zz", "t=a-b", "size=4", "fn"} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	txs := []cywtx{
+		{Num: 2, Cmd: CYW43439Cmd{Fn: FuncBus, Addr: 0x14, Size: 4}, Data: []byte{0xad, 0xbe, 0xed, 0xfe}, Start: 1, End: 1.5, Busy: 0.75},
+		{Num: 1, Cmd: CYW43439Cmd{Write: true, Fn: FuncWLAN, Size: 2}, Data: []byte{0x01, 0x02}, Start: 2, End: 3, Busy: 1, Status: 0x20, HasStatus: true},
+	}
+	bus := BusCtl{Format: formatJSONL, Filter: txFilter{fns: 1 << FuncWLAN}}
+	var out bytes.Buffer
+	selected, err := bus.writeCommands(&out, nil, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantJSON = `{"index":1,"count":1,"start":2,"end":3,"fn":"wlan","addr":0,"size":2,"dir":"w","data":"0102","status":32}` + "\n"
+	if len(selected) != 1 || out.String() != wantJSON {
+		t.Errorf("got JSON %q, want %q", out.String(), wantJSON)
+	}
+
+	bus = BusCtl{Format: formatCSV}
+	out.Reset()
+	_, err = bus.writeCommands(&out, nil, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantCSV = "index,count,start,end,fn,addr,size,dir,data,status\n" +
+		"0,2,1,1.5,bus,0x14,4,r,adbeedfe,\n" +
+		"1,1,2,3,wlan,0x0,2,w,0102,0x20\n"
+	if out.String() != wantCSV {
+		t.Errorf("got CSV %q, want %q", out.String(), wantCSV)
+	}
+
+	out.Reset()
+	err = writeStats(&out, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"transactions: 3", "bus               2          8", "wlan              1          2", "utilisation: 87.50%", "max=0.5s"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in stats:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Output formats of command transactions.
+const (
+	formatText  = "text"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+// txFilter selects transactions by function, direction, address range and time window.
+// The zero value selects all transactions.
+type txFilter struct {
+	fns            uint8 // Bitmask of selected functions, zero selects all.
+	dir            byte  // 'r' or 'w' to select reads or writes, zero selects both.
+	addrLo, addrHi uint32
+	hasAddr        bool
+	tLo, tHi       float64
+	hasTime        bool
+}
+
+// parseFilter parses a comma separated list of filter terms:
+//
+//	fn=bus|backplane|wlan|dma2  Function, several may be separated by '|'.
+//	dir=r|w                     Read or write transactions.
+//	addr=0x1000a[-0x1000e]      Address or inclusive address range in hexadecimal.
+//	t=1.5-2.25                  Time window in seconds, either bound may be omitted.
+func parseFilter(expr string) (f txFilter, err error) {
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(term, "=")
+		if !ok {
+			return f, fmt.Errorf("filter term %q missing '='", term)
+		}
+		switch key {
+		case "fn":
+			for _, name := range strings.Split(value, "|") {
+				fn, ok := parseFunction(name)
+				if !ok {
+					return f, fmt.Errorf("invalid filter function %q", name)
+				}
+				f.fns |= 1 << fn
+			}
+		case "dir":
+			if value != "r" && value != "w" {
+				return f, fmt.Errorf("invalid filter direction %q, expected r or w", value)
+			}
+			f.dir = value[0]
+		case "addr":
+			lo, hi, isRange := strings.Cut(value, "-")
+			f.addrLo, err = parseHex(lo)
+			if err != nil {
+				return f, err
+			}
+			f.addrHi = f.addrLo
+			if isRange {
+				f.addrHi, err = parseHex(hi)
+				if err != nil {
+					return f, err
+				}
+			}
+			f.hasAddr = true
+		case "t":
+			lo, hi, _ := strings.Cut(value, "-")
+			f.tLo, f.tHi = math.Inf(-1), math.Inf(1)
+			if lo != "" {
+				f.tLo, err = strconv.ParseFloat(lo, 64)
+			}
+			if err == nil && hi != "" {
+				f.tHi, err = strconv.ParseFloat(hi, 64)
+			}
+			if err != nil {
+				return f, fmt.Errorf("invalid filter time window %q: %w", value, err)
+			}
+			f.hasTime = true
+		default:
+			return f, fmt.Errorf("unknown filter key %q", key)
+		}
+	}
+	return f, nil
+}
+
+func parseFunction(name string) (Function, bool) {
+	for _, fn := range [...]Function{FuncBus, FuncBackplane, FuncWLAN, FuncDMA2} {
+		if fn.String() == name {
+			return fn, true
+		}
+	}
+	return 0, false
+}
+
+func parseHex(s string) (uint32, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid filter address %q: %w", s, err)
+	}
+	return uint32(v), nil
+}
+
+func (f *txFilter) match(tx *cywtx) bool {
+	switch {
+	case f.fns != 0 && (tx.Cmd.Fn > FuncDMA2 || f.fns&(1<<tx.Cmd.Fn) == 0):
+		return false
+	case f.dir == 'r' && tx.Cmd.Write, f.dir == 'w' && !tx.Cmd.Write:
+		return false
+	case f.hasAddr && (tx.Cmd.Addr < f.addrLo || tx.Cmd.Addr > f.addrHi):
+		return false
+	case f.hasTime && !(tx.Start >= f.tLo && tx.Start <= f.tHi):
+		return false
+	}
+	return true
+}
+
+// txRecord is the structured representation of a transaction.
+type txRecord struct {
+	Index  int     `json:"index"`
+	Count  int     `json:"count"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Fn     string  `json:"fn"`
+	Addr   uint32  `json:"addr"`
+	Size   uint32  `json:"size"`
+	Dir    string  `json:"dir"`
+	Data   string  `json:"data"`
+	Status *uint32 `json:"status,omitempty"`
+}
+
+var csvHeader = []string{"index", "count", "start", "end", "fn", "addr", "size", "dir", "data", "status"}
+
+func newTxRecord(index int, tx *cywtx) txRecord {
+	rec := txRecord{
+		Index: index,
+		Count: tx.Num,
+		Start: finite(tx.Start),
+		End:   finite(tx.End),
+		Fn:    tx.Cmd.Fn.String(),
+		Addr:  tx.Cmd.Addr,
+		Size:  tx.Cmd.Size,
+		Dir:   "r",
+		Data:  hex.EncodeToString(tx.Data),
+	}
+	if tx.Cmd.Write {
+		rec.Dir = "w"
+	}
+	if tx.HasStatus {
+		rec.Status = &tx.Status
+	}
+	return rec
+}
+
+// finite replaces undefined times by zero, which JSON can't encode.
+func finite(t float64) float64 {
+	if math.IsNaN(t) || math.IsInf(t, 0) {
+		return 0
+	}
+	return t
+}
+
+func (rec *txRecord) csv() []string {
+	status := ""
+	if rec.Status != nil {
+		status = "0x" + strconv.FormatUint(uint64(*rec.Status), 16)
+	}
+	return []string{
+		strconv.Itoa(rec.Index),
+		strconv.Itoa(rec.Count),
+		strconv.FormatFloat(rec.Start, 'f', -1, 64),
+		strconv.FormatFloat(rec.End, 'f', -1, 64),
+		rec.Fn,
+		"0x" + strconv.FormatUint(uint64(rec.Addr), 16),
+		strconv.FormatUint(uint64(rec.Size), 10),
+		rec.Dir,
+		rec.Data,
+		status,
+	}
+}
+
+// txWriter writes transactions in a structured format.
+type txWriter struct {
+	json *json.Encoder
+	csv  *csv.Writer
+}
+
+func newTxWriter(w io.Writer, format string) (*txWriter, error) {
+	switch format {
+	case formatJSONL:
+		return &txWriter{json: json.NewEncoder(w)}, nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		return &txWriter{csv: cw}, cw.Write(csvHeader)
+	}
+	return nil, errors.New("invalid output format " + strconv.Quote(format))
+}
+
+func (tw *txWriter) write(index int, tx *cywtx) error {
+	rec := newTxRecord(index, tx)
+	if tw.json != nil {
+		return tw.json.Encode(&rec)
+	}
+	return tw.csv.Write(rec.csv())
+}
+
+func (tw *txWriter) flush() error {
+	if tw.csv == nil {
+		return nil
+	}
+	tw.csv.Flush()
+	return tw.csv.Error()
+}
+
+// writeStats writes summary statistics of txs to w: transaction and byte counts
+// per function, bus utilisation and gaps between transactions.
+func writeStats(w io.Writer, txs []cywtx) error {
+	var (
+		counts, nbytes [FuncDMA2 + 1]int
+		total, other   int
+		busy           float64
+		start, end     = math.Inf(1), math.Inf(-1)
+		gaps           int
+		gapSum         float64
+		gapMin         = math.Inf(1)
+		gapMax         = math.Inf(-1)
+		gapMaxAt       int
+		prevEnd        = math.NaN()
+	)
+	for i := range txs {
+		tx := &txs[i]
+		total += tx.Num
+		if tx.Cmd.Fn <= FuncDMA2 {
+			counts[tx.Cmd.Fn] += tx.Num
+			nbytes[tx.Cmd.Fn] += tx.Num * len(tx.Data)
+		} else {
+			other += tx.Num
+		}
+		if math.IsNaN(tx.Start) || math.IsNaN(tx.End) {
+			continue
+		}
+		busy += tx.Busy
+		start = math.Min(start, tx.Start)
+		end = math.Max(end, tx.End)
+		if gap := tx.Start - prevEnd; !math.IsNaN(gap) {
+			gaps++
+			gapSum += gap
+			gapMin = math.Min(gapMin, gap)
+			if gap > gapMax {
+				gapMax, gapMaxAt = gap, i
+			}
+		}
+		prevEnd = tx.End
+	}
+	fmt.Fprintf(w, "transactions: %d\n", total)
+	fmt.Fprintf(w, "%-10s %8s %10s\n", "function", "count", "bytes")
+	for fn := FuncBus; fn <= FuncDMA2; fn++ {
+		fmt.Fprintf(w, "%-10s %8d %10d\n", fn.String(), counts[fn], nbytes[fn])
+	}
+	if other > 0 {
+		fmt.Fprintf(w, "%-10s %8d\n", funcInvalid.String(), other)
+	}
+	if span := end - start; span > 0 {
+		fmt.Fprintf(w, "span: %.6fs busy: %.6fs utilisation: %.2f%%\n", span, busy, 100*busy/span)
+	}
+	if gaps > 0 && gapMax > 0 {
+		_, err := fmt.Fprintf(w, "gaps: min=%.3gs mean=%.3gs max=%.3gs ending at t=%f\n",
+			gapMin, gapSum/float64(gaps), gapMax, txs[gapMaxAt].Start)
+		return err
+	}
+	return nil
+}
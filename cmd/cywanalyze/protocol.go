@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/soypat/cyw43439/cywtrace"
+	"github.com/soypat/cyw43439/whd"
+	"github.com/soypat/seqs/eth"
+)
+
+// sdpcmFrame is an SDPCM frame reassembled from WLAN function transfers.
+type sdpcmFrame struct {
+	// Index of the transaction the frame starts in.
+	Index  int
+	Start  float64
+	ToChip bool
+	Data   []byte
+}
+
+// reassembleF2 reassembles the WLAN function (F2) transfers of txs into SDPCM frames.
+// Frames spanning several transfers are joined and padding after the SDPCM size is dropped.
+func reassembleF2(txs []cywtx) (frames []sdpcmFrame) {
+	var pending [2]*sdpcmFrame // Indexed by direction, 1 for host to chip.
+	for i, tx := range txs {
+		if tx.Cmd.Fn != FuncWLAN {
+			continue
+		}
+		data := tx.Data
+		if uint32(len(data)) > tx.Cmd.Size {
+			data = data[:tx.Cmd.Size] // Trailing status word or garbage.
+		}
+		dir := b2u32(tx.Cmd.Write)
+		frame := pending[dir]
+		if frame != nil && isFrameStart(data) {
+			frames = append(frames, *frame) // Incomplete frame.
+			frame = nil
+		}
+		if frame == nil {
+			frame = &sdpcmFrame{Index: i, Start: tx.Start, ToChip: tx.Cmd.Write}
+		}
+		for n := 0; n < max(tx.Num, 1); n++ {
+			frame.Data = append(frame.Data, data...)
+		}
+		pending[dir] = nil
+		if len(frame.Data) < whd.SDPCM_HEADER_LEN {
+			pending[dir] = frame
+			continue
+		}
+		hdr := whd.DecodeSDPCMHeader(binary.LittleEndian, frame.Data)
+		switch {
+		case hdr.Size != ^hdr.SizeCom || hdr.Size < whd.SDPCM_HEADER_LEN:
+			// Not a frame start or an empty read, keep as is for reporting.
+		case int(hdr.Size) > len(frame.Data):
+			pending[dir] = frame
+			continue
+		default:
+			frame.Data = frame.Data[:hdr.Size]
+		}
+		frames = append(frames, *frame)
+	}
+	for _, frame := range pending {
+		if frame != nil {
+			frames = append(frames, *frame)
+		}
+	}
+	return frames
+}
+
+func isFrameStart(data []byte) bool {
+	if len(data) < whd.SDPCM_HEADER_LEN {
+		return false
+	}
+	hdr := whd.DecodeSDPCMHeader(binary.LittleEndian, data)
+	return hdr.Size == ^hdr.SizeCom && hdr.Size >= whd.SDPCM_HEADER_LEN
+}
+
+// decodeFrames writes a line per SDPCM frame in txs decoding ioctls, events and ethernet frames.
+func decodeFrames(w io.Writer, txs []cywtx) error {
+	for _, frame := range reassembleF2(txs) {
+		dir := "chip>host"
+		if frame.ToChip {
+			dir = "host>chip"
+		}
+		_, err := fmt.Fprintf(w, "tx=%-6d t=%f %s %s\n", frame.Index, frame.Start, dir, decodeFrame(&frame))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeFrame(frame *sdpcmFrame) string {
+	if len(frame.Data) < whd.SDPCM_HEADER_LEN {
+		return fmt.Sprintf("short sdpcm frame len=%d data=%#x", len(frame.Data), frame.Data)
+	}
+	hdr := whd.DecodeSDPCMHeader(binary.LittleEndian, frame.Data)
+	if int(hdr.HeaderLength) > len(frame.Data) {
+		return fmt.Sprintf("invalid sdpcm header length %d", hdr.HeaderLength)
+	}
+	payload, err := hdr.Parse(frame.Data)
+	if err != nil {
+		return fmt.Sprintf("invalid sdpcm frame len=%d: %s", len(frame.Data), err)
+	}
+	s := fmt.Sprintf("seq=%-3d credit=%-3d %-7s ", hdr.Seq, hdr.BusDataCredit, hdr.Type().String())
+	switch hdr.Type() {
+	case whd.CONTROL_HEADER:
+		return s + decodeControl(payload, frame.ToChip)
+	case whd.ASYNCEVENT_HEADER:
+		return s + decodeEvent(payload)
+	case whd.DATA_HEADER:
+		return s + decodeData(payload)
+	}
+	return s + fmt.Sprintf("len=%d", len(payload))
+}
+
+func decodeControl(packet []byte, request bool) string {
+	if len(packet) < whd.CDC_HEADER_LEN {
+		return fmt.Sprintf("short cdc len=%d", len(packet))
+	}
+	cdc := whd.DecodeCDCHeader(binary.LittleEndian, packet)
+	data, err := cdc.Parse(packet)
+	if err != nil {
+		return fmt.Sprintf("invalid cdc %s len=%d: %s", cdc.Cmd.String(), cdc.Length, err)
+	}
+	data = data[:cdc.Length]
+	kind := "get"
+	if cdc.Flags&whd.SDPCM_SET != 0 {
+		kind = "set"
+	}
+	iface := (cdc.Flags >> whd.CDCF_IOC_IF_SHIFT) & 0xf
+	s := fmt.Sprintf("ioctl %s %s id=%d if=%d len=%d", kind, cdc.Cmd.String(), cdc.ID, iface, cdc.Length)
+	if !request {
+		s = "resp  " + s[len("ioctl "):] + fmt.Sprintf(" status=%d", int32(cdc.Status))
+	}
+	isVar := cdc.Cmd == whd.WLC_GET_VAR || cdc.Cmd == whd.WLC_SET_VAR
+	if isVar && (request || cdc.Cmd == whd.WLC_SET_VAR) {
+		name, value, _ := bytes.Cut(data, []byte{0})
+		return s + fmt.Sprintf(" iovar=%q value=%s", name, hexSummary(value))
+	}
+	return s + " data=" + hexSummary(data)
+}
+
+func decodeEvent(packet []byte) string {
+	payload, err := bdcPayload(packet)
+	if err != nil {
+		return err.Error()
+	}
+	ev, err := whd.DecodeEventPacket(binary.BigEndian, payload)
+	if err != nil {
+		return fmt.Sprintf("invalid event len=%d: %s", len(payload), err)
+	}
+	msg := &ev.Message
+	return fmt.Sprintf("event %s status=%s reason=%d if=%d addr=%s datalen=%d",
+		msg.EventType.String(), estatusString(whd.EStatus(msg.Status)), msg.Reason, msg.IFIdx, net.HardwareAddr(msg.Addr[:]), msg.DataLen)
+}
+
+func decodeData(packet []byte) string {
+	payload, err := bdcPayload(packet)
+	if err != nil {
+		return err.Error()
+	}
+	if len(payload) < 14 {
+		return fmt.Sprintf("short ethernet frame len=%d", len(payload))
+	}
+	ehdr := eth.DecodeEthernetHeader(payload)
+	s := fmt.Sprintf("if=%d eth %s>%s %s len=%d", packet[2]&whd.BDC_FLAG2_IF_MASK,
+		net.HardwareAddr(ehdr.Source[:]), net.HardwareAddr(ehdr.Destination[:]), ehdr.AssertType().String(), len(payload))
+	if ehdr.AssertType() == eth.EtherTypeIPv4 && len(payload) >= 14+20 {
+		ip, _ := eth.DecodeIPv4Header(payload[14:])
+		s += " ip " + ip.String()
+	}
+	return s
+}
+
+func bdcPayload(packet []byte) ([]byte, error) {
+	if len(packet) < whd.BDC_HEADER_LEN {
+		return nil, fmt.Errorf("short bdc len=%d", len(packet))
+	}
+	bdc := whd.DecodeBDCHeader(packet)
+	start := whd.BDC_HEADER_LEN + 4*int(bdc.DataOffset)
+	if start > len(packet) {
+		return nil, fmt.Errorf("invalid bdc data offset %d", bdc.DataOffset)
+	}
+	return packet[start:], nil
+}
+
+func estatusString(status whd.EStatus) string {
+	names := [...]string{
+		whd.EStatusSuccess:     "SUCCESS",
+		whd.EStatusFail:        "FAIL",
+		whd.EStatusTimeout:     "TIMEOUT",
+		whd.EStatusNoNetworks:  "NO_NETWORKS",
+		whd.EStatusAbort:       "ABORT",
+		whd.EStatusNoAck:       "NO_ACK",
+		whd.EStatusUnsolicited: "UNSOLICITED",
+		whd.EStatusAttempt:     "ATTEMPT",
+		whd.EStatusPartial:     "PARTIAL",
+		whd.EStatusNewscan:     "NEWSCAN",
+		whd.EStatusNewassoc:    "NEWASSOC",
+		whd.EStatus11hQuiet:    "11H_QUIET",
+		whd.EStatusSuppress:    "SUPPRESS",
+		whd.EStatusNochans:     "NOCHANS",
+		whd.EStatusCcxFastRoam: "CCXFASTRM",
+		whd.EStatusCsAbort:     "CS_ABORT",
+	}
+	if int(status) < len(names) {
+		return names[status]
+	}
+	return fmt.Sprintf("%d", uint32(status))
+}
+
+// hexSummary formats at most the first 16 bytes of data in hexadecimal.
+func hexSummary(data []byte) string {
+	const maxLen = 16
+	if len(data) > maxLen {
+		return fmt.Sprintf("%x…", data[:maxLen])
+	}
+	return fmt.Sprintf("%x", data)
+}
+
+// transactions converts trace records to transactions. Transactions are not timed,
+// commands sent before the bus is set to 32 bit words are unswapped and backplane
+// read padding is dropped as is done for captures.
+func transactions(records []cywtrace.Record) (txs []cywtx) {
+	wide := false
+	for _, rec := range records {
+		if rec.Op == cywtrace.OpPower {
+			wide = false
+			continue
+		}
+		words := rec.Data
+		if !wide {
+			rec.Cmd = swap16(rec.Cmd)
+			words = make([]uint32, len(rec.Data))
+			for i, word := range rec.Data {
+				words[i] = swap16(word)
+			}
+		}
+		write, fn, addr, size := rec.Fields()
+		if write && Function(fn) == FuncBus && addr == whd.SPI_BUS_CONTROL && len(words) > 0 {
+			wide = words[0]&whd.WORD_LENGTH_32 != 0
+		} else if Function(fn) == FuncBackplane && !write && len(words) > 1 {
+			words = words[1:]
+		}
+		data := make([]byte, 4*len(words))
+		for i, word := range words {
+			binary.LittleEndian.PutUint32(data[4*i:], word)
+		}
+		txs = append(txs, cywtx{
+			Num:  1,
+			Cmd:  CYW43439Cmd{Write: write, AutoInc: rec.Cmd&(1<<30) != 0, Fn: Function(fn), Addr: addr, Size: uint32(size)},
+			Data: data,
+			// Status of the bus after the transaction as reported by the driver.
+			Status:    rec.Status,
+			HasStatus: true,
+		})
+	}
+	return txs
+}
+
+func swap16(b uint32) uint32 {
+	return (b >> 16) | (b << 16)
+}
@@ -0,0 +1,170 @@
+package cyw43439
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+// unresponsive emulates firmware that never completes joins, scans and disassociations.
+type unresponsive struct {
+	join, scan, leave bool
+}
+
+func (u *unresponsive) onIoctl(io *cywemu.Ioctl) bool {
+	switch {
+	case io.Cmd == whd.WLC_SET_SSID:
+		return u.join
+	case io.Cmd == whd.WLC_DISASSOC:
+		return u.leave
+	case io.Cmd == whd.WLC_SET_VAR && io.Name == "escan":
+		return u.scan && binary.LittleEndian.Uint16(io.Data[4:]) != whd.WL_SCAN_ACTION_ABORT
+	}
+	return false
+}
+
+func TestInitContext(t *testing.T) {
+	chip := cywemu.New(cywemu.Config{Networks: []cywemu.Network{testOpenNet}})
+	dev := New(chip.Power, func(bool) {}, chip)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := dev.InitContext(ctx, testConfig())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want canceled error, got %v", err)
+	}
+	if dev.ctx != nil {
+		t.Error("context not cleared")
+	}
+	// Device initializes again after an aborted initialization.
+	err = dev.InitContext(context.Background(), testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Join(testOpenNet.SSID, JoinOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinContext(t *testing.T) {
+	emu := unresponsive{join: true}
+	cfg := testConfig()
+	cfg.JoinTimeout = 50 * time.Millisecond
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{Networks: []cywemu.Network{testOpenNet}, OnIoctl: emu.onIoctl}, cfg)
+
+	// Config.JoinTimeout applies when the join options do not set a timeout.
+	start := time.Now()
+	err := dev.Join(testOpenNet.SSID, JoinOptions{})
+	var jerr *JoinError
+	if !errors.As(err, &jerr) || jerr.Reason != JoinFailTimeout {
+		t.Fatalf("want join timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("join timeout of %s took %s", cfg.JoinTimeout, elapsed)
+	}
+
+	ndisassoc := countIoctls(chip, whd.WLC_DISASSOC, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = dev.JoinContext(ctx, testOpenNet.SSID, JoinOptions{Timeout: time.Hour})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if countIoctls(chip, whd.WLC_DISASSOC, "") == ndisassoc {
+		t.Error("aborted join not disassociated")
+	}
+	if dev.ctx != nil || dev.IsLinkUp() {
+		t.Error("context not cleared or link up after aborted join")
+	}
+
+	emu.join = false
+	if err := dev.JoinContext(context.Background(), testOpenNet.SSID, JoinOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaveContext(t *testing.T) {
+	emu := unresponsive{leave: true}
+	dev, _ := newTestDeviceConfig(t, cywemu.Config{Networks: []cywemu.Network{testOpenNet}, OnIoctl: emu.onIoctl}, testConfig())
+	if err := dev.Join(testOpenNet.SSID, JoinOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Leaving a network within a canceled join does not wait for the link down events.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	dev.ctx = ctx
+	start := time.Now()
+	err := dev.leave()
+	dev.clearContext()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= leaveTimeout {
+		t.Errorf("canceled leave took %s", elapsed)
+	}
+	if dev.IsLinkUp() {
+		t.Error("link up after leave")
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	emu := unresponsive{scan: true}
+	cfg := testConfig()
+	cfg.ScanTimeout = 50 * time.Millisecond
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{Networks: []cywemu.Network{testOpenNet}, OnIoctl: emu.onIoctl}, cfg)
+	var n int
+	onResult := func(ScanResult) { n++ }
+
+	err := dev.Scan(ScanOptions{}, onResult)
+	if !errors.Is(err, errScanTimeout) {
+		t.Fatalf("want scan timeout, got %v", err)
+	}
+
+	nescan := countIoctls(chip, whd.WLC_SET_VAR, "escan")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = dev.ScanContext(ctx, ScanOptions{}, onResult)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if countIoctls(chip, whd.WLC_SET_VAR, "escan") != nescan+2 {
+		t.Fatal("scan not aborted")
+	}
+	data := lastIoctlData(t, chip, whd.WLC_SET_VAR, "escan")
+	if action := binary.LittleEndian.Uint16(data[4:]); action != whd.WL_SCAN_ACTION_ABORT {
+		t.Errorf("last escan action %d, want abort", action)
+	}
+
+	emu.scan = false
+	err = dev.ScanContext(context.Background(), ScanOptions{}, onResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d scan results, want 1", n)
+	}
+}
+
+func TestStartAPContext(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := dev.StartAPContext(ctx, "emu-ap", APOptions{Passphrase: "password123"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want canceled error, got %v", err)
+	}
+	if _, up := chip.AP(); up || dev.apUp {
+		t.Error("access point up after canceled start")
+	}
+	err = dev.StartAPContext(context.Background(), "emu-ap", APOptions{Passphrase: "password123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, up := chip.AP(); !up {
+		t.Error("access point not up")
+	}
+}
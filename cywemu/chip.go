@@ -0,0 +1,512 @@
+// Package cywemu implements a software model of the CYW43439 as seen from its gSPI
+// bus so the driver can be exercised with go test on the host.
+//
+// The emulator models the gSPI registers (test pattern, bus control, interrupts and
+// status word), the backplane window and core reset bits, firmware and NVRAM upload
+// into a RAM image, the F2 SDPCM channel with flow control credits, ioctls and
+// asynchronous events. [Chip] implements the bus interface expected by cyw43439.New
+// on non-rp2040 builds:
+//
+//	chip := cywemu.New(cywemu.Config{Networks: []cywemu.Network{{SSID: "home"}}})
+//	dev := cyw43439.New(chip.Power, func(bool) {}, chip)
+//
+// A Chip is not safe for concurrent use. Events and frames may be injected between driver calls.
+package cywemu
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+const (
+	// RAMSize is the size of the emulated chip's RAM where firmware and NVRAM are uploaded.
+	RAMSize = 512 * 1024
+	// ChipID is the chip identifier read from the ChipCommon core.
+	ChipID = 0xa9af // 43439.
+
+	defaultCreditWindow = 8
+	spiRegTestRW        = 0x18
+	// maxFrameSize is the largest F2 frame length representable in the status word.
+	maxFrameSize = whd.STATUS_F2_PKT_LEN_MASK >> whd.STATUS_F2_PKT_LEN_SHIFT
+)
+
+const (
+	funcBus       = 0
+	funcBackplane = 1
+	funcWLAN      = 2
+)
+
+var order = binary.LittleEndian
+
+// Bus protocol violations.
+var (
+	errReadCmdWrite     = errors.New("write command issued as read")
+	errWriteCmdRead     = errors.New("read command issued as write")
+	errShortBuffer      = errors.New("buffer shorter than command size")
+	errBadFunction      = errors.New("unsupported bus function")
+	errBackplaneWindow  = errors.New("backplane access crosses window")
+	errF2NotReady       = errors.New("F2 accessed before firmware running")
+	errF2ReadLength     = errors.New("F2 read length does not match frame length")
+	errF2NoData         = errors.New("F2 read with no frame available")
+	errBadNVRAMMagic    = errors.New("core started with invalid NVRAM length magic")
+	errFrameTooLarge    = errors.New("frame to host exceeds status length field")
+	errTooManyViolation = errors.New("too many protocol violations")
+)
+
+// Config configures the emulated chip.
+type Config struct {
+	// MAC is the hardware address reported by the firmware. Zero selects 02:00:00:43:94:39.
+	MAC [6]byte
+	// Networks are the access points in range, reported by scans and joinable.
+	Networks []Network
+	// CreditWindow is the amount of frames the host may send ahead of the
+	// firmware. Zero selects 8.
+	CreditWindow uint8
+	// OnIoctl, if set, is called on every ioctl received before the built-in handling.
+	// If it returns true built-in handling is skipped and the ioctl's Response and
+	// Status fields are sent back to the host.
+	OnIoctl func(*Ioctl) bool
+}
+
+// Chip is an emulated CYW43439 connected to the host over gSPI.
+type Chip struct {
+	cfg Config
+	on  bool
+	// bus32 is set when the host configured 32 bit words. Until then
+	// commands and data are sent with their 16 bit halves swapped.
+	bus32   bool
+	busregs [0x20]byte
+	irq     uint16 // Latched interrupt bits, cleared by writing 1.
+	status  uint32 // Status of the last transaction.
+	window  uint32
+	csr     uint8
+	f1regs  map[uint32]byte
+	regs    map[uint32]byte // Backplane registers outside of RAM.
+	ram     []byte
+	running bool
+	fw      firmware
+	errs    []error
+}
+
+// New returns a powered off emulated chip. The driver powers it on during Init.
+func New(cfg Config) *Chip {
+	if cfg.MAC == [6]byte{} {
+		cfg.MAC = [6]byte{0x02, 0x00, 0x00, 0x43, 0x94, 0x39}
+	}
+	if cfg.CreditWindow == 0 {
+		cfg.CreditWindow = defaultCreditWindow
+	}
+	c := &Chip{cfg: cfg, ram: make([]byte, RAMSize)}
+	c.reset()
+	return c
+}
+
+// Power drives the chip's WL_REG_ON pin. Powering on a chip that was off resets it.
+func (c *Chip) Power(on bool) {
+	if on && !c.on {
+		c.reset()
+	}
+	c.on = on
+}
+
+func (c *Chip) reset() {
+	c.bus32 = false
+	c.busregs = [0x20]byte{}
+	c.irq = 0
+	c.status = 0
+	c.window = 0
+	c.csr = 0
+	c.running = false
+	c.f1regs = make(map[uint32]byte)
+	c.regs = make(map[uint32]byte)
+	clear(c.ram)
+	c.putReg(whd.CHIPCOMMON_BASE_ADDRESS, ChipID, 2)
+	// Cores come out of power on held in reset.
+	for _, base := range [...]uint32{whd.WLAN_ARMCM3_BASE_ADDRESS, whd.SOCSRAM_BASE_ADDRESS} {
+		c.putReg(whd.WRAPPER_REGISTER_OFFSET+base+whd.AI_RESETCTRL_OFFSET, whd.AIRC_RESET, 1)
+	}
+	c.fw.reset(c.cfg.CreditWindow)
+}
+
+// Running returns true if the firmware was booted by the host.
+func (c *Chip) Running() bool { return c.running }
+
+// ReadRAM copies the chip's RAM starting at addr into dst. Used to check
+// firmware and NVRAM uploaded by the host.
+func (c *Chip) ReadRAM(addr uint32, dst []byte) {
+	copy(dst, c.ram[min(addr, RAMSize):])
+}
+
+// NVRAM returns the NVRAM image uploaded by the host or nil if the length magic at the end of RAM is invalid.
+func (c *Chip) NVRAM() []byte {
+	nwords, ok := c.nvramWords()
+	if !ok {
+		return nil
+	}
+	start := RAMSize - 4 - 4*nwords
+	return append([]byte(nil), c.ram[start:RAMSize-4]...)
+}
+
+func (c *Chip) nvramWords() (uint32, bool) {
+	magic := order.Uint32(c.ram[RAMSize-4:])
+	nwords := magic & 0xffff
+	if magic>>16 != ^nwords&0xffff || nwords == 0 || 4*nwords > RAMSize-4 {
+		return 0, false
+	}
+	return nwords, true
+}
+
+// Err returns the protocol violations committed by the host, or nil if there were none.
+func (c *Chip) Err() error {
+	return errors.Join(c.errs...)
+}
+
+func (c *Chip) violation(err error, context string) {
+	const maxViolations = 32
+	if len(c.errs) == maxViolations {
+		c.errs = append(c.errs, errTooManyViolation)
+	} else if len(c.errs) < maxViolations {
+		c.errs = append(c.errs, errors.New("cywemu: "+context+": "+err.Error()))
+	}
+}
+
+// LastStatus returns the gSPI status word sent after the last transaction.
+func (c *Chip) LastStatus() uint32 { return c.status }
+
+// CmdRead executes a gSPI read command and stores the response in buf.
+func (c *Chip) CmdRead(cmd uint32, buf []uint32) error {
+	if !c.on {
+		clear(buf)
+		return nil
+	}
+	swapped := !c.bus32
+	if swapped {
+		cmd = swap16(cmd)
+	}
+	write, fn, addr, size := decodeCmd(cmd)
+	if write {
+		c.violation(errReadCmdWrite, "read "+hex(cmd))
+		return errReadCmdWrite
+	}
+	var err error
+	switch fn {
+	case funcBus:
+		if len(buf) == 0 || size > 4 {
+			err = errShortBuffer
+			break
+		}
+		v := c.busRead(addr, size)
+		if swapped {
+			v = swap16(v)
+		}
+		buf[0] = v
+	case funcBackplane:
+		// First word is the response delay padding.
+		if len(buf) < 1+int(size+3)/4 {
+			err = errShortBuffer
+			break
+		}
+		var data [whd.BUS_SPI_MAX_BACKPLANE_TRANSFER_SIZE]byte
+		if size > uint32(len(data)) {
+			err = errShortBuffer
+			break
+		}
+		err = c.bpAccess(false, addr, data[:size])
+		buf[0] = 0
+		putWords(buf[1:], data[:size])
+	case funcWLAN:
+		err = c.f2Read(size, buf)
+	default:
+		err = errBadFunction
+	}
+	if err != nil {
+		c.violation(err, "read "+hex(cmd))
+	}
+	c.status = c.statusWord()
+	return err
+}
+
+// CmdWrite executes a gSPI write command with the data in buf.
+func (c *Chip) CmdWrite(cmd uint32, buf []uint32) error {
+	if !c.on {
+		return nil
+	}
+	swapped := !c.bus32
+	if swapped {
+		cmd = swap16(cmd)
+	}
+	write, fn, addr, size := decodeCmd(cmd)
+	if !write {
+		c.violation(errWriteCmdRead, "write "+hex(cmd))
+		return errWriteCmdRead
+	}
+	var err error
+	if len(buf) < int(size+3)/4 {
+		err = errShortBuffer
+		fn = 0xff
+	}
+	switch fn {
+	case 0xff:
+	case funcBus:
+		if size > 4 {
+			err = errShortBuffer
+			break
+		}
+		v := buf[0]
+		if swapped {
+			v = swap16(v)
+		}
+		c.busWrite(addr, size, v)
+	case funcBackplane:
+		var data [whd.BUS_SPI_MAX_BACKPLANE_TRANSFER_SIZE]byte
+		if size > uint32(len(data)) {
+			err = errShortBuffer
+			break
+		}
+		getWords(data[:size], buf)
+		err = c.bpAccess(true, addr, data[:size])
+	case funcWLAN:
+		if !c.running {
+			err = errF2NotReady
+			break
+		}
+		frame := make([]byte, size)
+		getWords(frame, buf)
+		c.rxFrame(frame)
+	default:
+		err = errBadFunction
+	}
+	if err != nil {
+		c.violation(err, "write "+hex(cmd))
+	}
+	c.status = c.statusWord()
+	return err
+}
+
+func decodeCmd(cmd uint32) (write bool, fn uint8, addr, size uint32) {
+	write = cmd&(1<<31) != 0
+	fn = uint8(cmd>>28) & 0b11
+	addr = (cmd >> 11) & 0x1ffff
+	size = cmd & 0x7ff
+	return write, fn, addr, size
+}
+
+func (c *Chip) statusWord() uint32 {
+	var s uint32
+	if c.irq&whd.DATA_UNAVAILABLE != 0 {
+		s |= whd.STATUS_DATA_NOT_AVAILABLE
+	}
+	if c.running {
+		s |= whd.STATUS_F2_RX_READY
+	}
+	if len(c.fw.tohost) > 0 {
+		s |= whd.STATUS_F2_PKT_AVAILABLE | uint32(len(c.fw.tohost[0]))<<whd.STATUS_F2_PKT_LEN_SHIFT
+	}
+	return s
+}
+
+func (c *Chip) interrupts() uint16 {
+	irq := c.irq
+	if len(c.fw.tohost) > 0 {
+		irq |= whd.F2_PACKET_AVAILABLE
+	}
+	return irq
+}
+
+func (c *Chip) busRead(addr, size uint32) (v uint32) {
+	var b [4]byte
+	status := c.statusWord()
+	irq := c.interrupts()
+	for i := uint32(0); i < size; i++ {
+		a := addr + i
+		switch {
+		case a >= whd.SPI_INTERRUPT_REGISTER && a < whd.SPI_INTERRUPT_REGISTER+2:
+			b[i] = byte(irq >> (8 * (a - whd.SPI_INTERRUPT_REGISTER)))
+		case a >= whd.SPI_STATUS_REGISTER && a < whd.SPI_STATUS_REGISTER+4:
+			b[i] = byte(status >> (8 * (a - whd.SPI_STATUS_REGISTER)))
+		case a >= whd.SPI_READ_TEST_REGISTER && a < whd.SPI_READ_TEST_REGISTER+4:
+			b[i] = byte(uint32(whd.TEST_PATTERN) >> (8 * (a - whd.SPI_READ_TEST_REGISTER)))
+		case a < uint32(len(c.busregs)):
+			b[i] = c.busregs[a]
+		}
+	}
+	return order.Uint32(b[:])
+}
+
+func (c *Chip) busWrite(addr, size, v uint32) {
+	for i := uint32(0); i < size; i++ {
+		a := addr + i
+		bv := byte(v >> (8 * i))
+		switch {
+		case a >= whd.SPI_INTERRUPT_REGISTER && a < whd.SPI_INTERRUPT_REGISTER+2:
+			c.irq &^= uint16(bv) << (8 * (a - whd.SPI_INTERRUPT_REGISTER))
+		case a >= whd.SPI_STATUS_REGISTER && a < whd.SPI_STATUS_REGISTER+4,
+			a >= whd.SPI_READ_TEST_REGISTER && a < whd.SPI_READ_TEST_REGISTER+4:
+			// Read only.
+		case a < uint32(len(c.busregs)):
+			c.busregs[a] = bv
+		}
+	}
+	if c.busregs[whd.SPI_BUS_CONTROL]&whd.WORD_LENGTH_32 != 0 {
+		c.bus32 = true
+	}
+}
+
+// bpAccess reads or writes data over the backplane function at addr.
+func (c *Chip) bpAccess(write bool, addr uint32, data []byte) error {
+	if addr >= 0x10000 {
+		// Function 1 registers.
+		for i := range data {
+			if write {
+				c.f1Write(addr+uint32(i), data[i])
+			} else {
+				data[i] = c.f1Read(addr + uint32(i))
+			}
+		}
+		return nil
+	}
+	offset := addr & whd.BACKPLANE_ADDR_MASK
+	if offset+uint32(len(data)) > whd.BACKPLANE_ADDR_MASK+1 {
+		return errBackplaneWindow
+	}
+	phys := c.window | offset
+	for i := range data {
+		if write {
+			c.memWrite(phys+uint32(i), data[i])
+		} else {
+			data[i] = c.memRead(phys + uint32(i))
+		}
+	}
+	if write {
+		c.updateCores()
+	}
+	return nil
+}
+
+func (c *Chip) f1Read(addr uint32) byte {
+	switch addr {
+	case whd.SDIO_BACKPLANE_ADDRESS_LOW:
+		return byte(c.window >> 8)
+	case whd.SDIO_BACKPLANE_ADDRESS_MID:
+		return byte(c.window >> 16)
+	case whd.SDIO_BACKPLANE_ADDRESS_HIGH:
+		return byte(c.window >> 24)
+	case whd.SDIO_CHIP_CLOCK_CSR:
+		csr := c.csr &^ (whd.SBSDIO_ALP_AVAIL | whd.SBSDIO_HT_AVAIL)
+		if c.csr&whd.SBSDIO_ALP_AVAIL_REQ != 0 || c.running {
+			csr |= whd.SBSDIO_ALP_AVAIL
+		}
+		if c.running {
+			csr |= whd.SBSDIO_HT_AVAIL
+		}
+		return csr
+	}
+	return c.f1regs[addr]
+}
+
+func (c *Chip) f1Write(addr uint32, v byte) {
+	switch addr {
+	case whd.SDIO_BACKPLANE_ADDRESS_LOW:
+		c.window = (c.window &^ 0xff00) | uint32(v&0x80)<<8
+	case whd.SDIO_BACKPLANE_ADDRESS_MID:
+		c.window = (c.window &^ 0xff0000) | uint32(v)<<16
+	case whd.SDIO_BACKPLANE_ADDRESS_HIGH:
+		c.window = (c.window &^ 0xff000000) | uint32(v)<<24
+	case whd.SDIO_CHIP_CLOCK_CSR:
+		c.csr = v
+	default:
+		c.f1regs[addr] = v
+	}
+}
+
+func (c *Chip) memRead(addr uint32) byte {
+	if addr < RAMSize {
+		return c.ram[addr]
+	}
+	return c.regs[addr]
+}
+
+func (c *Chip) memWrite(addr uint32, v byte) {
+	if addr < RAMSize {
+		c.ram[addr] = v
+	} else {
+		c.regs[addr] = v
+	}
+}
+
+func (c *Chip) putReg(addr, v, size uint32) {
+	for i := uint32(0); i < size; i++ {
+		c.regs[addr+i] = byte(v >> (8 * i))
+	}
+}
+
+// updateCores boots or halts the WLAN core after its wrapper registers were written.
+func (c *Chip) updateCores() {
+	const wrapper = whd.WRAPPER_REGISTER_OFFSET + whd.WLAN_ARMCM3_BASE_ADDRESS
+	ioctrl := c.regs[wrapper+whd.AI_IOCTRL_OFFSET]
+	resetctrl := c.regs[wrapper+whd.AI_RESETCTRL_OFFSET]
+	up := resetctrl&whd.AIRC_RESET == 0 && ioctrl&(whd.SICF_FGC|whd.SICF_CLOCK_EN|whd.SICF_CPUHALT) == whd.SICF_CLOCK_EN
+	switch {
+	case up && !c.running:
+		if _, ok := c.nvramWords(); !ok {
+			c.violation(errBadNVRAMMagic, "boot")
+			return
+		}
+		c.running = true
+		c.fw.reset(c.cfg.CreditWindow)
+	case !up && c.running:
+		c.running = false
+	}
+}
+
+func (c *Chip) f2Read(size uint32, buf []uint32) error {
+	if !c.running {
+		clear(buf)
+		return errF2NotReady
+	}
+	if len(buf) < int(size+3)/4 {
+		return errShortBuffer
+	}
+	frame := c.fw.pop()
+	if frame == nil {
+		c.irq |= whd.DATA_UNAVAILABLE
+		clear(buf)
+		return errF2NoData
+	}
+	clear(buf)
+	putWords(buf, frame[:min(len(frame), int(size))])
+	if int(size) != len(frame) {
+		return errF2ReadLength
+	}
+	return nil
+}
+
+// putWords packs b into little endian words.
+func putWords(dst []uint32, b []byte) {
+	for i := 0; i < len(b); i += 4 {
+		var w [4]byte
+		copy(w[:], b[i:])
+		dst[i/4] = order.Uint32(w[:])
+	}
+}
+
+// getWords unpacks little endian words into b.
+func getWords(b []byte, src []uint32) {
+	for i := 0; i < len(b); i += 4 {
+		var w [4]byte
+		order.PutUint32(w[:], src[i/4])
+		copy(b[i:], w[:])
+	}
+}
+
+// swap16 swaps lowest 16 bits with highest 16 bits of a uint32.
+func swap16(b uint32) uint32 {
+	return (b >> 16) | (b << 16)
+}
+
+func hex(v uint32) string {
+	return "0x" + strconv.FormatUint(uint64(v), 16)
+}
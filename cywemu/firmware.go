@@ -0,0 +1,623 @@
+package cywemu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+// SDPCM channel protocol violations.
+var (
+	errSDPCMSize     = errors.New("SDPCM size does not match transfer length")
+	errSDPCMSeq      = errors.New("SDPCM sequence number out of order")
+	errSDPCMCredit   = errors.New("frame sent without credit")
+	errSDPCMChannel  = errors.New("unknown SDPCM channel")
+	errCDCLength     = errors.New("CDC length exceeds frame")
+	errCDCKind       = errors.New("invalid CDC ioctl kind")
+	errDataHeaderLen = errors.New("data frame header length is not 14")
+)
+
+const (
+	bssInfoSize   = 128
+	escanHdrSize  = 12
+	eventHdrsSize = 14 + 10 + 48 // Ethernet, event header and event message.
+	etherTypeBRCM = 0x886c
+	phyNoise      = -92
+	// bcmeBufTooShort is the ioctl status of requests with a buffer shorter than the firmware structure.
+	bcmeBufTooShort = 0xffff_fff2 // BCME_BUFTOOSHORT (-14).
+)
+
+// Network is an access point in range of the emulated chip.
+type Network struct {
+	SSID    string
+	BSSID   [6]byte
+	Channel uint8
+	// RSSI is the signal strength in dBm. Zero selects -50.
+	RSSI int16
+	// Passphrase is the WPA2 passphrase or, if WPA3 is set, the SAE password. Empty for open networks.
+	Passphrase string
+	// WPA3 makes the network advertise SAE instead of PSK key management.
+	WPA3 bool
+	// Hidden networks are only reported by scans for their SSID.
+	Hidden bool
+}
+
+func (n *Network) secure() bool { return n.Passphrase != "" }
+
+func (n *Network) rssi() int16 {
+	if n.RSSI == 0 {
+		return -50
+	}
+	return n.RSSI
+}
+
+// Ioctl is an ioctl received from the host.
+type Ioctl struct {
+	Cmd   whd.SDPCMCommand
+	Iface whd.IoctlInterface
+	// Set is true for SET ioctls and false for GET ioctls.
+	Set bool
+	// Name is the variable name of WLC_GET_VAR and WLC_SET_VAR ioctls.
+	Name string
+	// Data is the ioctl data following the variable name, if any.
+	Data []byte
+	// Response is the data returned to the host. It is truncated or zero padded to the request length.
+	Response []byte
+	// Status is the ioctl status returned to the host. Non-zero statuses fail the ioctl.
+	Status uint32
+}
+
+// Frame is an ethernet frame sent by the host.
+type Frame struct {
+	Iface whd.IoctlInterface
+	Data  []byte
+}
+
+// firmware is the state of the emulated firmware's SDPCM channel and WLAN.
+type firmware struct {
+	tohost  [][]byte
+	txSeq   uint8
+	rxSeq   uint8 // Next sequence number expected from host.
+	credit  uint8 // Maximum sequence number advertised to host.
+	window  uint8
+	ioctls  []Ioctl
+	frames  []Frame
+	vars    map[string][]byte
+	evmask  [24]byte
+	up      bool
+	wpaAuth uint32
+	pmk     string
+	sae     string
+	assoc   int // Index of the associated network, -1 if none.
+	apUp    bool
+	apSSID  string
+	channel uint8
+}
+
+func (fw *firmware) reset(window uint8) {
+	ioctls, frames := fw.ioctls, fw.frames
+	*fw = firmware{
+		credit:  1,
+		window:  window,
+		ioctls:  ioctls,
+		frames:  frames,
+		vars:    make(map[string][]byte),
+		assoc:   -1,
+		channel: 1,
+	}
+	for i := range fw.evmask {
+		fw.evmask[i] = 0xff
+	}
+}
+
+// pop dequeues the next frame to the host and stamps its sequence number and credit.
+func (fw *firmware) pop() []byte {
+	if len(fw.tohost) == 0 {
+		return nil
+	}
+	frame := fw.tohost[0]
+	fw.tohost = fw.tohost[1:]
+	fw.credit = fw.rxSeq + fw.window
+	frame[4] = fw.txSeq
+	frame[9] = fw.credit
+	fw.txSeq++
+	return frame
+}
+
+// Ioctls returns the ioctls received from the host since the chip was created.
+func (c *Chip) Ioctls() []Ioctl { return c.fw.ioctls }
+
+// Frames returns the ethernet frames sent by the host since the chip was created.
+func (c *Chip) Frames() []Frame { return c.fw.frames }
+
+// Associated returns the network the station interface is associated with.
+func (c *Chip) Associated() (Network, bool) {
+	if c.fw.assoc < 0 {
+		return Network{}, false
+	}
+	return c.cfg.Networks[c.fw.assoc], true
+}
+
+// AP returns the SSID of the access point and whether it is up.
+func (c *Chip) AP() (ssid string, up bool) { return c.fw.apSSID, c.fw.apUp }
+
+// InjectEthernet queues an ethernet frame received on iface to the host.
+func (c *Chip) InjectEthernet(iface whd.IoctlInterface, frame []byte) {
+	buf := make([]byte, whd.SDPCM_HEADER_LEN+whd.BDC_HEADER_LEN+len(frame))
+	bdc := whd.BDCHeader{Flags: 2 << 4, Flags2: uint8(iface)}
+	bdc.Put(buf[whd.SDPCM_HEADER_LEN:])
+	copy(buf[whd.SDPCM_HEADER_LEN+whd.BDC_HEADER_LEN:], frame)
+	c.queue(whd.DATA_HEADER, buf)
+}
+
+// QueueEvent queues an asynchronous event to the host. The message's
+// DataLen and interface fields are set from data and iface. Events disabled
+// by the host through the firmware event mask are dropped.
+func (c *Chip) QueueEvent(iface whd.IoctlInterface, msg whd.EventMessage, data []byte) {
+	ev := msg.EventType
+	if int(ev) >= 8*len(c.fw.evmask) || c.fw.evmask[ev/8]&(1<<(ev%8)) == 0 {
+		return
+	}
+	msg.Version = 2
+	msg.DataLen = uint32(len(data))
+	msg.IFIdx = uint8(iface)
+	msg.BSSCfgIdx = uint8(iface)
+	buf := make([]byte, whd.SDPCM_HEADER_LEN+whd.BDC_HEADER_LEN+eventHdrsSize+len(data))
+	bdc := whd.BDCHeader{Flags: 2 << 4, Flags2: uint8(iface)}
+	bdc.Put(buf[whd.SDPCM_HEADER_LEN:])
+	pkt := buf[whd.SDPCM_HEADER_LEN+whd.BDC_HEADER_LEN:]
+	// Ethernet header. Events are addressed to the host.
+	copy(pkt[0:6], c.cfg.MAC[:])
+	copy(pkt[6:12], msg.Addr[:])
+	binary.BigEndian.PutUint16(pkt[12:14], etherTypeBRCM)
+	hdr := whd.EventHeader{
+		Subtype:     32769, // BCMILCP_SUBTYPE_VENDOR_LONG
+		Length:      uint16(len(pkt) - 14),
+		OUI:         [3]byte{0x00, 0x10, 0x18},
+		UserSubtype: 1, // BCMILCP_BCM_SUBTYPE_EVENT
+	}
+	hdr.Put(binary.BigEndian, pkt[14:24])
+	msg.Put(binary.BigEndian, pkt[24:72])
+	copy(pkt[eventHdrsSize:], data)
+	c.queue(whd.ASYNCEVENT_HEADER, buf)
+}
+
+// Disconnect emulates the access point deauthenticating the station with an 802.11 reason code.
+func (c *Chip) Disconnect(reason uint16) {
+	n, ok := c.Associated()
+	if !ok {
+		return
+	}
+	c.fw.assoc = -1
+	c.event(whd.EvDEAUTH, whd.EStatusSuccess, uint32(reason), 0, n.BSSID, nil)
+	c.event(whd.EvLINK, whd.EStatusSuccess, 1, 0, n.BSSID, nil)
+}
+
+// event queues an event on the station interface.
+func (c *Chip) event(ev whd.AsyncEventType, status whd.EStatus, reason uint32, flags uint16, addr [6]byte, data []byte) {
+	c.QueueEvent(whd.IF_STA, whd.EventMessage{
+		EventType: ev,
+		Status:    uint32(status),
+		Reason:    reason,
+		Flags:     flags,
+		Addr:      addr,
+	}, data)
+}
+
+// queue finishes the SDPCM header of buf and queues it to the host.
+// The sequence number and credit are set when the host reads the frame.
+func (c *Chip) queue(channel whd.SDPCMHeaderType, buf []byte) {
+	if len(buf) > maxFrameSize {
+		c.violation(errFrameTooLarge, "queue "+strconv.Itoa(len(buf)))
+		return
+	}
+	hdr := whd.SDPCMHeader{
+		Size:         uint16(len(buf)),
+		SizeCom:      ^uint16(len(buf)),
+		ChanAndFlags: uint8(channel),
+		HeaderLength: whd.SDPCM_HEADER_LEN,
+	}
+	hdr.Put(order, buf)
+	c.fw.tohost = append(c.fw.tohost, buf)
+}
+
+// rxFrame processes a SDPCM frame sent by the host over F2.
+func (c *Chip) rxFrame(frame []byte) {
+	if len(frame) < whd.SDPCM_HEADER_LEN {
+		c.violation(errSDPCMSize, "rx")
+		return
+	}
+	hdr := whd.DecodeSDPCMHeader(order, frame)
+	if hdr.Size != ^hdr.SizeCom || int(hdr.Size) != len(frame) || int(hdr.HeaderLength) > len(frame) {
+		c.violation(errSDPCMSize, "rx")
+		return
+	}
+	fw := &c.fw
+	if hdr.Seq != fw.rxSeq {
+		c.violation(errSDPCMSeq, "rx seq "+strconv.Itoa(int(hdr.Seq))+" want "+strconv.Itoa(int(fw.rxSeq)))
+	}
+	if hdr.Seq == fw.credit || (fw.credit-hdr.Seq)&0x80 != 0 {
+		c.violation(errSDPCMCredit, "rx seq "+strconv.Itoa(int(hdr.Seq))+" credit "+strconv.Itoa(int(fw.credit)))
+	}
+	fw.rxSeq = hdr.Seq + 1
+	payload := frame[hdr.HeaderLength:]
+	switch hdr.Type() {
+	case whd.CONTROL_HEADER:
+		c.rxControl(payload)
+	case whd.DATA_HEADER:
+		if hdr.HeaderLength != whd.SDPCM_HEADER_LEN+2 {
+			c.violation(errDataHeaderLen, "rx data")
+			return
+		}
+		if len(payload) < whd.BDC_HEADER_LEN {
+			c.violation(errSDPCMSize, "rx data")
+			return
+		}
+		bdc := whd.DecodeBDCHeader(payload)
+		start := whd.BDC_HEADER_LEN + 4*int(bdc.DataOffset)
+		if start > len(payload) {
+			c.violation(errSDPCMSize, "rx data")
+			return
+		}
+		fw.frames = append(fw.frames, Frame{
+			Iface: whd.IoctlInterface(bdc.Flags2 & whd.BDC_FLAG2_IF_MASK),
+			Data:  append([]byte(nil), payload[start:]...),
+		})
+	default:
+		c.violation(errSDPCMChannel, "rx channel "+strconv.Itoa(int(hdr.Type())))
+	}
+}
+
+func (c *Chip) rxControl(payload []byte) {
+	if len(payload) < whd.CDC_HEADER_LEN {
+		c.violation(errCDCLength, "rx control")
+		return
+	}
+	cdc := whd.DecodeCDCHeader(order, payload)
+	data := payload[whd.CDC_HEADER_LEN:]
+	if int(cdc.Length) > len(data) {
+		c.violation(errCDCLength, "rx ioctl "+cdc.Cmd.String())
+		return
+	}
+	data = data[:cdc.Length]
+	kind := cdc.Flags & 0xf
+	if kind != whd.SDPCM_GET && kind != whd.SDPCM_SET {
+		c.violation(errCDCKind, "rx ioctl "+cdc.Cmd.String())
+		return
+	}
+	io := Ioctl{
+		Cmd:   cdc.Cmd,
+		Iface: whd.IoctlInterface(cdc.Flags >> whd.CDCF_IOC_IF_SHIFT),
+		Set:   kind == whd.SDPCM_SET,
+		Data:  append([]byte(nil), data...),
+	}
+	if io.Cmd == whd.WLC_GET_VAR || io.Cmd == whd.WLC_SET_VAR {
+		if nul := bytes.IndexByte(io.Data, 0); nul >= 0 {
+			io.Name = string(io.Data[:nul])
+			io.Data = io.Data[nul+1:]
+		}
+	}
+	var after func()
+	if c.cfg.OnIoctl == nil || !c.cfg.OnIoctl(&io) {
+		after = c.handleIoctl(&io)
+	}
+	// GET responses are the size of the request, SET responses echo the request.
+	resp := append([]byte(nil), data...)
+	if !io.Set {
+		clear(resp)
+		copy(resp, io.Response)
+	}
+	buf := make([]byte, whd.SDPCM_HEADER_LEN+whd.CDC_HEADER_LEN+len(resp))
+	rcdc := whd.CDCHeader{
+		Cmd:    cdc.Cmd,
+		Length: uint32(len(resp)),
+		Flags:  cdc.Flags,
+		ID:     cdc.ID,
+		Status: io.Status,
+	}
+	rcdc.Put(order, buf[whd.SDPCM_HEADER_LEN:])
+	copy(buf[whd.SDPCM_HEADER_LEN+whd.CDC_HEADER_LEN:], resp)
+	c.queue(whd.CONTROL_HEADER, buf)
+	c.fw.ioctls = append(c.fw.ioctls, io)
+	if after != nil {
+		// Events caused by the ioctl follow its response.
+		after()
+	}
+}
+
+// handleIoctl implements the ioctls the driver depends on. It returns a function
+// that queues the events caused by the ioctl, if any.
+func (c *Chip) handleIoctl(io *Ioctl) (after func()) {
+	fw := &c.fw
+	u32 := func(v uint32) []byte { return order.AppendUint32(nil, v) }
+	switch io.Cmd {
+	case whd.WLC_UP:
+		fw.up = true
+	case whd.WLC_DOWN:
+		fw.up = false
+	case whd.WLC_GET_UP:
+		io.Response = u32(b2u32(fw.up))
+	case whd.WLC_SET_WPA_AUTH:
+		if len(io.Data) >= 4 {
+			fw.wpaAuth = order.Uint32(io.Data)
+		}
+	case whd.WLC_SET_WSEC_PMK:
+		if len(io.Data) >= 4 {
+			n := min(int(order.Uint16(io.Data)), len(io.Data)-4)
+			fw.pmk = string(io.Data[4 : 4+n])
+		}
+	case whd.WLC_SET_CHANNEL:
+		if len(io.Data) >= 4 {
+			fw.channel = uint8(order.Uint32(io.Data))
+		}
+	case whd.WLC_SET_SSID:
+		if len(io.Data) < 36 {
+			io.Status = 1
+			break
+		}
+		ssid := ssidFrom(io.Data)
+		return func() { c.join(ssid, [6]byte{}, nil) }
+	case whd.WLC_DISASSOC:
+		if n, ok := c.Associated(); ok {
+			fw.assoc = -1
+			return func() { c.event(whd.EvLINK, whd.EStatusSuccess, 2, 0, n.BSSID, nil) }
+		}
+	case whd.WLC_GET_SSID:
+		var ssid [36]byte
+		if n, ok := c.Associated(); ok {
+			order.PutUint32(ssid[:], uint32(len(n.SSID)))
+			copy(ssid[4:], n.SSID)
+		}
+		io.Response = ssid[:]
+	case whd.WLC_GET_BSSID:
+		if n, ok := c.Associated(); ok {
+			io.Response = n.BSSID[:]
+		}
+	case whd.WLC_GET_CHANNEL:
+		if len(io.Data) < 12 {
+			io.Status = bcmeBufTooShort
+			break
+		}
+		ch := uint32(fw.channel)
+		if n, ok := c.Associated(); ok {
+			ch = uint32(n.Channel)
+		}
+		// channel_info_t: hw_channel, target_channel, scan_channel.
+		io.Response = append(append(u32(ch), u32(ch)...), u32(0)...)
+	case whd.WLC_GET_RSSI:
+		if n, ok := c.Associated(); ok {
+			io.Response = u32(uint32(int32(n.rssi())))
+		}
+	case whd.WLC_GET_PHY_NOISE:
+		io.Response = u32(uint32(noiseFloor()))
+	case whd.WLC_GET_VAR:
+		switch io.Name {
+		case "cur_etheraddr":
+			io.Response = c.cfg.MAC[:]
+		default:
+			io.Response = fw.vars[io.Name]
+		}
+	case whd.WLC_SET_VAR:
+		fw.vars[io.Name] = io.Data
+		return c.handleSetVar(io)
+	}
+	return nil
+}
+
+func (c *Chip) handleSetVar(io *Ioctl) (after func()) {
+	fw := &c.fw
+	switch io.Name {
+	case "bsscfg:event_msgs":
+		if len(io.Data) >= 4+len(fw.evmask) {
+			copy(fw.evmask[:], io.Data[4:])
+		}
+	case "sae_password":
+		if len(io.Data) >= 2 {
+			n := min(int(order.Uint16(io.Data)), len(io.Data)-2)
+			fw.sae = string(io.Data[2 : 2+n])
+		}
+	case "bsscfg:ssid":
+		if len(io.Data) >= 40 {
+			fw.apSSID = ssidFrom(io.Data[4:])
+		}
+	case "bss":
+		if len(io.Data) >= 8 {
+			fw.apUp = order.Uint32(io.Data[4:]) != 0
+		}
+	case "join":
+		// wl_extjoin_params_t: SSID, join scan parameters and assoc parameters with BSSID and chanspec list.
+		const assocOff, chanspecOff = 56, 68
+		if len(io.Data) < chanspecOff {
+			io.Status = 1
+			break
+		}
+		ssid := ssidFrom(io.Data)
+		bssid := [6]byte(io.Data[assocOff:])
+		nch := int(order.Uint32(io.Data[assocOff+8:]))
+		if chanspecOff+2*nch > len(io.Data) {
+			io.Status = 1
+			break
+		}
+		channels := chanspecChannels(io.Data[chanspecOff:], nch)
+		return func() { c.join(ssid, bssid, channels) }
+	case "escan":
+		const chanOff = 72
+		if len(io.Data) < chanOff {
+			io.Status = 1
+			break
+		}
+		action := order.Uint16(io.Data[4:])
+		syncID := order.Uint16(io.Data[6:])
+		if action == whd.WL_SCAN_ACTION_ABORT {
+			return func() { c.escanDone(syncID, whd.EStatusAbort) }
+		}
+		ssidLen := min(order.Uint32(io.Data[8:]), 32)
+		ssid := string(io.Data[12 : 12+ssidLen])
+		bssid := [6]byte(io.Data[44:])
+		nch := int(order.Uint32(io.Data[68:]))
+		if chanOff+2*nch > len(io.Data) {
+			io.Status = 1
+			break
+		}
+		channels := chanspecChannels(io.Data[chanOff:], nch)
+		return func() { c.escan(syncID, ssid, bssid, channels) }
+	}
+	return nil
+}
+
+// findNetwork returns the index of the strongest network matching the filters, or -1.
+func (c *Chip) findNetwork(ssid string, bssid [6]byte, channels []uint8, directed bool) int {
+	best := -1
+	for i := range c.cfg.Networks {
+		n := &c.cfg.Networks[i]
+		if !matchNetwork(n, ssid, bssid, channels, directed) {
+			continue
+		}
+		if best < 0 || n.rssi() > c.cfg.Networks[best].rssi() {
+			best = i
+		}
+	}
+	return best
+}
+
+func matchNetwork(n *Network, ssid string, bssid [6]byte, channels []uint8, directed bool) bool {
+	if (ssid != "" && n.SSID != ssid) || (n.Hidden && !directed) {
+		return false
+	}
+	if bssid != [6]byte{} && bssid != [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff} && bssid != n.BSSID {
+		return false
+	}
+	if len(channels) == 0 {
+		return true
+	}
+	return bytes.IndexByte(channels, n.Channel) >= 0
+}
+
+// join emulates the station joining a network and queues the events the firmware sends.
+func (c *Chip) join(ssid string, bssid [6]byte, channels []uint8) {
+	fw := &c.fw
+	fw.assoc = -1
+	idx := c.findNetwork(ssid, bssid, channels, true)
+	if idx < 0 {
+		c.event(whd.EvSET_SSID, whd.EStatusNoNetworks, 0, 0, [6]byte{}, nil)
+		return
+	}
+	n := &c.cfg.Networks[idx]
+	secure := fw.wpaAuth != whd.WPA_AUTH_DISABLED
+	c.event(whd.EvAUTH, whd.EStatusSuccess, 0, 0, n.BSSID, nil)
+	if secure != n.secure() {
+		// Security mismatch, access point rejects the association.
+		const statusUnspecified = 1
+		c.event(whd.EvASSOC, whd.EStatusFail, statusUnspecified, 0, n.BSSID, nil)
+		c.event(whd.EvSET_SSID, whd.EStatusFail, 0, 0, n.BSSID, nil)
+		return
+	}
+	c.event(whd.EvASSOC, whd.EStatusSuccess, 0, 0, n.BSSID, nil)
+	c.event(whd.EvLINK, whd.EStatusSuccess, 0, 1, n.BSSID, nil)
+	c.event(whd.EvJOIN, whd.EStatusSuccess, 0, 0, n.BSSID, nil)
+	c.event(whd.EvSET_SSID, whd.EStatusSuccess, 0, 0, n.BSSID, nil)
+	if secure {
+		key := fw.pmk
+		if n.WPA3 {
+			key = fw.sae
+		}
+		if key != n.Passphrase {
+			// 4-way handshake times out waiting for a valid message 2.
+			const reasonHandshakeTimeout = 15
+			c.event(whd.EvPSK_SUP, whd.EStatusPartial, reasonHandshakeTimeout, 0, n.BSSID, nil)
+			return
+		}
+		c.event(whd.EvPSK_SUP, whd.EStatusUnsolicited, 0, 0, n.BSSID, nil)
+	}
+	fw.assoc = idx
+}
+
+// escan queues a scan result event for every network matching the scan parameters.
+func (c *Chip) escan(syncID uint16, ssid string, bssid [6]byte, channels []uint8) {
+	for i := range c.cfg.Networks {
+		n := &c.cfg.Networks[i]
+		if !matchNetwork(n, ssid, bssid, channels, ssid != "") {
+			continue
+		}
+		c.QueueEvent(whd.IF_STA, whd.EventMessage{
+			EventType: whd.EvESCAN_RESULT,
+			Status:    uint32(whd.EStatusPartial),
+			Addr:      n.BSSID,
+		}, escanResult(syncID, n))
+	}
+	c.escanDone(syncID, whd.EStatusSuccess)
+}
+
+func (c *Chip) escanDone(syncID uint16, status whd.EStatus) {
+	var res [escanHdrSize]byte
+	order.PutUint32(res[0:], escanHdrSize)
+	order.PutUint16(res[8:], syncID)
+	c.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvESCAN_RESULT, Status: uint32(status)}, res[:])
+}
+
+// escanResult encodes a wl_escan_result_t with the bss info of a single network.
+func escanResult(syncID uint16, n *Network) []byte {
+	var ies []byte
+	capability := uint16(1) // ESS.
+	if n.secure() {
+		capability |= whd.DOT11_CAP_PRIVACY
+		akm := byte(2) // PSK.
+		if n.WPA3 {
+			akm = 8 // SAE.
+		}
+		ies = []byte{whd.DOT11_IE_ID_RSN, 20,
+			1, 0, // Version.
+			0x00, 0x0f, 0xac, 4, // Group cipher CCMP.
+			1, 0, 0x00, 0x0f, 0xac, 4, // Pairwise cipher CCMP.
+			1, 0, 0x00, 0x0f, 0xac, akm,
+			0, 0, // Capabilities.
+		}
+	}
+	buf := make([]byte, escanHdrSize+bssInfoSize+len(ies))
+	order.PutUint32(buf[0:], uint32(len(buf)))
+	order.PutUint32(buf[4:], 109) // WL_BSS_INFO_VERSION.
+	order.PutUint16(buf[8:], syncID)
+	order.PutUint16(buf[10:], 1) // bss_count.
+	bss := buf[escanHdrSize:]
+	order.PutUint32(bss[0:], 109)
+	order.PutUint32(bss[4:], uint32(bssInfoSize+len(ies)))
+	copy(bss[8:14], n.BSSID[:])
+	order.PutUint16(bss[14:], 100) // Beacon period.
+	order.PutUint16(bss[16:], capability)
+	bss[18] = byte(min(len(n.SSID), 32))
+	copy(bss[19:51], n.SSID)
+	order.PutUint16(bss[72:], whd.ChanSpec20(n.Channel))
+	order.PutUint16(bss[78:], uint16(n.rssi()))
+	bss[80] = byte(noiseFloor())
+	order.PutUint16(bss[116:], bssInfoSize)
+	order.PutUint32(bss[120:], uint32(len(ies)))
+	copy(bss[bssInfoSize:], ies)
+	return buf
+}
+
+// ssidFrom decodes a wlc_ssid_t.
+func ssidFrom(b []byte) string {
+	n := min(order.Uint32(b), 32)
+	return string(b[4 : 4+n])
+}
+
+func chanspecChannels(b []byte, n int) []uint8 {
+	channels := make([]uint8, n)
+	for i := range channels {
+		channels[i] = uint8(order.Uint16(b[2*i:]) & whd.WL_CHANSPEC_CHAN_MASK)
+	}
+	return channels
+}
+
+// noiseFloor returns the PHY noise floor reported by the firmware in dBm.
+func noiseFloor() int32 { return phyNoise }
+
+func b2u32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,439 @@
+package cywtrace
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+const (
+	// maxEdits bounds the amount of missing and extra accesses considered when aligning traces.
+	maxEdits = 4096
+	// f1Regs is the start of the SDIO F1 registers, lower function 1 addresses are in the backplane window.
+	f1Regs = 0x10000
+)
+
+var errDiverged = errors.New("cywtrace: traces diverge beyond " + strconv.Itoa(maxEdits) + " edits")
+
+// Access is a register, backplane or WLAN access decoded from a trace.
+type Access struct {
+	// Index of the record in the trace the access was decoded from.
+	Index int
+	// Count is the amount of consecutive identical reads merged into this access.
+	Count int
+	Write bool
+	Fn    uint8
+	// Addr is the register address or, if Backplane is set, the full backplane address.
+	Addr uint32
+	// Backplane is set for function 1 accesses through the backplane window.
+	Backplane bool
+	Size      uint16
+	// Data holds the words written. It is nil for reads.
+	Data []uint32
+}
+
+// Accesses decodes the accesses of a trace. Commands issued before the bus is set to
+// 32 bit words are unswapped, reads of the gSPI status register are dropped since they
+// depend on timing and consecutive identical reads, i.e. polls, are merged.
+func Accesses(records []Record) []Access {
+	var (
+		accs   []Access
+		window uint32
+		wide   bool
+	)
+	for i := range records {
+		rec := &records[i]
+		if rec.Op == OpPower {
+			wide = false
+			continue
+		}
+		cmd := rec.Cmd
+		if !wide {
+			cmd = swap16(cmd)
+		}
+		acc := Access{
+			Index: i,
+			Count: 1,
+			Write: cmd&(1<<31) != 0,
+			Fn:    uint8(cmd>>28) & 0b11,
+			Addr:  (cmd >> 11) & 0x1ffff,
+			Size:  uint16(cmd & 0x7ff),
+		}
+		if acc.Write {
+			acc.Data = make([]uint32, len(rec.Data))
+			for j, word := range rec.Data {
+				if !wide {
+					word = swap16(word)
+				}
+				acc.Data[j] = word
+			}
+		}
+		switch {
+		case acc.Fn == 0 && acc.Addr == whd.SPI_STATUS_REGISTER && !acc.Write:
+			continue
+		case acc.Fn == 0 && acc.Addr == whd.SPI_BUS_CONTROL && acc.Write && len(acc.Data) > 0:
+			wide = acc.Data[0]&whd.WORD_LENGTH_32 != 0
+		case acc.Fn == 1 && acc.Addr < f1Regs:
+			acc.Addr = window | acc.Addr&whd.BACKPLANE_ADDR_MASK
+			acc.Backplane = true
+		case acc.Fn == 1 && acc.Write && len(acc.Data) > 0:
+			window = setWindow(window, acc.Addr, acc.Size, acc.Data[0])
+		}
+		if n := len(accs); n > 0 && !acc.Write && accs[n-1].key() == acc.key() {
+			accs[n-1].Count++
+			continue
+		}
+		accs = append(accs, acc)
+	}
+	return accs
+}
+
+// setWindow applies a write to the backplane window address registers.
+func setWindow(window, addr uint32, size uint16, value uint32) uint32 {
+	for i := uint32(0); i < uint32(size) && i < 4; i++ {
+		b := (value >> (8 * i)) & 0xff
+		switch addr + i {
+		case whd.SDIO_BACKPLANE_ADDRESS_LOW:
+			window = window&^0xff00 | b<<8
+		case whd.SDIO_BACKPLANE_ADDRESS_MID:
+			window = window&^0xff0000 | b<<16
+		case whd.SDIO_BACKPLANE_ADDRESS_HIGH:
+			window = window&^0xff000000 | b<<24
+		}
+	}
+	return window &^ whd.BACKPLANE_ADDR_MASK
+}
+
+type accessKey struct {
+	write     bool
+	backplane bool
+	fn        uint8
+	addr      uint32
+	size      uint16
+}
+
+func (a *Access) key() accessKey {
+	return accessKey{write: a.Write, backplane: a.Backplane, fn: a.Fn, addr: a.Addr, size: a.Size}
+}
+
+// sameData compares the written bytes covered by the access size. Words past
+// the size, such as padding or a captured status word, are ignored.
+func (a *Access) sameData(b *Access) bool {
+	n := (int(a.Size) + 3) / 4
+	if n == 0 || n > len(a.Data) || n > len(b.Data) {
+		if len(a.Data) != len(b.Data) {
+			return false
+		}
+		n = len(a.Data)
+	}
+	for i := 0; i < n; i++ {
+		mask := ^uint32(0)
+		if rem := int(a.Size) - 4*i; rem > 0 && rem < 4 {
+			mask = 1<<(8*rem) - 1
+		}
+		if a.Data[i]&mask != b.Data[i]&mask {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Access) String() string {
+	s := "read "
+	if a.Write {
+		s = "write "
+	}
+	if a.Backplane {
+		s += BackplaneSymbol(a.Addr)
+	} else {
+		s += Symbol(a.Fn, a.Addr)
+	}
+	s += " size=" + strconv.Itoa(int(a.Size))
+	if a.Count > 1 {
+		s += " ×" + strconv.Itoa(a.Count)
+	}
+	if len(a.Data) > 0 {
+		s += " data[0]=0x" + strconv.FormatUint(uint64(a.Data[0]), 16)
+	}
+	return s + " (record " + strconv.Itoa(a.Index) + ")"
+}
+
+// Symbol names the register at command address addr of gSPI function fn using the whd constants.
+// Function 1 addresses below the F1 registers are offsets into the backplane window, see [BackplaneSymbol].
+func Symbol(fn uint8, addr uint32) string {
+	switch fn {
+	case 0:
+		for _, r := range busRegs {
+			if r.addr == addr {
+				return r.name
+			}
+		}
+		return "bus+0x" + strconv.FormatUint(uint64(addr), 16)
+	case 1:
+	case 2:
+		return "wlan"
+	default:
+		return "dma2"
+	}
+	if addr < f1Regs {
+		return "window+0x" + strconv.FormatUint(uint64(addr&whd.BACKPLANE_ADDR_MASK), 16)
+	}
+	for _, r := range f1Registers {
+		if r.addr == addr {
+			return r.name
+		}
+	}
+	return "f1+0x" + strconv.FormatUint(uint64(addr), 16)
+}
+
+// BackplaneSymbol names the full backplane address addr relative to the closest
+// core base address using the whd constants.
+func BackplaneSymbol(addr uint32) string {
+	for _, r := range backplaneRegs {
+		if r.addr == addr {
+			return r.name
+		}
+	}
+	var base *register
+	for i := range backplaneCores {
+		core := &backplaneCores[i]
+		if addr >= core.addr && (base == nil || core.addr > base.addr) {
+			base = core
+		}
+	}
+	if base == nil {
+		return "ram+0x" + strconv.FormatUint(uint64(addr), 16)
+	} else if addr-base.addr >= 0x1000 && base.addr != whd.CYW_BT_BASE_ADDRESS {
+		return "backplane+0x" + strconv.FormatUint(uint64(addr), 16)
+	}
+	off := addr - base.addr
+	switch off {
+	case 0:
+		return base.name
+	case whd.AI_IOCTRL_OFFSET:
+		if base.wrapper {
+			return base.name + "+AI_IOCTRL_OFFSET"
+		}
+	case whd.AI_RESETCTRL_OFFSET:
+		if base.wrapper {
+			return base.name + "+AI_RESETCTRL_OFFSET"
+		}
+	}
+	return base.name + "+0x" + strconv.FormatUint(uint64(off), 16)
+}
+
+type register struct {
+	addr    uint32
+	name    string
+	wrapper bool
+}
+
+var busRegs = [...]register{
+	{addr: whd.SPI_BUS_CONTROL, name: "SPI_BUS_CONTROL"},
+	{addr: whd.SPI_RESPONSE_DELAY, name: "SPI_RESPONSE_DELAY"},
+	{addr: whd.SPI_STATUS_ENABLE, name: "SPI_STATUS_ENABLE"},
+	{addr: whd.SPI_RESET_BP, name: "SPI_RESET_BP"},
+	{addr: whd.SPI_INTERRUPT_REGISTER, name: "SPI_INTERRUPT_REGISTER"},
+	{addr: whd.SPI_INTERRUPT_ENABLE_REGISTER, name: "SPI_INTERRUPT_ENABLE_REGISTER"},
+	{addr: whd.SPI_STATUS_REGISTER, name: "SPI_STATUS_REGISTER"},
+	{addr: whd.SPI_FUNCTION1_INFO, name: "SPI_FUNCTION1_INFO"},
+	{addr: whd.SPI_FUNCTION2_INFO, name: "SPI_FUNCTION2_INFO"},
+	{addr: whd.SPI_FUNCTION3_INFO, name: "SPI_FUNCTION3_INFO"},
+	{addr: whd.SPI_READ_TEST_REGISTER, name: "SPI_READ_TEST_REGISTER"},
+	{addr: whd.SPI_RESP_DELAY_F0, name: "SPI_RESP_DELAY_F0"},
+	{addr: whd.SPI_RESP_DELAY_F1, name: "SPI_RESP_DELAY_F1"},
+	{addr: whd.SPI_RESP_DELAY_F2, name: "SPI_RESP_DELAY_F2"},
+	{addr: whd.SPI_RESP_DELAY_F3, name: "SPI_RESP_DELAY_F3"},
+}
+
+var f1Registers = [...]register{
+	{addr: whd.SDIO_FUNCTION2_WATERMARK, name: "SDIO_FUNCTION2_WATERMARK"},
+	{addr: whd.SDIO_BACKPLANE_ADDRESS_LOW, name: "SDIO_BACKPLANE_ADDRESS_LOW"},
+	{addr: whd.SDIO_BACKPLANE_ADDRESS_MID, name: "SDIO_BACKPLANE_ADDRESS_MID"},
+	{addr: whd.SDIO_BACKPLANE_ADDRESS_HIGH, name: "SDIO_BACKPLANE_ADDRESS_HIGH"},
+	{addr: whd.SDIO_CHIP_CLOCK_CSR, name: "SDIO_CHIP_CLOCK_CSR"},
+	{addr: whd.SDIO_PULL_UP, name: "SDIO_PULL_UP"},
+	{addr: whd.SDIO_WAKEUP_CTRL, name: "SDIO_WAKEUP_CTRL"},
+	{addr: whd.SDIO_SLEEP_CSR, name: "SDIO_SLEEP_CSR"},
+}
+
+var backplaneRegs = [...]register{
+	{addr: whd.CHIPCOMMON_SR_CONTROL1, name: "CHIPCOMMON_SR_CONTROL1"},
+	{addr: whd.SDIO_INT_STATUS, name: "SDIO_INT_STATUS"},
+	{addr: whd.SDIO_INT_HOST_MASK, name: "SDIO_INT_HOST_MASK"},
+	{addr: whd.SDIO_FUNCTION_INT_MASK, name: "SDIO_FUNCTION_INT_MASK"},
+	{addr: whd.SDIO_TO_SB_MAILBOX, name: "SDIO_TO_SB_MAILBOX"},
+	{addr: whd.SOCSRAM_BANKX_INDEX, name: "SOCSRAM_BANKX_INDEX"},
+	{addr: whd.SOCSRAM_BANKX_PDA, name: "SOCSRAM_BANKX_PDA"},
+}
+
+var backplaneCores = [...]register{
+	{addr: whd.CHIPCOMMON_BASE_ADDRESS, name: "CHIPCOMMON_BASE_ADDRESS"},
+	{addr: whd.SDIO_BASE_ADDRESS, name: "SDIO_BASE_ADDRESS"},
+	{addr: whd.WLAN_ARMCM3_BASE_ADDRESS, name: "WLAN_ARMCM3_BASE_ADDRESS"},
+	{addr: whd.SOCSRAM_BASE_ADDRESS, name: "SOCSRAM_BASE_ADDRESS"},
+	{addr: whd.WRAPPER_REGISTER_OFFSET + whd.WLAN_ARMCM3_BASE_ADDRESS, name: "WLAN_ARMCM3_WRAPPER", wrapper: true},
+	{addr: whd.WRAPPER_REGISTER_OFFSET + whd.SOCSRAM_BASE_ADDRESS, name: "SOCSRAM_WRAPPER", wrapper: true},
+	{addr: whd.CYW_BT_BASE_ADDRESS, name: "CYW_BT_BASE_ADDRESS"},
+}
+
+// MismatchKind classifies a difference between a reference and a driver trace.
+type MismatchKind uint8
+
+const (
+	// Missing accesses are in the reference but were not issued by the driver.
+	Missing MismatchKind = iota + 1
+	// Extra accesses were issued by the driver but are not in the reference.
+	Extra
+	// Differ accesses were issued by both at the same register but wrote different data.
+	Differ
+)
+
+func (k MismatchKind) String() string {
+	switch k {
+	case Missing:
+		return "missing"
+	case Extra:
+		return "extra"
+	case Differ:
+		return "differ"
+	}
+	return "MismatchKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Mismatch is a difference between a reference and a driver trace.
+type Mismatch struct {
+	Kind MismatchKind
+	// Ref is the reference access, nil for Extra.
+	Ref *Access
+	// Got is the driver access, nil for Missing.
+	Got *Access
+}
+
+func (m Mismatch) String() string {
+	switch m.Kind {
+	case Missing:
+		return "missing " + m.Ref.String()
+	case Extra:
+		return "extra   " + m.Got.String()
+	}
+	return "differ  " + m.Ref.String() + " != " + m.Got.String()
+}
+
+// Diff aligns the accesses of a reference trace with those of a driver trace and
+// returns the missing, extra and differing accesses in reference order.
+// Accesses are aligned by direction, function, address and size.
+func Diff(ref, got []Record) ([]Mismatch, error) {
+	a, b := Accesses(ref), Accesses(got)
+	pairs, ok := align(len(a), len(b), func(i, j int) bool { return a[i].key() == b[j].key() })
+	if !ok {
+		return nil, errDiverged
+	}
+	var mismatches []Mismatch
+	i, j := 0, 0
+	for _, p := range append(pairs, [2]int{len(a), len(b)}) {
+		for ; i < p[0]; i++ {
+			mismatches = append(mismatches, Mismatch{Kind: Missing, Ref: &a[i]})
+		}
+		for ; j < p[1]; j++ {
+			mismatches = append(mismatches, Mismatch{Kind: Extra, Got: &b[j]})
+		}
+		if i < len(a) && j < len(b) {
+			if a[i].Write && !a[i].sameData(&b[j]) {
+				mismatches = append(mismatches, Mismatch{Kind: Differ, Ref: &a[i], Got: &b[j]})
+			}
+			i++
+			j++
+		}
+	}
+	return mismatches, nil
+}
+
+// T is the subset of testing.TB used by [Conform].
+type T interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// Conform reports the differences between the reference trace and the driver trace
+// as test errors and returns true if the driver conforms to the reference.
+func Conform(t T, ref, got []Record) bool {
+	t.Helper()
+	const maxReport = 32
+	mismatches, err := Diff(ref, got)
+	if err != nil {
+		t.Errorf("%s", err)
+		return false
+	}
+	for i, m := range mismatches {
+		if i == maxReport {
+			t.Errorf("%d more mismatches", len(mismatches)-maxReport)
+			break
+		}
+		t.Errorf("%s", m)
+	}
+	return len(mismatches) == 0
+}
+
+// align returns the index pairs of the longest common subsequence of sequences of length n
+// and m using Myers' difference algorithm. It returns false if more than maxEdits are needed.
+func align(n, m int, eq func(i, j int) bool) (pairs [][2]int, ok bool) {
+	dmax := min(n+m, maxEdits)
+	off := dmax + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; d <= dmax; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && eq(x, y) {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+				return backtrack(trace, n, m), true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+	return nil, false
+}
+
+func backtrack(trace [][]int, x, y int) [][2]int {
+	var pairs [][2]int
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prev := trace[d-1] // Indexed by k+d-1.
+			prevK := k - 1
+			if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+				prevK = k + 1
+			}
+			prevX = prev[prevK+d-1]
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			pairs = append(pairs, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+	return pairs
+}
+
+func swap16(b uint32) uint32 {
+	return (b >> 16) | (b << 16)
+}
@@ -0,0 +1,254 @@
+// Package cywtrace records the gSPI bus transactions between the CYW43439 driver
+// and the chip and replays them back to the driver to catch protocol regressions without hardware.
+//
+// A [Recorder] wraps the bus passed to cyw43439.New and writes every command word,
+// payload and returned status to a compact trace. On rp2040 builds the bus must be
+// wrapped with the cy43nopio build tag set so that cyw43439.New accepts it:
+//
+//	spi, _ := piolib.NewSPI3w(sm, DATA, CLK, baud)
+//	rec := cywtrace.NewRecorder(spi, w)
+//	dev := cyw43439.New(rec.Power(WL_REG_ON.Set), CS.Set, rec)
+//
+// A [Replayer] serves a decoded trace back to a Device on the host and reports
+// the first command that deviates from the recording.
+package cywtrace
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Bus is the gSPI command bus used by the CYW43439 driver.
+type Bus interface {
+	CmdRead(cmd uint32, buf []uint32) error
+	CmdWrite(cmd uint32, buf []uint32) error
+	LastStatus() uint32
+}
+
+// Trace format: the magic and version followed by records. Each record starts with
+// a flags byte. Bus records follow with the little endian command word, the status
+// if it changed since the previous record, an uvarint word count and the words.
+const (
+	magic   = "CYWT"
+	version = 1
+
+	flagOpMask = 0b11
+	flagErr    = 1 << 2 // Bus returned an error.
+	flagStatus = 1 << 3 // Status word follows command word.
+	flagOn     = 1 << 4 // Power on, only for OpPower.
+)
+
+var (
+	errBadMagic    = errors.New("cywtrace: not a trace")
+	errBadVersion  = errors.New("cywtrace: unsupported trace version")
+	errBadRecord   = errors.New("cywtrace: invalid record")
+	errRecordedBus = errors.New("cywtrace: recorded bus error")
+)
+
+// Op is the kind of a recorded transaction.
+type Op uint8
+
+const (
+	OpRead Op = iota
+	OpWrite
+	// OpPower is a change of the WL_REG_ON power pin.
+	OpPower
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	case OpPower:
+		return "power"
+	}
+	return "Op(" + strconv.Itoa(int(op)) + ")"
+}
+
+// Record is a recorded bus transaction.
+type Record struct {
+	Op  Op
+	Cmd uint32
+	// Data holds the words written for OpWrite and the words read for OpRead.
+	Data []uint32
+	// Status is the bus status after the transaction.
+	Status uint32
+	// Err is set if the bus returned an error.
+	Err bool
+	// On is the pin level of OpPower records.
+	On bool
+}
+
+// Fields decodes the gSPI command word of the record. Commands sent before the bus
+// is configured for 32 bit words have their 16 bit halves swapped and decode incorrectly.
+func (r *Record) Fields() (write bool, fn uint8, addr uint32, size uint16) {
+	return r.Cmd&(1<<31) != 0, uint8(r.Cmd>>28) & 0b11, (r.Cmd >> 11) & 0x1ffff, uint16(r.Cmd & 0x7ff)
+}
+
+func (r *Record) String() string {
+	if r.Op == OpPower {
+		if r.On {
+			return "power on"
+		}
+		return "power off"
+	}
+	_, fn, addr, size := r.Fields()
+	s := r.Op.String() + " cmd=0x" + strconv.FormatUint(uint64(r.Cmd), 16) +
+		" fn=" + strconv.Itoa(int(fn)) + " addr=0x" + strconv.FormatUint(uint64(addr), 16) +
+		" size=" + strconv.Itoa(int(size)) + " words=" + strconv.Itoa(len(r.Data))
+	if len(r.Data) > 0 {
+		s += " data[0]=0x" + strconv.FormatUint(uint64(r.Data[0]), 16)
+	}
+	return s
+}
+
+// Recorder is a [Bus] that records all transactions to a writer.
+// It does not allocate so it may be used on the microcontroller.
+type Recorder struct {
+	bus     Bus
+	w       io.Writer
+	err     error
+	n       int
+	status  uint32
+	scratch [64]byte
+}
+
+// NewRecorder returns a Recorder that executes transactions on bus and writes them to w.
+// Buffering w is recommended since every transaction results in a write.
+func NewRecorder(bus Bus, w io.Writer) *Recorder {
+	r := &Recorder{bus: bus, w: w}
+	n := copy(r.scratch[:], magic)
+	r.scratch[n] = version
+	r.write(r.scratch[:n+1])
+	return r
+}
+
+// Power wraps the power pin function passed to cyw43439.New so that power cycles are recorded.
+func (r *Recorder) Power(pin func(bool)) func(bool) {
+	return func(on bool) {
+		pin(on)
+		flags := byte(OpPower)
+		if on {
+			flags |= flagOn
+		}
+		r.scratch[0] = flags
+		r.write(r.scratch[:1])
+		r.n++
+	}
+}
+
+// CmdRead executes the read on the wrapped bus and records the words read.
+func (r *Recorder) CmdRead(cmd uint32, buf []uint32) error {
+	err := r.bus.CmdRead(cmd, buf)
+	r.record(OpRead, cmd, buf, err)
+	return err
+}
+
+// CmdWrite executes the write on the wrapped bus and records the words written.
+func (r *Recorder) CmdWrite(cmd uint32, buf []uint32) error {
+	err := r.bus.CmdWrite(cmd, buf)
+	r.record(OpWrite, cmd, buf, err)
+	return err
+}
+
+// LastStatus returns the status of the wrapped bus.
+func (r *Recorder) LastStatus() uint32 { return r.bus.LastStatus() }
+
+// Len returns the amount of records written.
+func (r *Recorder) Len() int { return r.n }
+
+// Err returns the first error encountered writing the trace.
+func (r *Recorder) Err() error { return r.err }
+
+func (r *Recorder) record(op Op, cmd uint32, buf []uint32, err error) {
+	status := r.bus.LastStatus()
+	flags := byte(op)
+	if err != nil {
+		flags |= flagErr
+	}
+	b := r.scratch[:1]
+	b = binary.LittleEndian.AppendUint32(b, cmd)
+	if status != r.status || r.n == 0 {
+		flags |= flagStatus
+		b = binary.LittleEndian.AppendUint32(b, status)
+		r.status = status
+	}
+	b[0] = flags
+	b = binary.AppendUvarint(b, uint64(len(buf)))
+	for _, word := range buf {
+		if len(b)+4 > len(r.scratch) {
+			r.write(b)
+			b = r.scratch[:0]
+		}
+		b = binary.LittleEndian.AppendUint32(b, word)
+	}
+	r.write(b)
+	r.n++
+}
+
+func (r *Recorder) write(b []byte) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = r.w.Write(b)
+}
+
+// Decode reads a trace written by a [Recorder].
+func Decode(r io.Reader) ([]Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+		return nil, errBadMagic
+	} else if data[len(magic)] != version {
+		return nil, errBadVersion
+	}
+	data = data[len(magic)+1:]
+	var records []Record
+	var status uint32
+	for len(data) > 0 {
+		flags := data[0]
+		data = data[1:]
+		rec := Record{Op: Op(flags & flagOpMask), Err: flags&flagErr != 0}
+		switch rec.Op {
+		case OpPower:
+			rec.On = flags&flagOn != 0
+			rec.Status = status
+			records = append(records, rec)
+			continue
+		case OpRead, OpWrite:
+		default:
+			return records, errBadRecord
+		}
+		if len(data) < 4 {
+			return records, io.ErrUnexpectedEOF
+		}
+		rec.Cmd = binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if flags&flagStatus != 0 {
+			if len(data) < 4 {
+				return records, io.ErrUnexpectedEOF
+			}
+			status = binary.LittleEndian.Uint32(data)
+			data = data[4:]
+		}
+		rec.Status = status
+		nwords, n := binary.Uvarint(data)
+		if n <= 0 || nwords > uint64(len(data)-n)/4 {
+			return records, errBadRecord
+		}
+		data = data[n:]
+		rec.Data = make([]uint32, nwords)
+		for i := range rec.Data {
+			rec.Data[i] = binary.LittleEndian.Uint32(data[4*i:])
+		}
+		data = data[4*nwords:]
+		records = append(records, rec)
+	}
+	return records, nil
+}
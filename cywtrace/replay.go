@@ -0,0 +1,165 @@
+package cywtrace
+
+import (
+	"strconv"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+// DeviationError is returned by [Replayer.Err] when the driver issued a transaction
+// that differs from the recorded one.
+type DeviationError struct {
+	// Index is the index of the expected record in the trace.
+	Index int
+	// Want is the recorded transaction. Want is nil if the trace was exhausted.
+	Want *Record
+	// Got is the transaction issued by the driver.
+	Got Record
+}
+
+func (e *DeviationError) Error() string {
+	if e.Want == nil {
+		return "cywtrace: record " + strconv.Itoa(e.Index) + " past end of trace: got " + e.Got.String()
+	}
+	return "cywtrace: deviation at record " + strconv.Itoa(e.Index) + ": want " + e.Want.String() + ", got " + e.Got.String()
+}
+
+// Replayer is a [Bus] that serves a recorded trace back to the driver.
+// Reads return the recorded data and writes are checked against the recorded payload.
+// After the first deviation the Replayer stops serving the trace: reads return zeros
+// and the deviation is reported by [Replayer.Err].
+//
+// Reads of the gSPI status register depend on timing since the driver reuses the
+// last bus status when polled in quick succession. Recorded status reads the driver
+// does not issue are skipped and unrecorded ones are served the last status.
+type Replayer struct {
+	records []Record
+	pos     int
+	status  uint32
+	err     *DeviationError
+}
+
+// NewReplayer returns a Replayer serving records, usually obtained with [Decode].
+func NewReplayer(records []Record) *Replayer {
+	return &Replayer{records: records}
+}
+
+// Power checks the power pin level against the trace. It is passed to cyw43439.New as the power pin.
+func (rp *Replayer) Power(on bool) {
+	rp.next(Record{Op: OpPower, On: on})
+}
+
+// CmdRead serves the recorded read data into buf.
+func (rp *Replayer) CmdRead(cmd uint32, buf []uint32) error {
+	got := Record{Op: OpRead, Cmd: cmd, Data: buf}
+	want, unrecorded := rp.next(got)
+	switch {
+	case unrecorded:
+		buf[0] = rp.status
+		return nil
+	case want == nil:
+		clear(buf)
+		return nil
+	}
+	copy(buf, want.Data)
+	if want.Err {
+		return errRecordedBus
+	}
+	return nil
+}
+
+// CmdWrite checks the written data against the trace.
+func (rp *Replayer) CmdWrite(cmd uint32, buf []uint32) error {
+	want, _ := rp.next(Record{Op: OpWrite, Cmd: cmd, Data: buf})
+	if want != nil && want.Err {
+		return errRecordedBus
+	}
+	return nil
+}
+
+// LastStatus returns the status recorded for the last served transaction.
+func (rp *Replayer) LastStatus() uint32 { return rp.status }
+
+// Remaining returns the amount of records not yet served.
+func (rp *Replayer) Remaining() int { return len(rp.records) - rp.pos }
+
+// Err returns the first deviation from the trace, or nil if the driver followed it.
+func (rp *Replayer) Err() error {
+	if rp.err == nil {
+		return nil
+	}
+	return rp.err
+}
+
+// next returns the record matching got and advances the trace. It returns nil
+// on deviation and unrecorded set for a status register read missing from the trace.
+func (rp *Replayer) next(got Record) (want *Record, unrecorded bool) {
+	if rp.err != nil {
+		return nil, false
+	}
+	for rp.pos < len(rp.records) {
+		want := &rp.records[rp.pos]
+		switch {
+		case matches(want, &got):
+			rp.pos++
+			rp.status = want.Status
+			return want, false
+		case isStatusRead(want):
+			rp.pos++
+			rp.status = want.Status
+			continue
+		case isStatusRead(&got):
+			return nil, true
+		}
+		rp.deviate(want, got)
+		return nil, false
+	}
+	if isStatusRead(&got) {
+		return nil, true
+	}
+	rp.deviate(nil, got)
+	return nil, false
+}
+
+func (rp *Replayer) deviate(want *Record, got Record) {
+	// Copy data since the driver reuses its buffers.
+	got.Data = append([]uint32(nil), got.Data...)
+	rp.err = &DeviationError{Index: rp.pos, Want: want, Got: got}
+}
+
+func matches(want, got *Record) bool {
+	if want.Op != got.Op {
+		return false
+	} else if want.Op == OpPower {
+		return want.On == got.On
+	}
+	if want.Cmd != got.Cmd || len(want.Data) != len(got.Data) {
+		return false
+	}
+	if want.Op == OpRead {
+		return true
+	}
+	// Only compare the bytes covered by the command size,
+	// the driver pads writes with stale buffer contents.
+	_, _, _, size := want.Fields()
+	n := (int(size) + 3) / 4
+	if n == 0 || n > len(want.Data) {
+		n = len(want.Data)
+		size = 0
+	}
+	for i := 0; i < n; i++ {
+		mask := ^uint32(0)
+		if i == n-1 && size%4 != 0 {
+			mask = 1<<(8*(size%4)) - 1
+		}
+		if want.Data[i]&mask != got.Data[i]&mask {
+			return false
+		}
+	}
+	return true
+}
+
+func isStatusRead(r *Record) bool {
+	write, fn, addr, _ := r.Fields()
+	return r.Op == OpRead && !write && fn == 0 && addr == whd.SPI_STATUS_REGISTER
+}
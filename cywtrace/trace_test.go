@@ -0,0 +1,161 @@
+package cywtrace_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439"
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/cywtrace"
+	"github.com/soypat/cyw43439/whd"
+)
+
+var testNet = cywemu.Network{SSID: "trace-net", BSSID: [6]byte{0x02, 1, 2, 3, 4, 5}, Channel: 6, Passphrase: "password123"}
+
+func recordSession(t *testing.T) []cywtrace.Record {
+	t.Helper()
+	var buf bytes.Buffer
+	chip := cywemu.New(cywemu.Config{Networks: []cywemu.Network{testNet}})
+	rec := cywtrace.NewRecorder(chip, &buf)
+	dev := cyw43439.New(rec.Power(chip.Power), func(bool) {}, rec)
+	runSession(t, dev, testNet.Passphrase)
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := cywtrace.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != rec.Len() {
+		t.Fatalf("decoded %d records, recorded %d", len(records), rec.Len())
+	}
+	return records
+}
+
+func runSession(t *testing.T, dev *cyw43439.Device, passphrase string) error {
+	t.Helper()
+	err := dev.Init(cyw43439.DefaultWifiConfig())
+	if err != nil {
+		t.Fatal("init:", err)
+	}
+	return dev.Join(testNet.SSID, cyw43439.JoinOptions{Passphrase: passphrase})
+}
+
+func TestReplay(t *testing.T) {
+	records := recordSession(t)
+	rp := cywtrace.NewReplayer(records)
+	dev := cyw43439.New(rp.Power, func(bool) {}, rp)
+	err := runSession(t, dev, testNet.Passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n := rp.Remaining(); n != 0 {
+		t.Errorf("%d records not replayed", n)
+	}
+}
+
+func TestReplayDeviation(t *testing.T) {
+	records := recordSession(t)
+	rp := cywtrace.NewReplayer(records)
+	dev := cyw43439.New(rp.Power, func(bool) {}, rp)
+	runSession(t, dev, "other-password")
+	var derr *cywtrace.DeviationError
+	if !errors.As(rp.Err(), &derr) {
+		t.Fatalf("want deviation error, got %v", rp.Err())
+	}
+	if derr.Want == nil || derr.Want.Op != cywtrace.OpWrite {
+		t.Errorf("want deviation in written passphrase, got %v", derr)
+	}
+}
+
+func TestReplayUnrecordedStatus(t *testing.T) {
+	const (
+		statusRead = whd.SPI_STATUS_REGISTER<<11 | 4
+		testRead   = 1<<30 | whd.SPI_READ_TEST_REGISTER<<11 | 4
+		busWrite   = 1<<31 | 1<<30 | whd.SPI_INTERRUPT_REGISTER<<11 | 4
+	)
+	// Trace without the status reads issued by the driver.
+	rp := cywtrace.NewReplayer([]cywtrace.Record{
+		{Op: cywtrace.OpWrite, Cmd: busWrite, Data: []uint32{1}, Status: 0xabcd},
+		{Op: cywtrace.OpRead, Cmd: testRead, Data: []uint32{whd.TEST_PATTERN}, Status: 0x1234},
+	})
+	readStatus := func(want uint32) {
+		t.Helper()
+		buf := []uint32{0xdeadbeef}
+		rp.CmdRead(statusRead, buf)
+		if buf[0] != want {
+			t.Errorf("status read served %#x, want %#x", buf[0], want)
+		}
+	}
+	rp.CmdWrite(busWrite, []uint32{1})
+	readStatus(0xabcd)
+	rp.CmdRead(testRead, make([]uint32, 1))
+	readStatus(0x1234) // Past the end of the trace.
+	if err := rp.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConform(t *testing.T) {
+	ref := recordSession(t)
+	got := recordSession(t)
+	if !cywtrace.Conform(t, ref, got) {
+		t.Fatal("identical sessions do not conform")
+	}
+
+	var buf bytes.Buffer
+	chip := cywemu.New(cywemu.Config{Networks: []cywemu.Network{testNet}})
+	rec := cywtrace.NewRecorder(chip, &buf)
+	dev := cyw43439.New(rec.Power(chip.Power), func(bool) {}, rec)
+	runSession(t, dev, "other-password")
+	got, err := cywtrace.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := cywtrace.Diff(ref, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var differ bool
+	for _, m := range mismatches {
+		t.Log(m)
+		differ = differ || m.Kind == cywtrace.Differ && m.Got.Fn == 2
+	}
+	if !differ {
+		t.Error("want differing WLAN write for passphrase")
+	}
+}
+
+func TestSymbol(t *testing.T) {
+	for _, test := range []struct {
+		fn   uint8
+		addr uint32
+		want string
+	}{
+		{fn: 0, addr: whd.SPI_READ_TEST_REGISTER, want: "SPI_READ_TEST_REGISTER"},
+		{fn: 1, addr: whd.SDIO_CHIP_CLOCK_CSR, want: "SDIO_CHIP_CLOCK_CSR"},
+		{fn: 1, addr: 0x8010, want: "window+0x10"},
+		{fn: 2, addr: 0, want: "wlan"},
+	} {
+		if got := cywtrace.Symbol(test.fn, test.addr); got != test.want {
+			t.Errorf("Symbol(%d, %#x)=%q, want %q", test.fn, test.addr, got, test.want)
+		}
+	}
+	for _, test := range []struct {
+		addr uint32
+		want string
+	}{
+		{addr: whd.SDIO_INT_HOST_MASK, want: "SDIO_INT_HOST_MASK"},
+		{addr: whd.WRAPPER_REGISTER_OFFSET + whd.WLAN_ARMCM3_BASE_ADDRESS + whd.AI_RESETCTRL_OFFSET, want: "WLAN_ARMCM3_WRAPPER+AI_RESETCTRL_OFFSET"},
+		{addr: whd.SOCSRAM_BASE_ADDRESS + 0x20, want: "SOCSRAM_BASE_ADDRESS+0x20"},
+		{addr: 0x1000e, want: "ram+0x1000e"},
+	} {
+		if got := cywtrace.BackplaneSymbol(test.addr); got != test.want {
+			t.Errorf("BackplaneSymbol(%#x)=%q, want %q", test.addr, got, test.want)
+		}
+	}
+}
@@ -168,14 +168,14 @@ func getFWVersion(src string) (string, error) {
 	return fwVersion, nil
 }
 
-// errjoion returns an error that wraps the given errors.
+// errjoin returns an error that wraps the given errors.
 // Any nil error values are discarded.
-// errjoion returns nil if every value in errs is nil.
+// errjoin returns nil if every value in errs is nil.
 // The error formats as the concatenation of the strings obtained
 // by calling the Error method of each element of errs, with a newline
 // between each string.
 //
-// A non-nil error returned by errjoion implements the Unwrap() []error method.
+// A non-nil error returned by errjoin implements the Unwrap() []error method.
 func errjoin(errs ...error) error {
 	n := 0
 	for _, err := range errs {
@@ -186,7 +186,7 @@ func errjoin(errs ...error) error {
 	if n == 0 {
 		return nil
 	}
-	e := &joinError{
+	e := &multiError{
 		errs: make([]error, 0, n),
 	}
 	for _, err := range errs {
@@ -219,132 +219,108 @@ const (
 	irqF3_INTR                 irqmask = 0x8000
 )
 
+// PowerManagementMode selects a power management preset of the CYW43439.
+// The zero value is [PMPowerSave], the default mode.
+//
 // https://github.com/embassy-rs/embassy/blob/26870082427b64d3ca42691c55a2cded5eadc548/cyw43/src/lib.rs#L153
-type powerManagementMode uint8
+type PowerManagementMode uint8
 
 const (
+	// PMPowerSave is the default mode.
+	PMPowerSave PowerManagementMode = iota
+
 	// Custom, officially unsupported mode. Use at your own risk.
 	// All power-saving features set to their max at only a marginal decrease in power consumption
 	// as oppposed to `Aggressive`.
-	pmSuperSave powerManagementMode = iota
+	PMSuperSave
 
-	// pmAggressive power saving mode.
-	pmAggressive
+	// PMAggressive power saving mode.
+	PMAggressive
 
-	// The default mode.
-	pmPowerSave
-
-	// pmPerformance is prefered over power consumption but still some power is conserved as opposed to
+	// PMPerformance is prefered over power consumption but still some power is conserved as opposed to
 	// `None`.
-	pmPerformance
+	PMPerformance
 
 	// Unlike all the other PM modes, this lowers the power consumption at all times at the cost of
 	// a much lower throughput.
-	pmThroughputThrottling
+	PMThroughputThrottling
 
 	// No power management is configured. This consumes the most power.
-	pmNone
+	PMNone
 )
 
-func (pm powerManagementMode) IsValid() bool {
-	return pm <= pmNone
+func (pm PowerManagementMode) IsValid() bool {
+	return pm <= PMNone
 }
 
-func (pm powerManagementMode) String() string {
+func (pm PowerManagementMode) String() string {
 	switch pm {
-	case pmSuperSave:
+	case PMSuperSave:
 		return "SuperSave"
-	case pmAggressive:
+	case PMAggressive:
 		return "Aggressive"
-	case pmPowerSave:
+	case PMPowerSave:
 		return "PowerSave"
-	case pmPerformance:
+	case PMPerformance:
 		return "Performance"
-	case pmThroughputThrottling:
+	case PMThroughputThrottling:
 		return "ThroughputThrottling"
-	case pmNone:
+	case PMNone:
 		return "None"
 	default:
 		return "unknown"
 	}
 }
-func (pm powerManagementMode) sleep_ret_ms() uint16 {
-	switch pm {
-	case pmSuperSave:
-		return 2000
-	case pmAggressive:
-		return 2000
-	case pmPowerSave:
-		return 200
-	case pmPerformance:
-		return 20
-	default: // ThroughputThrottling, None
-		return 0 // value doesn't matter
-	}
-}
-
-func (pm powerManagementMode) beacon_period() uint8 {
-	switch pm {
-	case pmSuperSave:
-		return 255
-	case pmAggressive:
-		return 1
-	case pmPowerSave:
-		return 1
-	case pmPerformance:
-		return 1
-	default: // ThroughputThrottling, None
-		return 0 // value doesn't matter
-	}
-}
 
-func (pm powerManagementMode) dtim_period() uint8 {
+// Params returns the power save parameters of the mode. Modes
+// [PMThroughputThrottling] and [PMNone] do not use the parameters and return the zero value.
+func (pm PowerManagementMode) Params() PowerManagementParams {
 	switch pm {
-	case pmSuperSave:
-		return 255
-	case pmAggressive:
-		return 1
-	case pmPowerSave:
-		return 1
-	case pmPerformance:
-		return 1
+	case PMSuperSave:
+		return PowerManagementParams{SleepRetMs: 2000, BeaconPeriod: 255, DTIMPeriod: 255, AssocListen: 255}
+	case PMAggressive:
+		return PowerManagementParams{SleepRetMs: 2000, BeaconPeriod: 1, DTIMPeriod: 1, AssocListen: 10}
+	case PMPowerSave:
+		return PowerManagementParams{SleepRetMs: 200, BeaconPeriod: 1, DTIMPeriod: 1, AssocListen: 10}
+	case PMPerformance:
+		return PowerManagementParams{SleepRetMs: 20, BeaconPeriod: 1, DTIMPeriod: 1, AssocListen: 1}
 	default: // ThroughputThrottling, None
-		return 0 // value doesn't matter
-	}
-}
-
-func (pm powerManagementMode) assoc() uint8 {
-	switch pm {
-	case pmSuperSave:
-		return 255
-	case pmAggressive:
-		return 10
-	case pmPowerSave:
-		return 10
-	case pmPerformance:
-		return 1
-	default: // ThroughputThrottling, None
-		return 0 // value doesn't matter
+		return PowerManagementParams{} // value doesn't matter
 	}
 }
 
 // mode returns the WHD's internal mode number.
-func (pm powerManagementMode) mode() uint8 {
+func (pm PowerManagementMode) mode() uint8 {
 	switch pm {
-	case pmThroughputThrottling:
+	case PMThroughputThrottling:
 		return 1
-	case pmNone:
+	case PMNone:
 		return 0
 	default:
 		return 2
 	}
 }
 
-type joinError struct {
+// PowerManagementParams are the parameters of the fast power save mode (PM2)
+// used by all power save modes except [PMThroughputThrottling] and [PMNone].
+// Use [Device.SetPowerManagementParams] to configure a custom power save mode.
+type PowerManagementParams struct {
+	// SleepRetMs is the time in milliseconds the radio stays awake after the last
+	// packet is sent or received before returning to sleep.
+	SleepRetMs uint16
+	// BeaconPeriod is the amount of beacon periods between wakeups to listen for beacons.
+	BeaconPeriod uint8
+	// DTIMPeriod is the amount of DTIM periods between wakeups to receive buffered broadcast traffic.
+	DTIMPeriod uint8
+	// AssocListen is the listen interval in beacon periods advertised to the access point on association.
+	AssocListen uint8
+}
+
+type multiError struct {
 	errs []error
 }
 
-func (e *joinError) Error() string {
+func (e *multiError) Error() string {
 	var b []byte
 	for i, err := range e.errs {
 		if i > 0 {
@@ -355,7 +331,7 @@ func (e *joinError) Error() string {
 	return string(b)
 }
 
-func (e *joinError) Unwrap() []error {
+func (e *multiError) Unwrap() []error {
 	return e.errs
 }
 
@@ -29,12 +29,15 @@ const (
 type linkState uint8
 
 const (
-	linkStateDown   linkState = iota
-	_                         // unused (was linkStateUpWaitForSSID)
+	linkStateDown linkState = iota
+	_                       // unused (was linkStateUpWaitForSSID)
 	linkStateUp
 	linkStateFailed
 )
 
+// initPollInterval is the interval at which clock and status bits are polled during Init.
+const initPollInterval = 100 * time.Microsecond
+
 type outputPin func(bool)
 
 func DefaultBluetoothConfig() Config {
@@ -96,17 +99,58 @@ type Device struct {
 	authOK          bool // AUTH event succeeded. ref: runner.rs:90
 	joinOK          bool // JOIN event succeeded. ref: runner.rs:88
 	keyExchangeOK   bool // PSK_SUP key exchange succeeded. ref: runner.rs:89
+	scanst          scanState
+	usermask        eventMask   // Events subscribed to by user with SetEventHandler.
+	evHandler       func(Event) // User event handler.
+	reconn          reconnectState
+	cfg             Config          // Config used in last Init call.
+	ctx             context.Context // Context of the ongoing blocking operation, nil if none.
+	timings         InitTimings
+	netlink         netlinkState
+	apUp            bool // Access point started.
+	apConcurrent    bool // Access point runs alongside station on IF_AP.
+	apFollowPending bool // Concurrent access point must be checked to follow station channel.
+	apChannel       uint8
+	rcvEthAP        func([]byte) error // Receive handler for IF_AP in concurrent mode.
+	monitor         monitorState
+	probeHandler    func(*ProbeRequest)
+	actframe        actionFrameState
+	joinst          joinState
 }
 
 type Config struct {
 	Firmware string
 	CLM      string
 	Logger   *slog.Logger
+	// NVRAM is the board configuration image uploaded to the device. Empty selects the
+	// Raspberry Pi Pico W configuration. Use [NVRAM.Image] to build a custom image.
+	NVRAM string
+	// Country is the two letter ISO 3166 country code which selects the regulatory domain,
+	// i.e: "US", "JP", "DE". Empty selects the worldwide default "XX" which restricts
+	// channels 12 to 14 and transmit power. See [Device.SetCountry].
+	Country string
+	// CountryRev is the regulatory revision of Country in the loaded CLM. Zero selects the default revision.
+	CountryRev uint8
+	// PowerManagement is the power management mode set on initialization.
+	// The zero value selects [PMPowerSave]. Can be changed after Init with [Device.SetPowerManagement].
+	PowerManagement PowerManagementMode
+	// JoinTimeout is the maximum time a join may take. Zero selects 10 seconds.
+	// Can be overridden per join with [JoinOptions.Timeout].
+	JoinTimeout time.Duration
+	// ScanTimeout is the maximum time a scan may take. Zero selects 10 seconds.
+	ScanTimeout time.Duration
 	// mode selects the enabled operation modes of the CYW43439.
 	mode opMode
 }
 
 func (d *Device) Init(cfg Config) (err error) {
+	return d.InitContext(context.Background(), cfg)
+}
+
+// InitContext is like [Device.Init] but returns early with the context's error
+// if ctx is canceled or its deadline is exceeded. The device must be initialized
+// again after an aborted initialization.
+func (d *Device) InitContext(ctx context.Context, cfg Config) (err error) {
 	if cfg.mode&(modeBluetooth|modeWifi) == 0 {
 		return errors.New("no operation mode selected")
 	}
@@ -115,10 +159,41 @@ func (d *Device) Init(cfg Config) (err error) {
 	if err != nil {
 		return err
 	}
+	d.ctx = ctx
+	defer d.clearContext()
+	return d.init(cfg)
+}
+
+// InitTimings is a breakdown of the time spent in each stage of [Device.Init].
+type InitTimings struct {
+	// Bus is the time spent power cycling the chip, initializing the bus and waiting for the ALP clock.
+	Bus time.Duration
+	// Firmware is the time spent uploading the firmware and NVRAM.
+	Firmware time.Duration
+	// Boot is the time from starting the core until the firmware is running.
+	Boot time.Duration
+	// CLM is the time spent uploading the CLM (regulatory database).
+	CLM time.Duration
+	// Control is the time spent configuring the radio and bringing it up.
+	Control time.Duration
+	// Total is the total time spent in Init.
+	Total time.Duration
+}
+
+// InitTimings returns the per-stage timing breakdown of the last call to [Device.Init].
+func (d *Device) InitTimings() InitTimings {
+	return d.timings
+}
+
+func (d *Device) init(cfg Config) (err error) {
 	d.info("Init:start")
 	start := time.Now()
+	d.timings = InitTimings{}
+	defer func() { d.timings.Total = time.Since(start) }()
+	stage := start
 	// Reference: https://github.com/embassy-rs/embassy/blob/6babd5752e439b234151104d8d20bae32e41d714/cyw43/src/runner.rs#L76
 	d.logger = cfg.Logger
+	d.cfg = cfg
 	d._traceenabled = d.logger != nil && d.logger.Handler().Enabled(context.Background(), levelTrace)
 
 	d.backplaneWindow = 0xaaaa_aaaa
@@ -146,11 +221,15 @@ func (d *Device) Init(cfg Config) (err error) {
 		if got&whd.SBSDIO_ALP_AVAIL != 0 {
 			break // ALP available-> clock OK.
 		}
-		time.Sleep(time.Millisecond)
+		if err = d.sleep(initPollInterval); err != nil {
+			return err
+		}
 	}
 
 	// Clear request for ALP.
 	d.write8(FuncBackplane, whd.SDIO_CHIP_CLOCK_CSR, 0)
+	d.timings.Bus = time.Since(stage)
+	stage = time.Now()
 
 	chip_id, _ := d.bp_read16(0x1800_0000)
 
@@ -181,15 +260,20 @@ func (d *Device) Init(cfg Config) (err error) {
 
 	// Load NVRAM
 	const chipRAMSize = 512 * 1024
-	nvramLen := alignup(uint32(len(nvram43439)), 4)
-	d.debug("flashing nvram")
-	err = d.bp_writestring(ramAddr+chipRAMSize-4-nvramLen, nvram43439)
+	nvram := cfg.NVRAM
+	if nvram == "" {
+		nvram = nvram43439
+	}
+	nvramLen := alignup(uint32(len(nvram)), 4)
+	d.debug("flashing nvram", slog.Int("len", len(nvram)))
+	err = d.bp_writestring(ramAddr+chipRAMSize-4-nvramLen, nvram)
 	if err != nil {
 		return err
 	}
-	nvramLenWords := nvramLen / 4
-	nvramLenMagic := ((^nvramLenWords) << 16) | nvramLenWords
-	d.bp_write32(ramAddr+chipRAMSize-4, nvramLenMagic)
+	d.bp_write32(ramAddr+chipRAMSize-4, nvramLenMagic(nvramLen))
+
+	d.timings.Firmware = time.Since(stage)
+	stage = time.Now()
 
 	// Start core.
 	d.debug("Init:start-core")
@@ -212,7 +296,9 @@ func (d *Device) Init(cfg Config) (err error) {
 		if time.Since(deadline) >= 0 {
 			return errors.New("timeout waiting for chip clock")
 		}
-		time.Sleep(time.Millisecond)
+		if err = d.sleep(initPollInterval); err != nil {
+			return err
+		}
 	}
 
 	// "Set up the interrupt mask and enable interrupts"
@@ -236,7 +322,9 @@ func (d *Device) Init(cfg Config) (err error) {
 		if time.Since(deadline) >= 0 {
 			return errors.New("wifi startup timeout")
 		}
-		time.Sleep(time.Millisecond)
+		if err = d.sleep(initPollInterval); err != nil {
+			return err
+		}
 	}
 
 	// Clear pulls.
@@ -256,7 +344,9 @@ func (d *Device) Init(cfg Config) (err error) {
 		} else if time.Since(deadline) > 0 {
 			return errors.New("ht clock timeout")
 		}
-		time.Sleep(time.Millisecond)
+		if err = d.sleep(initPollInterval); err != nil {
+			return err
+		}
 	}
 
 	err = d.log_init()
@@ -264,6 +354,7 @@ func (d *Device) Init(cfg Config) (err error) {
 		return err
 	}
 	d.log_read()
+	d.timings.Boot = time.Since(stage)
 	d.debug("base init done")
 	if cfg.CLM == "" {
 		return nil
@@ -277,9 +368,11 @@ func (d *Device) Init(cfg Config) (err error) {
 		return err
 	}
 
-	err = d.set_power_management(pmPowerSave)
+	err = d.set_power_management(cfg.PowerManagement)
 	d.state = linkStateDown
-	d.info("Init:done", slog.Duration("took", time.Since(start)))
+	d.info("Init:done", slog.Duration("took", time.Since(start)), slog.Duration("bus", d.timings.Bus),
+		slog.Duration("fw", d.timings.Firmware), slog.Duration("boot", d.timings.Boot),
+		slog.Duration("clm", d.timings.CLM), slog.Duration("ctl", d.timings.Control))
 	return err
 }
 
@@ -329,6 +422,10 @@ func (d *Device) reset() {
 	d.mode = 0
 	d.backplaneWindow = 0
 	d.state = 0
+	d.apUp = false
+	d.apConcurrent = false
+	d.monitor = monitorState{}
+	d.actframe = actionFrameState{}
 	d.ioctlID = 0
 	d.sdpcmSeq = 0
 	d.sdpcmSeqMax = 1
@@ -352,6 +449,32 @@ func (d *Device) acquire(mode opMode) error {
 	return nil
 }
 
+// clearContext clears the context set by a context aware operation.
+func (d *Device) clearContext() { d.ctx = nil }
+
+// ctxErr returns the error of the ongoing operation's context, if any.
+func (d *Device) ctxErr() error {
+	if d.ctx == nil {
+		return nil
+	}
+	return d.ctx.Err()
+}
+
+// sleep sleeps for dur and returns the operation's context error if it
+// was canceled in the meantime. Long sleeps are checked for cancellation every 10ms.
+func (d *Device) sleep(dur time.Duration) error {
+	const step = 10 * time.Millisecond
+	for dur > step {
+		time.Sleep(step)
+		dur -= step
+		if err := d.ctxErr(); err != nil {
+			return err
+		}
+	}
+	time.Sleep(dur)
+	return d.ctxErr()
+}
+
 func (d *Device) release() {
 	d.mu.Unlock()
 }
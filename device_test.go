@@ -0,0 +1,262 @@
+package cyw43439
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+const testFirmware = "emulated firmware\x00Version: 7.95.61\x00"
+
+var (
+	testOpenNet = cywemu.Network{SSID: "open-net", BSSID: [6]byte{0x02, 1, 1, 1, 1, 1}, Channel: 6, RSSI: -40}
+	testWPA2Net = cywemu.Network{SSID: "wpa2-net", BSSID: [6]byte{0x02, 2, 2, 2, 2, 2}, Channel: 11, RSSI: -60, Passphrase: "password123"}
+)
+
+func newTestDevice(t *testing.T, networks ...cywemu.Network) (*Device, *cywemu.Chip) {
+	t.Helper()
+	return newTestDeviceConfig(t, cywemu.Config{Networks: networks}, testConfig())
+}
+
+// testConfig returns a configuration that loads the emulated firmware.
+func testConfig() Config {
+	return Config{
+		Firmware: testFirmware,
+		CLM:      "emulated clm",
+		mode:     modeInit | modeWifi,
+	}
+}
+
+func newTestDeviceConfig(t *testing.T, emucfg cywemu.Config, cfg Config) (*Device, *cywemu.Chip) {
+	t.Helper()
+	chip := cywemu.New(emucfg)
+	dev := New(chip.Power, func(bool) {}, chip)
+	err := dev.Init(cfg)
+	if err != nil {
+		t.Fatal("init:", err, chip.Err())
+	}
+	t.Cleanup(func() {
+		if err := chip.Err(); err != nil {
+			t.Error("protocol violations:", err)
+		}
+	})
+	return dev, chip
+}
+
+func TestInit(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	if !chip.Running() {
+		t.Fatal("firmware not running")
+	}
+	var fw [len(testFirmware)]byte
+	chip.ReadRAM(0, fw[:])
+	if string(fw[:]) != testFirmware {
+		t.Errorf("firmware not uploaded, got %q", fw[:])
+	}
+	nvram := chip.NVRAM()
+	if !bytes.HasPrefix(nvram, []byte(nvram43439)) {
+		t.Error("nvram not uploaded")
+	}
+	mac, err := dev.HardwareAddr6()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac != [6]byte{0x02, 0x00, 0x00, 0x43, 0x94, 0x39} {
+		t.Errorf("unexpected MAC %x", mac)
+	}
+	var gotCLM, gotUp bool
+	for _, io := range chip.Ioctls() {
+		gotCLM = gotCLM || io.Name == "clmload"
+		gotUp = gotUp || io.Cmd == whd.WLC_UP
+	}
+	if !gotCLM || !gotUp {
+		t.Errorf("missing ioctls: clmload=%v up=%v", gotCLM, gotUp)
+	}
+}
+
+func TestJoinOpen(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet, testWPA2Net)
+	var phases []JoinPhase
+	err := dev.Join(testOpenNet.SSID, JoinOptions{Progress: func(p JoinPhase) { phases = append(phases, p) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dev.IsLinkUp() {
+		t.Fatal("link not up after join")
+	}
+	if phases[len(phases)-1] != JoinConnected {
+		t.Errorf("last phase %v, want connected", phases[len(phases)-1])
+	}
+	li, err := dev.LinkInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if li.SSID() != testOpenNet.SSID || li.BSSID != testOpenNet.BSSID || li.Channel != testOpenNet.Channel || li.RSSI != testOpenNet.RSSI {
+		t.Errorf("unexpected link info %+v", li)
+	}
+	err = dev.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IsLinkUp() {
+		t.Error("link up after leave")
+	}
+	if _, ok := chip.Associated(); ok {
+		t.Error("chip still associated after leave")
+	}
+}
+
+func TestJoinWPA2(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet, testWPA2Net)
+	err := dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: "wrong-password"})
+	var jerr *JoinError
+	if !errors.As(err, &jerr) || jerr.Reason != JoinFailWrongPassphrase {
+		t.Fatalf("want wrong passphrase join error, got %v", err)
+	}
+	if dev.IsLinkUp() {
+		t.Fatal("link up after failed join")
+	}
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: testWPA2Net.Passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := chip.Associated(); !dev.IsLinkUp() || !ok || n.SSID != testWPA2Net.SSID {
+		t.Fatal("not associated after join")
+	}
+}
+
+func TestJoinNotFound(t *testing.T) {
+	dev, _ := newTestDevice(t, testOpenNet)
+	err := dev.Join("missing-net", JoinOptions{})
+	var jerr *JoinError
+	if !errors.As(err, &jerr) || jerr.Reason != JoinFailNotFound {
+		t.Fatalf("want not found join error, got %v", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	hidden := cywemu.Network{SSID: "hidden-net", BSSID: [6]byte{0x02, 3, 3, 3, 3, 3}, Channel: 1, Hidden: true}
+	dev, _ := newTestDevice(t, testOpenNet, testWPA2Net, hidden)
+	var results []ScanResult
+	err := dev.Scan(ScanOptions{}, func(sr ScanResult) { results = append(results, sr) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want 2 scan results, got %d", len(results))
+	}
+	for _, sr := range results {
+		switch sr.SSID() {
+		case testOpenNet.SSID:
+			if sr.Security != 0 || sr.Channel != testOpenNet.Channel {
+				t.Errorf("unexpected open network result %+v", sr)
+			}
+		case testWPA2Net.SSID:
+			if sr.Security.JoinAuth() != JoinAuthWPA2 || sr.BSSID != testWPA2Net.BSSID || sr.RSSI != testWPA2Net.RSSI {
+				t.Errorf("unexpected wpa2 network result %+v", sr)
+			}
+		default:
+			t.Errorf("unexpected network %q", sr.SSID())
+		}
+	}
+}
+
+func TestStartAPSendEth(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	err := dev.StartAP("emu-ap", "password123", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssid, up := chip.AP(); !up || ssid != "emu-ap" {
+		t.Fatalf("access point not up: ssid=%q up=%v", ssid, up)
+	}
+	frame := make([]byte, 60)
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	frame[12], frame[13] = 0x08, 0x06 // ARP.
+	err = dev.SendEth(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := chip.Frames()
+	if len(frames) != 1 || frames[0].Iface != whd.IF_STA || !bytes.Equal(frames[0].Data, frame) {
+		t.Fatalf("frame not received by chip: %+v", frames)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet)
+	var kinds []EventKind
+	err := dev.SetEventHandler(func(ev Event) { kinds = append(kinds, ev.Kind) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rx []byte
+	dev.RecvEthHandle(func(pkt []byte) error {
+		rx = append(rx[:0], pkt...)
+		return nil
+	})
+	err = dev.Join(testOpenNet.SSID, JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := []byte{0x02, 0x00, 0x00, 0x43, 0x94, 0x39, 0x02, 1, 1, 1, 1, 1, 0x08, 0x00, 'h', 'i'}
+	chip.InjectEthernet(whd.IF_STA, frame)
+	_, err = dev.PollOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rx, frame) {
+		t.Errorf("received %x, want %x", rx, frame)
+	}
+
+	chip.Disconnect(3)
+	for i := 0; i < 2; i++ {
+		_, err = dev.PollOne()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dev.IsLinkUp() {
+		t.Error("link up after deauthentication")
+	}
+	want := []EventKind{EventLinkUp, EventDeauth, EventLinkDown}
+	if len(kinds) != len(want) {
+		t.Fatalf("got events %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("got events %v, want %v", kinds, want)
+		}
+	}
+}
+
+func TestInitTimings(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	tm := dev.InitTimings()
+	stages := []time.Duration{tm.Bus, tm.Firmware, tm.Boot, tm.CLM, tm.Control}
+	var sum time.Duration
+	for i, d := range stages {
+		if d <= 0 {
+			t.Errorf("stage %d not timed: %+v", i, tm)
+		}
+		sum += d
+	}
+	if tm.Total < sum {
+		t.Errorf("total %s less than sum of stages %s", tm.Total, sum)
+	}
+	// Bring-up is confirmed by readback instead of fixed 100ms sleeps.
+	if tm.Control >= 300*time.Millisecond {
+		t.Errorf("radio configuration took %s", tm.Control)
+	}
+	if countIoctls(chip, whd.WLC_GET_UP, "") == 0 {
+		t.Error("radio up not confirmed")
+	}
+	if n := countIoctls(chip, whd.WLC_SET_VAR, "bus:txglom"); n != 2 {
+		t.Errorf("txglom set %d times, want 2", n)
+	}
+}
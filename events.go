@@ -0,0 +1,189 @@
+package cyw43439
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/soypat/cyw43439/internal/netlink"
+	"github.com/soypat/cyw43439/whd"
+)
+
+var errInvalidEvent = errors.New("invalid event type")
+
+// EventKind classifies events delivered to the handler set with [Device.SetEventHandler].
+type EventKind uint8
+
+const (
+	// EventOther is an event enabled with [Device.SetEventHandler] that is not classified by the driver.
+	EventOther EventKind = iota
+	// EventLinkUp is delivered when the link goes up after a successful join or access point start.
+	EventLinkUp
+	// EventLinkDown is delivered when the link is lost or the access point is stopped.
+	// The event message is the one that caused the link loss.
+	EventLinkDown
+	// EventDeauth is delivered when the access point deauthenticates the device.
+	// The 802.11 reason code is stored in the event message Reason field.
+	EventDeauth
+	// EventDisassoc is delivered when the device is disassociated from the access point.
+	// The 802.11 reason code is stored in the event message Reason field.
+	EventDisassoc
+	// EventKeyExchangeFailed is delivered when the WPA key exchange (PSK_SUP) fails.
+	EventKeyExchangeFailed
+	// EventStationJoined is delivered when a station associates with the access point.
+	// The station MAC address is stored in the event message Addr field.
+	EventStationJoined
+	// EventStationLeft is delivered when a station disassociates or deauthenticates from the access point.
+	// The station MAC address is stored in the event message Addr field and
+	// the 802.11 reason code in the Reason field.
+	EventStationLeft
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventOther:
+		return "other"
+	case EventLinkUp:
+		return "linkup"
+	case EventLinkDown:
+		return "linkdown"
+	case EventDeauth:
+		return "deauth"
+	case EventDisassoc:
+		return "disassoc"
+	case EventKeyExchangeFailed:
+		return "keyexchangefailed"
+	case EventStationJoined:
+		return "stationjoined"
+	case EventStationLeft:
+		return "stationleft"
+	}
+	return "EventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Event is an asynchronous event received from the CYW43439 firmware.
+type Event struct {
+	Kind EventKind
+	// Message is the decoded firmware event message. It is the zero value
+	// for link down events not caused by a firmware event, i.e: [Device.Leave] timing out.
+	Message whd.EventMessage
+	// Payload is the event data following the event message.
+	// It is only valid during the handler call and must be copied to be retained.
+	Payload []byte
+}
+
+// Type returns the firmware event type.
+func (e *Event) Type() whd.AsyncEventType { return e.Message.EventType }
+
+// Status returns the firmware event status.
+func (e *Event) Status() whd.EStatus { return whd.EStatus(e.Message.Status) }
+
+// Reason returns the event reason. For deauthentication and disassociation
+// events this is the 802.11 reason code sent by the access point.
+func (e *Event) Reason() uint32 { return e.Message.Reason }
+
+// SetEventHandler sets the handler called on asynchronous firmware events.
+// Link up/down, deauthentication, disassociation, key exchange failure and
+// access point station join/leave events are always delivered. Additional event types can be subscribed to by listing them in events;
+// these are delivered with [EventOther] kind unless classified by the driver.
+// If handler is nil events are no longer delivered.
+//
+// Events are delivered during [Device.PollOne] and other calls that read from the device.
+// The handler is called with the device lock held so it must not call methods on the Device.
+func (d *Device) SetEventHandler(handler func(Event), events ...whd.AsyncEventType) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	var usermask eventMask
+	for _, ev := range events {
+		if int(ev) >= 8*len(usermask.events) {
+			return errInvalidEvent
+		}
+		usermask.Enable(ev)
+	}
+	if handler == nil {
+		usermask = eventMask{}
+	}
+	d.info("SetEventHandler", slog.Bool("set", handler != nil), slog.Int("nevents", len(events)))
+	if usermask != d.usermask {
+		// Firmware filters out some events by default, make sure subscribed events are sent by firmware.
+		fwmask := d.firmwareEventMask(&usermask)
+		err = d.setFirmwareEventMask(&fwmask)
+		if err != nil {
+			return err
+		}
+	}
+	d.usermask = usermask
+	d.evHandler = handler
+	return nil
+}
+
+// defaultFirmwareEventMask returns the mask of events the firmware sends to the host on initialization.
+func defaultFirmwareEventMask() (evts eventMask) {
+	for i := range evts.events {
+		evts.events[i] = 0xff
+	}
+	// Ignore uninteresting/spammy events.
+	evts.Disable(whd.EvRADIO)
+	evts.Disable(whd.EvIF)
+	evts.Disable(whd.EvPROBREQ_MSG)
+	evts.Disable(whd.EvPROBREQ_MSG_RX)
+	evts.Disable(whd.EvPROBRESP_MSG)
+	evts.Disable(whd.EvROAM)
+	return evts
+}
+
+// firmwareEventMask returns the default firmware event mask with the user subscribed
+// events and events needed by enabled features, such as probe request reception, enabled.
+func (d *Device) firmwareEventMask(usermask *eventMask) eventMask {
+	fwmask := defaultFirmwareEventMask()
+	for i := range fwmask.events {
+		fwmask.events[i] |= usermask.events[i]
+	}
+	if d.probeHandler != nil {
+		fwmask.Enable(whd.EvPROBREQ_MSG)
+		fwmask.Enable(whd.EvPROBREQ_MSG_RX)
+	}
+	return fwmask
+}
+
+func (d *Device) setFirmwareEventMask(evts *eventMask) error {
+	var buf [4 + 24]byte
+	evts.Put(buf[:])
+	return d.set_iovar_n("bsscfg:event_msgs", whd.IF_STA, buf[:evts.Size()])
+}
+
+// notifyEvent delivers the event to the user handler. prevState is the
+// link state before the event was processed.
+func (d *Device) notifyEvent(kind EventKind, prevState linkState, msg *whd.EventMessage, payload []byte) {
+	if d.evHandler != nil && (kind != EventOther || d.usermask.IsEnabled(msg.EventType)) {
+		d.evHandler(Event{Kind: kind, Message: *msg, Payload: payload})
+	}
+	d.notifyLinkState(prevState, msg, payload)
+}
+
+// notifyLinkState delivers link up/down events to the user handler and
+// netlink callback if the link state changed.
+func (d *Device) notifyLinkState(prevState linkState, msg *whd.EventMessage, payload []byte) {
+	wentUp := prevState != linkStateUp && d.state == linkStateUp
+	wentDown := prevState == linkStateUp && d.state != linkStateUp
+	if !wentUp && !wentDown {
+		return
+	}
+	if d.netlink.notify != nil {
+		if wentUp {
+			d.netlink.notify(netlink.EventNetUp)
+		} else {
+			d.netlink.notify(netlink.EventNetDown)
+		}
+	}
+	if d.evHandler == nil {
+		return
+	} else if wentUp {
+		d.evHandler(Event{Kind: EventLinkUp, Message: *msg, Payload: payload})
+	} else {
+		d.evHandler(Event{Kind: EventLinkDown, Message: *msg, Payload: payload})
+	}
+}
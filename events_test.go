@@ -0,0 +1,90 @@
+package cyw43439
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+// pollAll processes the frames queued by the chip.
+func pollAll(t *testing.T, dev *Device, chip *cywemu.Chip) {
+	t.Helper()
+	for {
+		_, err := dev.PollOne()
+		if err != nil {
+			t.Fatal(err)
+		} else if chip.LastStatus()&whd.STATUS_F2_PKT_AVAILABLE == 0 {
+			return
+		}
+	}
+}
+
+func TestEventSubscription(t *testing.T) {
+	dev, chip := newTestDevice(t, testWPA2Net)
+	var events []Event
+	handler := func(ev Event) {
+		ev.Payload = append([]byte(nil), ev.Payload...)
+		events = append(events, ev)
+	}
+	payload := []byte("probe response")
+	probeResp := whd.EventMessage{EventType: whd.EvPROBRESP_MSG, Addr: [6]byte{0x02, 9, 9, 9, 9, 9}}
+
+	// Probe responses are disabled in the firmware by default.
+	err := dev.SetEventHandler(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip.QueueEvent(whd.IF_STA, probeResp, payload)
+	pollAll(t, dev, chip)
+	if len(events) != 0 {
+		t.Fatalf("unsubscribed event delivered: %+v", events)
+	}
+
+	err = dev.SetEventHandler(handler, whd.EvPROBRESP_MSG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip.QueueEvent(whd.IF_STA, probeResp, payload)
+	pollAll(t, dev, chip)
+	if len(events) != 1 {
+		t.Fatalf("want 1 subscribed event, got %d", len(events))
+	}
+	ev := events[0]
+	if ev.Kind != EventOther || ev.Type() != whd.EvPROBRESP_MSG || ev.Message.Addr != probeResp.Addr || !bytes.Equal(ev.Payload, payload) {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	// Failed key exchange is always delivered.
+	events = events[:0]
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: "wrong-password"})
+	if err == nil {
+		t.Fatal("join with wrong passphrase succeeded")
+	}
+	var gotKeyFail bool
+	for _, ev := range events {
+		gotKeyFail = gotKeyFail || ev.Kind == EventKeyExchangeFailed
+	}
+	if !gotKeyFail {
+		t.Errorf("key exchange failure not delivered, got %+v", events)
+	}
+
+	// Removing the handler unsubscribes from events.
+	err = dev.SetEventHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = events[:0]
+	chip.QueueEvent(whd.IF_STA, probeResp, payload)
+	pollAll(t, dev, chip)
+	if len(events) != 0 {
+		t.Errorf("event delivered after handler removed: %+v", events)
+	}
+
+	err = dev.SetEventHandler(handler, whd.AsyncEventType(8*len(eventMask{}.events)))
+	if !errors.Is(err, errInvalidEvent) {
+		t.Errorf("want invalid event error, got %v", err)
+	}
+}
@@ -101,10 +101,15 @@ const (
 	MTU = MaxFrameSize - ethHeaderSize
 )
 
-// tx transmits a SDPCM+BDC data packet to the device.
-func (d *Device) tx(packet []byte) (err error) {
-	if !d.IsLinkUp() {
+// tx transmits a SDPCM+BDC data packet to the device over the given interface.
+func (d *Device) tx(iface whd.IoctlInterface, packet []byte) (err error) {
+	switch {
+	case iface == whd.IF_AP && !(d.apUp && d.apConcurrent):
+		return errLinkDown
+	case iface == whd.IF_STA && !d.IsLinkUp():
 		return errLinkDown
+	case iface != whd.IF_STA && iface != whd.IF_AP:
+		return errInvalidIoctlIface
 	}
 	// reference: https://github.com/embassy-rs/embassy/blob/6babd5752e439b234151104d8d20bae32e41d714/cyw43/src/runner.rs#L247
 	d.debug("tx", slog.Int("len", len(packet)))
@@ -137,7 +142,8 @@ func (d *Device) tx(packet []byte) (err error) {
 	d.lastSDPCMHeader.Put(_busOrder, buf8[:whd.SDPCM_HEADER_LEN])
 
 	d.auxBDCHeader = whd.BDCHeader{
-		Flags: 2 << 4, // BDC version.
+		Flags:  2 << 4,       // BDC version.
+		Flags2: uint8(iface), // Interface index.
 	}
 	d.auxBDCHeader.Put(buf8[whd.SDPCM_HEADER_LEN+paddingSize:])
 
@@ -173,6 +179,13 @@ func (d *Device) get_iovar_n(VAR string, iface whd.IoctlInterface, res []byte) (
 	return plen, err
 }
 
+// get_ioctl gets a uint32 value with an ioctl GET command.
+func (d *Device) get_ioctl(cmd whd.SDPCMCommand, iface whd.IoctlInterface) (uint32, error) {
+	var val uint32
+	_, err := d.doIoctlGet(cmd, iface, u32PtrTo4U8(&val)[:4])
+	return val, err
+}
+
 // reference: ioctl_set_u32
 func (d *Device) set_ioctl(cmd whd.SDPCMCommand, iface whd.IoctlInterface, val uint32) error {
 	return d.doIoctlSet(cmd, iface, u32PtrTo4U8(&val)[:4])
@@ -378,14 +391,15 @@ func (d *Device) waitForCredit(buf []uint32) error {
 // pollForIoctl polls until a control/ioctl/cdc packet is received.
 func (d *Device) pollForIoctl(buf []uint32) ([]byte, error) {
 	d.trace("pollForIoctl:start")
-	for retries := 0; retries < 10; retries++ {
+	// Responses usually arrive within a millisecond, poll often to not add latency to every ioctl.
+	for retries := 0; retries < 100; retries++ {
 		buf8, hdr, err := d.tryPoll(buf)
 		if err != nil && err != errNoF2Avail {
 			return nil, err
 		} else if hdr == whd.CONTROL_HEADER {
 			return buf8, nil
 		}
-		time.Sleep(10 * time.Millisecond)
+		time.Sleep(time.Millisecond)
 	}
 	return nil, errors.New("pollForIoctl timeout")
 }
@@ -531,7 +545,7 @@ func (d *Device) rxEvent(packet []byte) (err error) {
 		)
 	}
 	ev := aePacket.Message.EventType
-	if !d.eventmask.IsEnabled(ev) {
+	if !d.eventmask.IsEnabled(ev) && !d.usermask.IsEnabled(ev) {
 		return nil
 	}
 	// Event handling follows embassy-rs runner.rs:769-842.
@@ -539,8 +553,41 @@ func (d *Device) rxEvent(packet []byte) (err error) {
 	// Link is UP when: joinOK && (!secureNetwork || keyExchangeOK)  ref: runner.rs:826
 	status := whd.EStatus(aePacket.Message.Status)
 	msg := &aePacket.Message
+	evData := bdcPacket[72:]
+	if int(msg.DataLen) < len(evData) {
+		evData = evData[:msg.DataLen]
+	}
 	updateLinkStatus := false
+	prevState := d.state
+	kind := EventOther
 	switch {
+	case !d.eventmask.IsEnabled(ev):
+		// Only subscribed to by user.
+
+	case ev == whd.EvESCAN_RESULT:
+		return d.rxScanResult(status, evData)
+
+	case ev == whd.EvPROBREQ_MSG || ev == whd.EvPROBREQ_MSG_RX:
+		return d.rxProbeRequest(evData)
+
+	case ev == whd.EvACTION_FRAME_RX:
+		return d.rxActionFrame(msg.Addr, evData)
+
+	case ev == whd.EvACTION_FRAME_COMPLETE:
+		d.actframe.txDone = true
+		d.actframe.txStatus = status
+
+	// Stations joining and leaving the access point. Station address is in msg.Addr.
+	// In concurrent mode these arrive on the AP interface, on the station interface
+	// they report the upstream access point and are handled as station events.
+	case (ev == whd.EvASSOC_IND || ev == whd.EvREASSOC_IND) && d.isAPEvent(msg):
+		kind = EventStationJoined
+	case (ev == whd.EvDISASSOC_IND || ev == whd.EvDEAUTH_IND) && d.isAPEvent(msg):
+		kind = EventStationLeft
+
+	// Other events on the concurrent mode AP interface do not affect station link state.
+	case d.apConcurrent && whd.IoctlInterface(msg.IFIdx) == whd.IF_AP:
+
 	// SET_SSID is used by wait_for_join (control.rs:413-419) to detect join completion/failure.
 	case ev == whd.EvSET_SSID:
 		switch {
@@ -568,6 +615,11 @@ func (d *Device) rxEvent(packet []byte) (err error) {
 		d.joinOK = false
 		d.keyExchangeOK = false
 		updateLinkStatus = true
+		if ev == whd.EvDEAUTH {
+			kind = EventDeauth
+		} else if ev == whd.EvDISASSOC {
+			kind = EventDisassoc
+		}
 
 	// Update auth flag; ignore unsolicited events. ref: runner.rs:787-794
 	// When changing passwords on a WPA3 AP we're connected to, or when roaming,
@@ -598,6 +650,7 @@ func (d *Device) rxEvent(packet []byte) (err error) {
 	case ev == whd.EvPSK_SUP:
 		d.keyExchangeOK = false
 		updateLinkStatus = true
+		kind = EventKeyExchangeFailed
 	}
 
 	if updateLinkStatus {
@@ -608,6 +661,17 @@ func (d *Device) rxEvent(packet []byte) (err error) {
 			d.state = linkStateDown
 		}
 	}
+	if d.joinst.active && whd.IoctlInterface(msg.IFIdx) == whd.IF_STA {
+		d.joinst.update(msg)
+	}
+	d.notifyEvent(kind, prevState, msg, evData)
+	if prevState == linkStateUp && d.state != linkStateUp {
+		d.reconn.linkLost(time.Now())
+	}
+	if d.apConcurrent && d.state == linkStateUp && (prevState != linkStateUp || ev == whd.EvLINK) {
+		// Station may have joined or roamed to another channel.
+		d.apFollowPending = true
+	}
 
 	if d.logenabled(slog.LevelInfo) {
 		d.info("rxEvent",
@@ -626,14 +690,26 @@ func (d *Device) rxEvent(packet []byte) (err error) {
 
 func (d *Device) rxData(packet []byte) (err error) {
 	d.trace("rxData:start")
-	if d.rcvEth != nil {
-		bdcHdr := whd.DecodeBDCHeader(packet)
+	bdcHdr := whd.DecodeBDCHeader(packet)
+	if d.monitor.active {
+		packetStart := whd.BDC_HEADER_LEN + 4*int(bdcHdr.DataOffset)
+		if packetStart > len(packet) {
+			return errInvalidRxBDCHeaderLen
+		}
+		d.rxMonitor(packet[packetStart:])
+		return nil
+	}
+	handler := d.rcvEth
+	if whd.IoctlInterface(bdcHdr.Flags2&whd.BDC_FLAG2_IF_MASK) == whd.IF_AP {
+		handler = d.rcvEthAP
+	}
+	if handler != nil {
 		packetStart := whd.BDC_HEADER_LEN + 4*int(bdcHdr.DataOffset)
 		if packetStart > len(packet) {
 			return errInvalidRxBDCHeaderLen
 		}
 		payload := packet[packetStart:]
-		return d.rcvEth(payload)
+		return handler(payload)
 	}
 	return nil
 }
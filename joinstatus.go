@@ -0,0 +1,183 @@
+package cyw43439
+
+import (
+	"strconv"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+// JoinPhase is a stage of the join procedure reported to [JoinOptions.Progress].
+type JoinPhase uint8
+
+const (
+	// JoinScanning is reported when the device starts looking for the network.
+	JoinScanning JoinPhase = iota + 1
+	// JoinAuthenticating is reported when the access point was found and responded to 802.11 authentication.
+	JoinAuthenticating
+	// JoinAssociating is reported when authentication succeeded and the device associates with the access point.
+	JoinAssociating
+	// JoinKeyExchange is reported when the device associated with a secure network
+	// and performs the WPA 4-way handshake.
+	JoinKeyExchange
+	// JoinConnected is reported when the link is up.
+	JoinConnected
+)
+
+func (p JoinPhase) String() string {
+	switch p {
+	case JoinScanning:
+		return "scanning"
+	case JoinAuthenticating:
+		return "authenticating"
+	case JoinAssociating:
+		return "associating"
+	case JoinKeyExchange:
+		return "keyexchange"
+	case JoinConnected:
+		return "connected"
+	}
+	return "JoinPhase(" + strconv.Itoa(int(p)) + ")"
+}
+
+// JoinFailure is the reason a join failed. See [JoinError].
+type JoinFailure uint8
+
+const (
+	// JoinFailTimeout means the join did not complete within the join timeout.
+	JoinFailTimeout JoinFailure = iota + 1
+	// JoinFailNotFound means no access point with the SSID (and BSSID, if set) was found.
+	JoinFailNotFound
+	// JoinFailAuthRejected means the access point rejected 802.11 authentication.
+	// WPA3 networks report a wrong password as an authentication rejection.
+	JoinFailAuthRejected
+	// JoinFailAssocRejected means the access point rejected the association.
+	JoinFailAssocRejected
+	// JoinFailWrongPassphrase means the WPA 4-way handshake failed, usually due to a wrong passphrase.
+	JoinFailWrongPassphrase
+	// JoinFailOther means the firmware reported a failure with no further detail.
+	JoinFailOther
+)
+
+func (f JoinFailure) String() string {
+	switch f {
+	case JoinFailTimeout:
+		return "timeout"
+	case JoinFailNotFound:
+		return "network not found"
+	case JoinFailAuthRejected:
+		return "authentication rejected"
+	case JoinFailAssocRejected:
+		return "association rejected"
+	case JoinFailWrongPassphrase:
+		return "wrong passphrase"
+	case JoinFailOther:
+		return "failed"
+	}
+	return "JoinFailure(" + strconv.Itoa(int(f)) + ")"
+}
+
+// JoinError is returned by [Device.Join] when the device fails to join a network.
+type JoinError struct {
+	Reason JoinFailure
+	// Status is the 802.11 status code sent by the access point when rejecting
+	// authentication or association. For key exchange failures it is the firmware
+	// supplicant reason code. Zero if not applicable.
+	Status uint16
+}
+
+func (e *JoinError) Error() string {
+	s := "join: " + e.Reason.String()
+	if e.Status != 0 {
+		s += " (status " + strconv.Itoa(int(e.Status)) + ")"
+	}
+	return s
+}
+
+// joinState tracks progress of a join in progress from firmware events.
+type joinState struct {
+	active   bool
+	secure   bool
+	progress func(JoinPhase)
+	phase    JoinPhase
+	failure  JoinFailure
+	status   uint16
+	// terminal is set when a failure the firmware does not recover from was seen.
+	terminal bool
+}
+
+func (js *joinState) start(secure bool, progress func(JoinPhase)) {
+	*js = joinState{active: true, secure: secure, progress: progress}
+	js.setPhase(JoinScanning)
+}
+
+func (js *joinState) setPhase(phase JoinPhase) {
+	if phase <= js.phase {
+		return
+	}
+	js.phase = phase
+	if js.progress != nil {
+		js.progress(phase)
+	}
+}
+
+func (js *joinState) fail(reason JoinFailure, status uint16) {
+	js.failure = reason
+	js.status = status
+}
+
+// update processes a firmware event received during a join.
+func (js *joinState) update(msg *whd.EventMessage) {
+	status := whd.EStatus(msg.Status)
+	switch msg.EventType {
+	case whd.EvAUTH:
+		if status == whd.EStatusUnsolicited {
+			break
+		}
+		js.setPhase(JoinAuthenticating)
+		if status == whd.EStatusSuccess {
+			js.setPhase(JoinAssociating)
+		} else {
+			js.fail(JoinFailAuthRejected, uint16(msg.Reason))
+		}
+
+	case whd.EvASSOC, whd.EvREASSOC:
+		if status != whd.EStatusSuccess {
+			js.fail(JoinFailAssocRejected, uint16(msg.Reason))
+		} else if js.secure {
+			js.setPhase(JoinKeyExchange)
+		}
+
+	case whd.EvJOIN:
+		if status == whd.EStatusSuccess && js.secure {
+			js.setPhase(JoinKeyExchange)
+		}
+
+	case whd.EvPSK_SUP:
+		// Success and roaming events. See rxEvent.
+		if (status == whd.EStatusUnsolicited && msg.Flags == 0 && msg.Reason == 0) || msg.Reason == 14 {
+			break
+		}
+		js.fail(JoinFailWrongPassphrase, uint16(msg.Reason))
+		js.terminal = true
+
+	case whd.EvSET_SSID:
+		if status == whd.EStatusNoNetworks || (status != whd.EStatusSuccess && js.phase < JoinAuthenticating) {
+			js.fail(JoinFailNotFound, 0)
+		} else if status != whd.EStatusSuccess && js.failure == 0 {
+			js.fail(JoinFailOther, 0)
+		}
+	}
+}
+
+// result returns the outcome of the join given the final link state.
+func (js *joinState) result(state linkState) error {
+	js.active = false
+	if state == linkStateUp {
+		js.setPhase(JoinConnected)
+		return nil
+	}
+	if js.failure == 0 {
+		return &JoinError{Reason: JoinFailTimeout}
+	}
+	return &JoinError{Reason: js.failure, Status: js.status}
+}
@@ -0,0 +1,99 @@
+package cyw43439
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+func TestJoinProgress(t *testing.T) {
+	dev, _ := newTestDevice(t, testOpenNet, testWPA2Net)
+	var phases []JoinPhase
+	progress := func(p JoinPhase) { phases = append(phases, p) }
+	for _, test := range []struct {
+		net  cywemu.Network
+		want []JoinPhase
+	}{
+		{net: testOpenNet, want: []JoinPhase{JoinScanning, JoinAuthenticating, JoinAssociating, JoinConnected}},
+		{net: testWPA2Net, want: []JoinPhase{JoinScanning, JoinAuthenticating, JoinAssociating, JoinKeyExchange, JoinConnected}},
+	} {
+		phases = phases[:0]
+		err := dev.Join(test.net.SSID, JoinOptions{Passphrase: test.net.Passphrase, Progress: progress})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(phases, test.want) {
+			t.Errorf("%s: got phases %v, want %v", test.net.SSID, phases, test.want)
+		}
+		if err := dev.Leave(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJoinError(t *testing.T) {
+	// WLC_SET_SSID joins are rejected during authentication or left unanswered.
+	var chip *cywemu.Chip
+	var authReject, silent bool
+	onIoctl := func(io *cywemu.Ioctl) bool {
+		if io.Cmd != whd.WLC_SET_SSID || !(authReject || silent) {
+			return false
+		}
+		if authReject {
+			const statusUnsupportedAuthAlg = 13
+			chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvAUTH, Status: uint32(whd.EStatusFail), Reason: statusUnsupportedAuthAlg}, nil)
+			chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvSET_SSID, Status: uint32(whd.EStatusFail)}, nil)
+		}
+		return true
+	}
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{Networks: []cywemu.Network{testOpenNet, testWPA2Net}, OnIoctl: onIoctl}, testConfig())
+
+	for _, test := range []struct {
+		name       string
+		ssid       string
+		opts       JoinOptions
+		authReject bool
+		silent     bool
+		want       JoinFailure
+		wantStatus uint16
+		wantPhase  JoinPhase
+	}{
+		{name: "not found", ssid: "missing-net", want: JoinFailNotFound, wantPhase: JoinScanning},
+		{name: "wrong passphrase", ssid: testWPA2Net.SSID, opts: JoinOptions{Passphrase: "wrong-password"},
+			want: JoinFailWrongPassphrase, wantStatus: 15, wantPhase: JoinKeyExchange},
+		{name: "security mismatch", ssid: testWPA2Net.SSID, opts: JoinOptions{Auth: JoinAuthOpen},
+			want: JoinFailAssocRejected, wantStatus: 1, wantPhase: JoinAssociating},
+		{name: "auth rejected", ssid: testOpenNet.SSID, authReject: true, want: JoinFailAuthRejected, wantStatus: 13, wantPhase: JoinAuthenticating},
+		{name: "timeout", ssid: testOpenNet.SSID, opts: JoinOptions{Timeout: 50 * time.Millisecond}, silent: true,
+			want: JoinFailTimeout, wantPhase: JoinScanning},
+	} {
+		authReject, silent = test.authReject, test.silent
+		var last JoinPhase
+		test.opts.Progress = func(p JoinPhase) { last = p }
+		start := time.Now()
+		err := dev.Join(test.ssid, test.opts)
+		var jerr *JoinError
+		if !errors.As(err, &jerr) {
+			t.Fatalf("%s: want join error, got %v", test.name, err)
+		}
+		if jerr.Reason != test.want || jerr.Status != test.wantStatus {
+			t.Errorf("%s: got %v, want %v status %d", test.name, jerr, test.want, test.wantStatus)
+		}
+		if last != test.wantPhase {
+			t.Errorf("%s: last phase %v, want %v", test.name, last, test.wantPhase)
+		}
+		if test.silent && time.Since(start) > time.Second {
+			t.Errorf("%s: join timeout not applied, took %s", test.name, time.Since(start))
+		}
+		if dev.IsLinkUp() {
+			t.Fatalf("%s: link up after failed join", test.name)
+		}
+	}
+	if s := (&JoinError{Reason: JoinFailAuthRejected, Status: 13}).Error(); s != "join: authentication rejected (status 13)" {
+		t.Errorf("got error string %q", s)
+	}
+}
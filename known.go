@@ -0,0 +1,168 @@
+package cyw43439
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+)
+
+var (
+	errNoKnownNetworks   = errors.New("no known networks")
+	errTooManyKnown      = errors.New("too many known networks")
+	errKnownNotFound     = errors.New("network not found in scan")
+	errKnownJoinFailed   = errors.New("could not join any known network")
+	errKnownNotAttempted = errors.New("not attempted")
+)
+
+// maxKnownNetworks is the maximum amount of networks accepted by [Device.JoinKnown].
+const maxKnownNetworks = 16
+
+// KnownNetwork is a network the device may join with [Device.JoinKnown].
+type KnownNetwork struct {
+	// SSID is the network name.
+	SSID string
+	// Priority orders networks by preference, higher priority networks are tried first.
+	// Networks of equal priority are tried in order of signal strength.
+	Priority int
+	// Options holds the credentials of the network. If Auth is not set the
+	// authentication method is chosen from the security advertised by the access point.
+	// Set Options.Hidden for networks that do not broadcast their SSID so they are
+	// attempted even if not seen during the scan.
+	Options JoinOptions
+}
+
+// KnownNetworkResult is the outcome of joining a network passed to [Device.JoinKnown].
+type KnownNetworkResult struct {
+	// Index is the index of the network in the list passed to JoinKnown.
+	Index int
+	// BSSID is the access point found with the strongest signal. Zero if the network was not found.
+	BSSID [6]byte
+	// Channel is the channel the access point operates on. Zero if the network was not found.
+	Channel uint8
+	// RSSI is the signal strength of the access point in dBm. Zero if the network was not found.
+	RSSI int16
+	// Err is nil if the network was joined, otherwise it describes why the network was skipped or the join failed.
+	Err error
+}
+
+type knownCandidate struct {
+	found    bool
+	bssid    [6]byte
+	channel  uint8
+	rssi     int16
+	security ScanSecurity
+}
+
+// JoinKnown scans for the networks in known and joins the best candidate. Candidates are
+// ordered by priority and then by signal strength. If joining a candidate fails the next one
+// is tried. The outcome of every network is appended to results in the order
+// networks were tried, networks not found during the scan are appended last.
+//
+// On success the index of the joined network in known is returned and the reconnect
+// supervisor, if enabled, rejoins that network on link loss.
+func (d *Device) JoinKnown(known []KnownNetwork, results []KnownNetworkResult) (joined int, _ []KnownNetworkResult, err error) {
+	err = d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return -1, results, err
+	}
+	if len(known) == 0 {
+		return -1, results, errNoKnownNetworks
+	} else if len(known) > maxKnownNetworks {
+		return -1, results, errTooManyKnown
+	}
+	var cands [maxKnownNetworks]knownCandidate
+	err = d.scan(ScanOptions{}, func(sr ScanResult) {
+		ssid := sr.SSID()
+		for i := range known {
+			c := &cands[i]
+			pinned := known[i].Options.BSSID
+			if known[i].SSID != ssid || (pinned != [6]byte{} && pinned != sr.BSSID) || (c.found && c.rssi >= sr.RSSI) {
+				continue
+			}
+			*c = knownCandidate{found: true, bssid: sr.BSSID, channel: sr.Channel, rssi: sr.RSSI, security: sr.Security}
+		}
+	})
+	if err != nil {
+		return -1, results, err
+	}
+
+	// Sort candidate indices by priority and signal strength. Insertion sort, list is short.
+	var order [maxKnownNetworks]uint8
+	n := 0
+	for i := range known {
+		if !cands[i].found && !known[i].Options.Hidden {
+			continue
+		}
+		j := n
+		for ; j > 0 && knownBetter(known, cands[:], i, int(order[j-1])); j-- {
+			order[j] = order[j-1]
+		}
+		order[j] = uint8(i)
+		n++
+	}
+
+	joined = -1
+	for _, idx := range order[:n] {
+		res := KnownNetworkResult{Index: int(idx), Err: errKnownNotAttempted}
+		c := &cands[idx]
+		if c.found {
+			res.BSSID = c.bssid
+			res.Channel = c.channel
+			res.RSSI = c.rssi
+		}
+		if joined < 0 {
+			res.Err = d.joinKnown(&known[idx], c)
+			if res.Err == nil {
+				joined = int(idx)
+			}
+		}
+		results = append(results, res)
+	}
+	for i := range known {
+		if !cands[i].found && !known[i].Options.Hidden {
+			results = append(results, KnownNetworkResult{Index: i, Err: errKnownNotFound})
+		}
+	}
+	if joined < 0 {
+		return -1, results, errKnownJoinFailed
+	}
+	return joined, results, nil
+}
+
+// joinKnown joins a known network pinned to the candidate access point found during the scan.
+func (d *Device) joinKnown(kn *KnownNetwork, c *knownCandidate) error {
+	opts := kn.Options
+	var channel [1]uint8
+	if c.found {
+		opts.BSSID = c.bssid
+		channel[0] = c.channel
+		opts.Channels = channel[:]
+		if opts.Auth == joinAuthUndefined && opts.Passphrase != "" {
+			opts.Auth = c.security.JoinAuth()
+		}
+	}
+	d.info("JoinKnown:try", slog.String("ssid", kn.SSID), slog.String("bssid", net.HardwareAddr(c.bssid[:]).String()), slog.Int("rssi", int(c.rssi)))
+	err := d.join(kn.SSID, opts)
+	if err != nil {
+		d.logerr("JoinKnown:fail", slog.String("ssid", kn.SSID), slog.String("err", err.Error()))
+		return err
+	}
+	// Reconnect to any access point of the network, not only the one pinned,
+	// with the authentication advertised by the access point.
+	remembered := kn.Options
+	remembered.Auth = opts.Auth
+	d.reconn.remember(kn.SSID, remembered)
+	return nil
+}
+
+// knownBetter returns true if network a should be tried before network b.
+func knownBetter(known []KnownNetwork, cands []knownCandidate, a, b int) bool {
+	if known[a].Priority != known[b].Priority {
+		return known[a].Priority > known[b].Priority
+	}
+	if cands[a].found != cands[b].found {
+		return cands[a].found // Prefer networks seen during the scan.
+	}
+	return cands[a].rssi > cands[b].rssi
+}
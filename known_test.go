@@ -0,0 +1,76 @@
+package cyw43439
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439/cywemu"
+)
+
+func TestJoinKnown(t *testing.T) {
+	home := cywemu.Network{SSID: "home", BSSID: [6]byte{0x02, 5, 5, 5, 5, 1}, Channel: 1, RSSI: -40, Passphrase: "home-password"}
+	officeFar := cywemu.Network{SSID: "office", BSSID: [6]byte{0x02, 5, 5, 5, 5, 2}, Channel: 6, RSSI: -80, Passphrase: "office-password"}
+	officeNear := cywemu.Network{SSID: "office", BSSID: [6]byte{0x02, 5, 5, 5, 5, 3}, Channel: 11, RSSI: -60, Passphrase: "office-password", WPA3: true}
+	cafe := cywemu.Network{SSID: "cafe", BSSID: [6]byte{0x02, 5, 5, 5, 5, 4}, Channel: 6, RSSI: -50, Passphrase: "cafe-password"}
+	dev, chip := newTestDevice(t, home, officeFar, officeNear, cafe)
+	if _, _, err := dev.JoinKnown(nil, nil); !errors.Is(err, errNoKnownNetworks) {
+		t.Errorf("want no known networks error, got %v", err)
+	}
+
+	known := []KnownNetwork{
+		{SSID: "home", Options: JoinOptions{Passphrase: home.Passphrase}},
+		{SSID: "office", Priority: 1, Options: JoinOptions{Passphrase: officeNear.Passphrase}},
+		{SSID: "cafe", Priority: 1, Options: JoinOptions{Passphrase: "stale-password"}},
+		{SSID: "away", Priority: 2, Options: JoinOptions{Passphrase: "away-password"}},
+	}
+	// Cafe has the same priority as the office but a stronger signal, its
+	// join fails and the office network is joined on its strongest access point.
+	joined, results, err := dev.JoinKnown(known, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined != 1 {
+		t.Fatalf("joined network %d, want office", joined)
+	}
+	if n, ok := chip.Associated(); !ok || n.BSSID != officeNear.BSSID {
+		t.Errorf("associated with %x, want strongest office access point %x", n.BSSID, officeNear.BSSID)
+	}
+	wantOrder := []int{2, 1, 0, 3}
+	if len(results) != len(wantOrder) {
+		t.Fatalf("got %d results, want %d", len(results), len(wantOrder))
+	}
+	for i, res := range results {
+		if res.Index != wantOrder[i] {
+			t.Errorf("result %d for network %d, want %d", i, res.Index, wantOrder[i])
+		}
+	}
+	var jerr *JoinError
+	if !errors.As(results[0].Err, &jerr) || jerr.Reason != JoinFailWrongPassphrase {
+		t.Errorf("want wrong passphrase for cafe, got %v", results[0].Err)
+	}
+	if res := results[1]; res.Err != nil || res.BSSID != officeNear.BSSID || res.Channel != officeNear.Channel || res.RSSI != officeNear.RSSI {
+		t.Errorf("got office result %+v", res)
+	}
+	if !errors.Is(results[2].Err, errKnownNotAttempted) || results[2].BSSID != home.BSSID {
+		t.Errorf("got home result %+v", results[2])
+	}
+	if !errors.Is(results[3].Err, errKnownNotFound) {
+		t.Errorf("want away network not found, got %v", results[3].Err)
+	}
+
+	// Reconnect supervisor rejoins any access point of the network with the advertised authentication.
+	rc := &dev.reconn
+	if rc.ssid != "office" || rc.opts.Auth != JoinAuthWPA3 || rc.opts.BSSID != [6]byte{} || len(rc.opts.Channels) != 0 {
+		t.Errorf("remembered %q with options %+v", rc.ssid, rc.opts)
+	}
+
+	known[0].Options.Passphrase = "wrong-password"
+	known[1].Options.Passphrase = "wrong-password"
+	joined, results, err = dev.JoinKnown(known, results[:0])
+	if !errors.Is(err, errKnownJoinFailed) || joined != -1 {
+		t.Fatalf("want join failed, got network %d: %v", joined, err)
+	}
+	if len(results) != 4 || results[2].Index != 0 || !errors.As(results[2].Err, &jerr) {
+		t.Errorf("want all found networks attempted, got %+v", results)
+	}
+}
@@ -0,0 +1,174 @@
+package cyw43439
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+var (
+	errMonitorActive   = errors.New("monitor mode active")
+	errMonitorInactive = errors.New("monitor mode not active")
+)
+
+const (
+	// defaultMonitorDwell is the time spent on each channel when hopping channels.
+	defaultMonitorDwell = 200 * time.Millisecond
+	// maxMonitorChannels is the maximum amount of channels to hop over.
+	maxMonitorChannels = 14
+)
+
+// MonitorOptions configures monitor mode started with [Device.StartMonitor].
+type MonitorOptions struct {
+	// Channels lists the 2.4GHz channels to receive on. If more than one channel is
+	// given the device hops between them every Dwell during [Device.PollOne].
+	// If empty channel 1 is used.
+	Channels []uint8
+	// Dwell is the time spent on each channel when hopping. Default is 200ms.
+	Dwell time.Duration
+}
+
+// MonitorFrame is a raw 802.11 frame received in monitor mode.
+// The firmware delivers monitor frames without receive metadata so the signal
+// strength and rate of a frame are not known.
+type MonitorFrame struct {
+	// Channel is the channel the device was tuned to when the frame was received.
+	Channel uint8
+	// Data is the 802.11 frame starting at the frame control field. It is
+	// only valid during the handler call and must be copied to be retained.
+	Data []byte
+}
+
+type monitorState struct {
+	active   bool
+	handler  func(*MonitorFrame)
+	dwell    time.Duration
+	nch      uint8
+	chidx    uint8
+	channels [maxMonitorChannels]uint8
+	lastHop  time.Time
+}
+
+// StartMonitor puts the device in monitor (promiscuous 802.11) mode and calls handler
+// for every management, control and data frame received. The device must not be
+// associated with a network nor running an access point.
+// The handler is called with the device lock held so it must not call methods on the Device.
+// Use [Device.StopMonitor] to return to station mode.
+func (d *Device) StartMonitor(opts MonitorOptions, handler func(*MonitorFrame)) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	if d.monitor.active {
+		return errMonitorActive
+	} else if d.state == linkStateUp || d.apUp {
+		return errors.New("monitor requires device to be disconnected")
+	} else if handler == nil {
+		return errors.New("nil monitor handler")
+	} else if len(opts.Channels) > maxMonitorChannels {
+		return errors.New("too many monitor channels")
+	}
+	st := monitorState{
+		handler: handler,
+		dwell:   opts.Dwell,
+		nch:     uint8(len(opts.Channels)),
+	}
+	if st.dwell <= 0 {
+		st.dwell = defaultMonitorDwell
+	}
+	for i, ch := range opts.Channels {
+		if ch == 0 || ch > maxChannel2G {
+			return errScanChannel
+		}
+		st.channels[i] = ch
+	}
+	if st.nch == 0 {
+		st.channels[0] = 1
+		st.nch = 1
+	}
+	d.info("StartMonitor", slog.Int("nch", int(st.nch)), slog.Duration("dwell", st.dwell))
+	err = d.set_ioctl(whd.WLC_SET_CHANNEL, whd.IF_STA, uint32(st.channels[0]))
+	if err != nil {
+		return err
+	}
+	err = d.set_ioctl(whd.WLC_SET_MONITOR, whd.IF_STA, 1)
+	if err != nil {
+		return err
+	}
+	st.active = true
+	st.lastHop = time.Now()
+	d.monitor = st
+	return nil
+}
+
+// StopMonitor disables monitor mode.
+func (d *Device) StopMonitor() error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	if !d.monitor.active {
+		return errMonitorInactive
+	}
+	d.info("StopMonitor")
+	d.monitor = monitorState{}
+	return d.set_ioctl(whd.WLC_SET_MONITOR, whd.IF_STA, 0)
+}
+
+// monitorHop tunes to the next monitor channel if the dwell time elapsed.
+func (d *Device) monitorHop(now time.Time) error {
+	m := &d.monitor
+	if !m.active || m.nch < 2 || now.Sub(m.lastHop) < m.dwell {
+		return nil
+	}
+	m.lastHop = now
+	m.chidx = (m.chidx + 1) % m.nch
+	d.trace("monitor:hop", slog.Int("ch", int(m.channels[m.chidx])))
+	return d.set_ioctl(whd.WLC_SET_CHANNEL, whd.IF_STA, uint32(m.channels[m.chidx]))
+}
+
+// rxMonitor delivers a frame received on the data channel in monitor mode.
+// The frame follows the BDC header directly, there is no receive header to
+// read the signal strength or rate from.
+func (d *Device) rxMonitor(frame []byte) {
+	m := &d.monitor
+	f := MonitorFrame{
+		Channel: m.channels[m.chidx],
+		Data:    frame,
+	}
+	m.handler(&f)
+}
+
+// Radiotap present flags. See https://www.radiotap.org/fields/defined
+const (
+	radiotapChannel    = 1 << 3
+	radiotapChan2GHz   = 0x0080
+	radiotapHeaderSize = 8 + 4 // Header and channel field.
+)
+
+// AppendRadiotap appends the frame to dst prefixed with a radiotap header carrying
+// the channel. The result can be written to pcap files with link type 127
+// (LINKTYPE_IEEE802_11_RADIOTAP).
+func AppendRadiotap(dst []byte, f *MonitorFrame) []byte {
+	var hdr [radiotapHeaderSize]byte
+	// Radiotap fields are little endian regardless of host and bus order.
+	binary.LittleEndian.PutUint16(hdr[2:4], radiotapHeaderSize) // Version and padding are zero.
+	binary.LittleEndian.PutUint32(hdr[4:8], radiotapChannel)
+	binary.LittleEndian.PutUint16(hdr[8:], channelFrequency(f.Channel))
+	binary.LittleEndian.PutUint16(hdr[10:], radiotapChan2GHz)
+	dst = append(dst, hdr[:]...)
+	return append(dst, f.Data...)
+}
+
+// channelFrequency returns the center frequency in MHz of a 2.4GHz channel.
+func channelFrequency(ch uint8) uint16 {
+	if ch == 14 {
+		return 2484
+	}
+	return 2407 + 5*uint16(ch)
+}
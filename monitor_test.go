@@ -0,0 +1,117 @@
+package cyw43439
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+func TestAppendRadiotap(t *testing.T) {
+	beacon := []byte{0x80, 0x00}
+	for _, test := range []struct {
+		frame MonitorFrame
+		want  []byte
+	}{
+		{
+			frame: MonitorFrame{Channel: 14, Data: beacon},
+			want: []byte{
+				0x00, 0x00, 0x0c, 0x00, // Version, padding, length 12.
+				0x08, 0x00, 0x00, 0x00, // Present: channel.
+				0xb4, 0x09, 0x80, 0x00, // 2484MHz, 2GHz.
+				0x80, 0x00,
+			},
+		},
+		{
+			frame: MonitorFrame{Channel: 6, Data: beacon},
+			want: []byte{
+				0x00, 0x00, 0x0c, 0x00, // Version, padding, length 12.
+				0x08, 0x00, 0x00, 0x00, // Present: channel.
+				0x85, 0x09, 0x80, 0x00, // 2437MHz, 2GHz.
+				0x80, 0x00,
+			},
+		},
+	} {
+		prefix := []byte{0xff}
+		got := AppendRadiotap(prefix, &test.frame)
+		if !bytes.Equal(got[:1], prefix) || !bytes.Equal(got[1:], test.want) {
+			t.Errorf("AppendRadiotap(%+v)=%x, want %x", test.frame, got[1:], test.want)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet)
+	var frames []MonitorFrame
+	handler := func(f *MonitorFrame) {
+		f.Data = append([]byte(nil), f.Data...)
+		frames = append(frames, *f)
+	}
+	for _, opts := range []MonitorOptions{
+		{Channels: []uint8{0}},
+		{Channels: []uint8{15}},
+		{Channels: make([]uint8, maxMonitorChannels+1)},
+	} {
+		if err := dev.StartMonitor(opts, handler); err == nil {
+			t.Errorf("invalid options accepted: %+v", opts)
+		}
+	}
+	if err := dev.StartMonitor(MonitorOptions{}, nil); err == nil {
+		t.Error("nil handler accepted")
+	}
+	if err := dev.StopMonitor(); !errors.Is(err, errMonitorInactive) {
+		t.Errorf("want monitor inactive error, got %v", err)
+	}
+	if err := dev.Join(testOpenNet.SSID, JoinOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.StartMonitor(MonitorOptions{}, handler); err == nil {
+		t.Error("monitor started while associated")
+	}
+	if err := dev.Leave(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := dev.StartMonitor(MonitorOptions{Channels: []uint8{1, 6}, Dwell: time.Hour}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_MONITOR, ""); got != 1 {
+		t.Error("monitor not enabled")
+	}
+	if err := dev.StartMonitor(MonitorOptions{}, handler); !errors.Is(err, errMonitorActive) {
+		t.Errorf("want monitor active error, got %v", err)
+	}
+
+	frame := []byte{0x40, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff} // Probe request.
+	for _, wantCh := range []uint8{1, 6, 1} {
+		if got := lastIoctlValue(t, chip, whd.WLC_SET_CHANNEL, ""); got != uint32(wantCh) {
+			t.Errorf("tuned to channel %d, want %d", got, wantCh)
+		}
+		frames = frames[:0]
+		chip.InjectEthernet(whd.IF_STA, frame)
+		pollAll(t, dev, chip)
+		if len(frames) != 1 || frames[0].Channel != wantCh || !bytes.Equal(frames[0].Data, frame) {
+			t.Fatalf("want frame on channel %d, got %+v", wantCh, frames)
+		}
+		// Dwell time elapsed.
+		dev.monitor.lastHop = time.Now().Add(-2 * time.Hour)
+		pollAll(t, dev, chip)
+	}
+
+	err = dev.StopMonitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_MONITOR, ""); got != 0 {
+		t.Error("monitor not disabled")
+	}
+	frames = frames[:0]
+	chip.InjectEthernet(whd.IF_STA, frame)
+	pollAll(t, dev, chip)
+	if len(frames) != 0 {
+		t.Error("frame delivered to monitor handler after stop")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log/slog"
 	"net"
+	"time"
 
 	"github.com/soypat/cyw43439/whd"
 )
@@ -38,7 +39,19 @@ func (d *Device) PollOne() (bool, error) {
 	}
 	_, cmd, err := d.tryPoll(d._rxBuf[:])
 	if err == errNoF2Avail {
-		return false, nil
+		err = nil
+	}
+	if err == nil && d.reconn.due(time.Now()) {
+		d.reconnect()
+	}
+	if err == nil && d.netlink.watchdogDue(time.Now()) {
+		err = d.netWatchdog()
+	}
+	if err == nil && d.apFollowPending {
+		err = d.followSTAChannel()
+	}
+	if err == nil && d.monitor.active {
+		err = d.monitorHop(time.Now())
 	}
 	return cmd == whd.CONTROL_HEADER && err == nil, err
 }
@@ -61,7 +74,39 @@ func (d *Device) SendEth(pkt []byte) error {
 	if err != nil {
 		return err
 	}
-	return d.tx(pkt)
+	return d.tx(whd.IF_STA, pkt)
+}
+
+// SendEthIface sends an Ethernet packet over the given interface. Use [whd.IF_AP]
+// to send over the access point started in concurrent mode, see [APOptions].
+// [whd.IF_STA] is the station interface or the access point when not in concurrent mode.
+func (d *Device) SendEthIface(iface whd.IoctlInterface, pkt []byte) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	return d.tx(iface, pkt)
+}
+
+// RecvEthHandleIface sets handler for receiving Ethernet packets on the given interface.
+// Setting the [whd.IF_STA] handler is equivalent to calling [Device.RecvEthHandle].
+// If set to nil then incoming packets on the interface are ignored.
+func (d *Device) RecvEthHandleIface(iface whd.IoctlInterface, handler func(pkt []byte) error) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	switch iface {
+	case whd.IF_STA:
+		d.rcvEth = handler
+	case whd.IF_AP:
+		d.rcvEthAP = handler
+	default:
+		return errInvalidIoctlIface
+	}
+	return nil
 }
 
 // NetFlags returns the current network flags for the device.
@@ -0,0 +1,233 @@
+package cyw43439
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/soypat/cyw43439/internal/netlink"
+	"github.com/soypat/cyw43439/whd"
+)
+
+// Device implements TinyGo's data link layer interface.
+var _ netlink.Netlinker = (*Device)(nil)
+
+type netlinkState struct {
+	params       netlink.ConnectParams
+	connected    bool
+	notify       func(netlink.Event)
+	lastWatchdog time.Time
+}
+
+// watchdogDue returns true if the connection watchdog should run.
+func (nl *netlinkState) watchdogDue(now time.Time) bool {
+	return nl.connected && nl.params.WatchdogTimeout > 0 && now.Sub(nl.lastWatchdog) >= nl.params.WatchdogTimeout
+}
+
+// NetConnect connects the device to a network as a station or starts an access point
+// as described by params. Implements [netlink.Netlinker].
+//
+// In station mode each connection attempt is bounded by params.ConnectTimeout and
+// up to params.Retries attempts are made, waiting with exponential backoff between attempts.
+// Retrying stops early if the access point rejects the credentials. Zero retries retries
+// until connected, use [Device.NetConnectContext] to give up. If params.WatchdogTimeout is set the
+// connection is checked periodically during [Device.PollOne] and recovered on link loss
+// or hardware fault by reinitializing the device.
+func (d *Device) NetConnect(params *netlink.ConnectParams) error {
+	return d.NetConnectContext(context.Background(), params)
+}
+
+// NetConnectContext is like [Device.NetConnect] but stops retrying and returns the
+// context's error if ctx is canceled or its deadline is exceeded before connecting.
+func (d *Device) NetConnectContext(ctx context.Context, params *netlink.ConnectParams) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	d.ctx = ctx
+	defer d.clearContext()
+	if d.netlink.connected {
+		return netlink.ErrConnected
+	} else if params.SSID == "" {
+		return netlink.ErrMissingSSID
+	}
+	p := *params
+	if p.ConnectTimeout <= 0 {
+		p.ConnectTimeout = netlink.DefaultConnectTimeout
+	}
+	d.info("NetConnect", slog.String("ssid", p.SSID), slog.Int("mode", int(p.ConnectMode)), slog.Int("auth", int(p.AuthType)))
+	err = d.netConnect(&p, p.Retries)
+	if err != nil {
+		return err
+	}
+	d.netlink.params = p
+	d.netlink.connected = true
+	d.netlink.lastWatchdog = time.Now()
+	return nil
+}
+
+// NetDisconnect disconnects the device from the network or stops the access point
+// started by [Device.NetConnect]. Implements [netlink.Netlinker].
+func (d *Device) NetDisconnect() {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		d.logerr("cyw:netdisconnect", slog.String("err", err.Error()))
+		return
+	}
+	if !d.netlink.connected {
+		return
+	}
+	d.netlink.connected = false
+	if d.netlink.params.ConnectMode == netlink.ConnectModeAP {
+		err = d.stopAP()
+	} else {
+		err = d.leave()
+	}
+	if err != nil {
+		d.logerr("cyw:netdisconnect", slog.String("err", err.Error()))
+	}
+}
+
+// NetNotify sets the callback called when the network connection goes up or down.
+// The callback is called with the device lock held so it must not call methods on the Device.
+// Implements [netlink.Netlinker].
+func (d *Device) NetNotify(cb func(netlink.Event)) {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		d.logerr("cyw:netnotify", slog.String("err", err.Error()))
+		return
+	}
+	d.netlink.notify = cb
+}
+
+// GetHardwareAddr returns the device's MAC address. Implements [netlink.Netlinker].
+func (d *Device) GetHardwareAddr() (net.HardwareAddr, error) {
+	mac, err := d.HardwareAddr6()
+	if err != nil {
+		return nil, err
+	}
+	return net.HardwareAddr(mac[:]), nil
+}
+
+// netConnect connects with the given parameters. retries of zero means infinite retries.
+func (d *Device) netConnect(p *netlink.ConnectParams, retries int) (err error) {
+	if p.Country != "" {
+		err = d.setCountry(p.Country, 0)
+		if err != nil {
+			return err
+		}
+	}
+	switch p.ConnectMode {
+	case netlink.ConnectModeSTA:
+		var opts JoinOptions
+		opts, err = joinOptionsFromNetlink(p)
+		if err != nil {
+			return err
+		}
+		opts.Timeout = p.ConnectTimeout
+		var jerr *JoinError
+		backoff := defaultReconnectMinBackoff
+		for attempt := 1; retries <= 0 || attempt <= retries; attempt++ {
+			err = d.join(p.SSID, opts)
+			if err == nil {
+				d.reconn.remember(p.SSID, opts)
+				return nil
+			}
+			d.warn("netconnect:fail", slog.Int("attempt", attempt), slog.String("err", err.Error()))
+			if errors.As(err, &jerr) && (jerr.Reason == JoinFailWrongPassphrase || jerr.Reason == JoinFailAuthRejected) {
+				break // Retrying with the same credentials will not succeed.
+			} else if ctxErr := d.ctxErr(); ctxErr != nil {
+				return ctxErr
+			}
+			if retries <= 0 || attempt < retries {
+				if err := d.sleep(backoff); err != nil {
+					return err
+				}
+				backoff = min(2*backoff, defaultReconnectMaxBackoff)
+			}
+		}
+		if errors.As(err, &jerr) {
+			switch jerr.Reason {
+			case JoinFailTimeout:
+				return errjoin(netlink.ErrConnectTimeout, err)
+			case JoinFailAuthRejected, JoinFailWrongPassphrase:
+				return errjoin(netlink.ErrAuthFailure, err)
+			}
+		}
+		return errjoin(netlink.ErrConnectFailed, err)
+
+	case netlink.ConnectModeAP:
+		switch {
+		case p.AuthType == netlink.AuthTypeOpen && p.Passphrase != "":
+			return errors.New("passphrase set for open network")
+		case p.AuthType == netlink.AuthTypeWPA2 && p.Passphrase == "":
+			return netlink.ErrAuthFailure
+		case p.AuthType != netlink.AuthTypeOpen && p.AuthType != netlink.AuthTypeWPA2:
+			return netlink.ErrAuthTypeNoGood
+		}
+		return d.startAP(p.SSID, APOptions{Passphrase: p.Passphrase})
+	}
+	return netlink.ErrConnectModeNoGood
+}
+
+func joinOptionsFromNetlink(p *netlink.ConnectParams) (opts JoinOptions, err error) {
+	opts.Passphrase = p.Passphrase
+	switch p.AuthType {
+	case netlink.AuthTypeOpen:
+		opts.Auth = JoinAuthOpen
+		opts.Passphrase = ""
+	case netlink.AuthTypeWPA:
+		opts.Auth = JoinAuthWPA
+		opts.CipherTKIP = true
+	case netlink.AuthTypeWPA2:
+		opts.Auth = JoinAuthWPA2
+	case netlink.AuthTypeWPA2Mixed:
+		opts.Auth = JoinAuthWPA2
+		opts.CipherTKIP = true
+	default:
+		return opts, netlink.ErrAuthTypeNoGood
+	}
+	if opts.Auth != JoinAuthOpen && opts.Passphrase == "" {
+		return opts, netlink.ErrAuthFailure
+	}
+	return opts, nil
+}
+
+// netWatchdog checks the device for a hardware fault or downed connection and attempts recovery.
+func (d *Device) netWatchdog() error {
+	d.netlink.lastWatchdog = time.Now()
+	p := &d.netlink.params
+	got, err := d.read32(FuncBus, whd.SPI_READ_TEST_REGISTER)
+	hwfault := err != nil || got != whd.TEST_PATTERN
+	switch {
+	case hwfault:
+		d.warn("watchdog:hwfault", slog.String("test", hex32(got)))
+		prevState := d.state
+		err = d.init(d.cfg)
+		d.notifyLinkState(prevState, &whd.EventMessage{}, nil)
+		if err != nil {
+			return err
+		}
+		if d.usermask != (eventMask{}) || d.probeHandler != nil {
+			// Restore firmware event mask for subscribed events.
+			fwmask := d.firmwareEventMask(&d.usermask)
+			if err := d.setFirmwareEventMask(&fwmask); err != nil {
+				d.logerr("watchdog:eventmask", slog.String("err", err.Error()))
+			}
+		}
+	case p.ConnectMode == netlink.ConnectModeSTA && d.state != linkStateUp && !d.reconn.reconnecting:
+		d.warn("watchdog:linkdown")
+	default:
+		return nil // Connection is healthy or being recovered by reconnect supervisor.
+	}
+	err = d.netConnect(p, 1)
+	if err != nil {
+		d.logerr("watchdog:reconnect", slog.String("err", err.Error()))
+	}
+	return nil
+}
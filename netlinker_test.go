@@ -0,0 +1,111 @@
+package cyw43439
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soypat/cyw43439/internal/netlink"
+	"github.com/soypat/cyw43439/whd"
+)
+
+func TestNetConnect(t *testing.T) {
+	dev, chip := newTestDevice(t, testWPA2Net)
+	var events []netlink.Event
+	dev.NetNotify(func(ev netlink.Event) { events = append(events, ev) })
+	params := netlink.ConnectParams{SSID: testWPA2Net.SSID, Passphrase: testWPA2Net.Passphrase, AuthType: netlink.AuthTypeWPA2}
+	err := dev.NetConnect(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dev.IsLinkUp() {
+		t.Fatal("link not up after connect")
+	}
+	if err = dev.NetConnect(&params); !errors.Is(err, netlink.ErrConnected) {
+		t.Errorf("want already connected error, got %v", err)
+	}
+	dev.NetDisconnect()
+	if _, ok := chip.Associated(); ok || dev.IsLinkUp() {
+		t.Error("associated after disconnect")
+	}
+	if len(events) != 2 || events[0] != netlink.EventNetUp || events[1] != netlink.EventNetDown {
+		t.Errorf("got events %v, want up and down", events)
+	}
+}
+
+func TestNetConnectWrongPassphrase(t *testing.T) {
+	dev, chip := newTestDevice(t, testWPA2Net)
+	// Zero retries retries forever unless the credentials are rejected.
+	params := netlink.ConnectParams{SSID: testWPA2Net.SSID, Passphrase: "wrong-password", AuthType: netlink.AuthTypeWPA2}
+	err := dev.NetConnect(&params)
+	if !errors.Is(err, netlink.ErrAuthFailure) {
+		t.Fatalf("want auth failure, got %v", err)
+	}
+	if n := countIoctls(chip, whd.WLC_SET_SSID, ""); n != 1 {
+		t.Errorf("want a single join attempt, got %d", n)
+	}
+}
+
+func TestNetConnectRetries(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet)
+	params := netlink.ConnectParams{SSID: "missing-net", AuthType: netlink.AuthTypeOpen, Retries: 2}
+	start := time.Now()
+	err := dev.NetConnect(&params)
+	if !errors.Is(err, netlink.ErrConnectFailed) {
+		t.Fatalf("want connect failed, got %v", err)
+	}
+	if n := countIoctls(chip, whd.WLC_SET_SSID, ""); n != 2 {
+		t.Errorf("want 2 join attempts, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < defaultReconnectMinBackoff {
+		t.Errorf("retried without backoff in %s", elapsed)
+	}
+
+	// Unlimited retries stop when the context is done.
+	params.Retries = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = dev.NetConnectContext(ctx, &params)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
+
+func TestNetConnectAP(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	params := netlink.ConnectParams{ConnectMode: netlink.ConnectModeAP, SSID: "netlink-ap", Passphrase: "password123", AuthType: netlink.AuthTypeWPA2}
+	err := dev.NetConnect(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssid, up := chip.AP(); !up || ssid != params.SSID {
+		t.Fatalf("access point not up: ssid=%q up=%v", ssid, up)
+	}
+	dev.NetDisconnect()
+	if _, up := chip.AP(); up {
+		t.Error("access point up after disconnect")
+	}
+}
+
+func TestNetWatchdog(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet)
+	params := netlink.ConnectParams{SSID: testOpenNet.SSID, AuthType: netlink.AuthTypeOpen, WatchdogTimeout: time.Millisecond}
+	err := dev.NetConnect(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip.Disconnect(3)
+	pollAll(t, dev, chip)
+	if dev.IsLinkUp() {
+		t.Fatal("link up after deauthentication")
+	}
+	time.Sleep(2 * time.Millisecond)
+	pollAll(t, dev, chip)
+	if !dev.IsLinkUp() {
+		t.Fatal("watchdog did not reconnect")
+	}
+	if _, ok := chip.Associated(); !ok {
+		t.Error("chip not associated after watchdog reconnect")
+	}
+}
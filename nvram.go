@@ -0,0 +1,173 @@
+package cyw43439
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errNVRAMEntry = errors.New("nvram: invalid entry")
+	errNVRAMKey   = errors.New("nvram: invalid key")
+	errNVRAMValue = errors.New("nvram: invalid value")
+)
+
+// NVRAM is a board configuration image uploaded to the CYW43439 during [Device.Init].
+// It holds key=value entries that configure the radio for a specific board, such as
+// the crystal frequency (xtalfreq), board flags, antenna settings (swdiv*) and
+// TX power tables (maxp2ga0, pa2ga0, *bw202gpo).
+//
+// Use [DefaultNVRAM] to start off of the Raspberry Pi Pico W configuration or
+// [ParseNVRAM] for custom boards. Set [Config.NVRAM] to [NVRAM.Image] to upload it.
+// Copies of an NVRAM are independent, modifying one does not affect the others.
+type NVRAM struct {
+	buf []byte // Null terminated key=value entries.
+}
+
+// DefaultNVRAM returns the NVRAM of the Raspberry Pi Pico W, the default used by [Device.Init].
+// It panics if the embedded image is invalid, which the package tests rule out.
+func DefaultNVRAM() NVRAM {
+	n, err := ParseNVRAM(nvram43439)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// ParseNVRAM parses an NVRAM image. Entries may be separated by null characters as in
+// the image uploaded to the device or by newlines as in vendor NVRAM text files.
+// Lines starting with '#' are treated as comments. Repeated keys overwrite previous values.
+func ParseNVRAM(image string) (n NVRAM, err error) {
+	n.buf = make([]byte, 0, len(image))
+	for len(image) > 0 {
+		var entry string
+		i := strings.IndexAny(image, "\x00\n")
+		if i < 0 {
+			entry, image = image, ""
+		} else {
+			entry, image = image[:i], image[i+1:]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(entry, '=')
+		if eq <= 0 {
+			return NVRAM{}, errNVRAMEntry
+		}
+		err = n.Set(entry[:eq], entry[eq+1:])
+		if err != nil {
+			return NVRAM{}, err
+		}
+	}
+	return n, nil
+}
+
+// Get returns the value of key and true if the key is present.
+func (n *NVRAM) Get(key string) (string, bool) {
+	start, end := n.find(key)
+	if start < 0 {
+		return "", false
+	}
+	return string(n.buf[start+len(key)+1 : end-1]), true
+}
+
+// Set sets the value of key. A previous value is removed and the entry moves to the end of the image.
+func (n *NVRAM) Set(key, value string) error {
+	if key == "" || strings.ContainsAny(key, "=\x00\n") {
+		return errNVRAMKey
+	} else if strings.ContainsAny(value, "\x00\n") {
+		return errNVRAMValue
+	}
+	buf := n.without(key, len(key)+len(value)+2)
+	buf = append(buf, key...)
+	buf = append(buf, '=')
+	buf = append(buf, value...)
+	n.buf = append(buf, 0)
+	return nil
+}
+
+// Delete removes key and returns true if it was present.
+func (n *NVRAM) Delete(key string) bool {
+	if start, _ := n.find(key); start < 0 {
+		return false
+	}
+	n.buf = n.without(key, 0)
+	return true
+}
+
+// without returns a copy of the image with the entry of key removed and room for
+// extra bytes. The image is never modified in place since copies of n share it.
+func (n *NVRAM) without(key string, extra int) []byte {
+	start, end := n.find(key)
+	if start < 0 {
+		start, end = len(n.buf), len(n.buf)
+	}
+	buf := make([]byte, 0, len(n.buf)-(end-start)+extra)
+	buf = append(buf, n.buf[:start]...)
+	return append(buf, n.buf[end:]...)
+}
+
+// SetMAC sets the MAC address of the device.
+func (n *NVRAM) SetMAC(mac [6]byte) {
+	const hexdigits = "0123456789abcdef"
+	var buf [17]byte
+	for i, b := range mac {
+		if i > 0 {
+			buf[3*i-1] = ':'
+		}
+		buf[3*i] = hexdigits[b>>4]
+		buf[3*i+1] = hexdigits[b&0xf]
+	}
+	n.Set("macaddr", string(buf[:]))
+}
+
+// ForEach calls fn for every entry in the order they appear in the image.
+func (n *NVRAM) ForEach(fn func(key, value string)) {
+	buf := n.buf
+	for len(buf) > 0 {
+		end := 0
+		for buf[end] != 0 {
+			end++
+		}
+		entry := buf[:end]
+		eq := 0
+		for entry[eq] != '=' {
+			eq++
+		}
+		fn(string(entry[:eq]), string(entry[eq+1:]))
+		buf = buf[end+1:]
+	}
+}
+
+// Image returns the NVRAM image to be uploaded to the device. The image is
+// terminated by an additional null character and padded with zeros to a multiple of 4 bytes.
+func (n *NVRAM) Image() string {
+	size := alignup(uint32(len(n.buf)+1), 4)
+	img := make([]byte, size)
+	copy(img, n.buf)
+	return string(img)
+}
+
+// find returns the start and end index of the entry for key in buf. Returns -1 if not found.
+func (n *NVRAM) find(key string) (start, end int) {
+	for start < len(n.buf) {
+		end = start
+		for n.buf[end] != 0 {
+			end++
+		}
+		end++ // Include null terminator.
+		entry := n.buf[start:end]
+		if len(entry) > len(key) && entry[len(key)] == '=' && string(entry[:len(key)]) == key {
+			return start, end
+		}
+		start = end
+	}
+	return -1, -1
+}
+
+// nvramLenMagic returns the word written at the end of device RAM which
+// encodes the NVRAM length in words and its complement.
+func nvramLenMagic(nvramLen uint32) uint32 {
+	nvramLenWords := nvramLen / 4
+	return ((^nvramLenWords) << 16) | nvramLenWords
+}
@@ -0,0 +1,149 @@
+package cyw43439
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/soypat/cyw43439/cywemu"
+)
+
+// entries returns the key=value entries of n in image order.
+func entries(n *NVRAM) (s []string) {
+	n.ForEach(func(key, value string) { s = append(s, key+"="+value) })
+	return s
+}
+
+func TestParseNVRAM(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		image string
+		want  []string
+		err   error
+	}{
+		{name: "null separated", image: "a=1\x00b=2\x00\x00", want: []string{"a=1", "b=2"}},
+		{name: "text file", image: "# Board config.\na=1\n\n  b = x \n#c=3\n", want: []string{"a=1", "b = x"}},
+		{name: "duplicate key", image: "a=1\x00b=2\x00a=3\x00", want: []string{"b=2", "a=3"}},
+		{name: "empty value", image: "a=\x00", want: []string{"a="}},
+		{name: "value with equals", image: "a=b=c\n", want: []string{"a=b=c"}},
+		{name: "missing equals", image: "a=1\nboardflags\n", err: errNVRAMEntry},
+		{name: "missing key", image: "=1\n", err: errNVRAMEntry},
+	} {
+		n, err := ParseNVRAM(test.image)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		got := entries(&n)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got entries %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNVRAMSet(t *testing.T) {
+	n, err := ParseNVRAM("xtalfreq=37400\nboardflags=0x00404001\naa2g=3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		key, value string
+		err        error
+	}{
+		{key: "boardflags", value: "0x1"},
+		{key: "maxp2ga0", value: "74"},
+		{key: "", value: "1", err: errNVRAMKey},
+		{key: "a=b", value: "1", err: errNVRAMKey},
+		{key: "a\nb", value: "1", err: errNVRAMKey},
+		{key: "aa2g", value: "1\n2", err: errNVRAMValue},
+		{key: "aa2g", value: "1\x002", err: errNVRAMValue},
+	} {
+		if err := n.Set(test.key, test.value); !errors.Is(err, test.err) {
+			t.Errorf("Set(%q, %q): got error %v, want %v", test.key, test.value, err, test.err)
+		}
+	}
+	// Overridden entries move to the end, rejected values leave entries untouched.
+	want := []string{"xtalfreq=37400", "aa2g=3", "boardflags=0x1", "maxp2ga0=74"}
+	if got := entries(&n); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+	if v, ok := n.Get("boardflags"); !ok || v != "0x1" {
+		t.Errorf("Get(boardflags)=%q,%v", v, ok)
+	}
+	if !n.Delete("xtalfreq") || n.Delete("xtalfreq") {
+		t.Error("delete of present key must succeed once")
+	}
+	if _, ok := n.Get("xtalfreq"); ok {
+		t.Error("deleted key present")
+	}
+	n.SetMAC([6]byte{0x02, 0xab, 0, 1, 2, 0xff})
+	if v, _ := n.Get("macaddr"); v != "02:ab:00:01:02:ff" {
+		t.Errorf("got macaddr %q", v)
+	}
+}
+
+func TestNVRAMCopy(t *testing.T) {
+	b := DefaultNVRAM()
+	want := b.Image()
+	a := b
+	a.Delete("xtalfreq")
+	a.Set("boardflags", "0x1")
+	a.Set("maxp2ga0", "74")
+	if got := b.Image(); got != want {
+		t.Error("modifying a copy changed the original")
+	}
+	b.Set("aa2g", "1")
+	if v, ok := a.Get("maxp2ga0"); !ok || v != "74" {
+		t.Errorf("modifying the original changed the copy: maxp2ga0=%q, present=%v", v, ok)
+	}
+}
+
+func TestNVRAMImage(t *testing.T) {
+	for _, test := range []struct {
+		image string
+		want  string
+	}{
+		{image: "", want: "\x00\x00\x00\x00"},
+		{image: "ab=1", want: "ab=1\x00\x00\x00\x00"}, // Entry and image null terminators.
+		{image: "a=1", want: "a=1\x00\x00\x00\x00\x00"},
+		{image: "abc=12", want: "abc=12\x00\x00"},
+	} {
+		n, err := ParseNVRAM(test.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n.Image(); got != test.want {
+			t.Errorf("Image of %q = %q, want %q", test.image, got, test.want)
+		}
+	}
+	for _, test := range []struct {
+		len  uint32
+		want uint32
+	}{
+		{len: 4, want: 0xfffe_0001},
+		{len: 0x300, want: 0xff3f_00c0},
+	} {
+		if got := nvramLenMagic(test.len); got != test.want {
+			t.Errorf("nvramLenMagic(%d)=%#x, want %#x", test.len, got, test.want)
+		}
+	}
+}
+
+func TestDefaultNVRAM(t *testing.T) {
+	n := DefaultNVRAM()
+	if v, ok := n.Get("xtalfreq"); !ok || v != "37400" {
+		t.Errorf("default xtalfreq=%q, present=%v", v, ok)
+	}
+
+	// Custom images are uploaded to the end of RAM followed by the length word.
+	n.Set("boardflags", "0x1")
+	cfg := testConfig()
+	cfg.NVRAM = n.Image()
+	_, chip := newTestDeviceConfig(t, cywemu.Config{}, cfg)
+	if got := chip.NVRAM(); !bytes.Equal(got, []byte(cfg.NVRAM)) {
+		t.Errorf("uploaded nvram differs from image:\n%q\n%q", got, cfg.NVRAM)
+	}
+}
@@ -0,0 +1,110 @@
+package cyw43439
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+var errProbeRequestShort = errors.New("probe request too short")
+
+// ProbeRequest is an 802.11 probe request received from a nearby station.
+type ProbeRequest struct {
+	// Source is the MAC address of the station sending the probe request.
+	// Many devices randomize this address while not associated.
+	Source [6]byte
+	// RSSI is the received signal strength in dBm.
+	RSSI int16
+	// Channel is the channel the probe request was received on.
+	Channel uint8
+	// Time is the time the probe request was processed by the host.
+	Time time.Time
+	// MACTime is the firmware timestamp of the frame in microseconds.
+	MACTime uint32
+	ssidLen uint8
+	ssid    [32]byte
+}
+
+// SSID returns the network name requested by the station. Empty for wildcard (broadcast) probe requests.
+func (pr *ProbeRequest) SSID() string { return string(pr.ssid[:pr.ssidLen]) }
+
+// SetProbeRequestHandler enables reception of probe requests sent by nearby stations and
+// calls handler for each one received. Works in station, access point and idle mode; while idle
+// probe requests are received on the channel the device is tuned to. If handler is nil
+// probe request reception is disabled.
+//
+// Probe requests are delivered during [Device.PollOne]. The handler is called
+// with the device lock held so it must not call methods on the Device.
+func (d *Device) SetProbeRequestHandler(handler func(*ProbeRequest)) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	d.info("SetProbeRequestHandler", slog.Bool("set", handler != nil))
+	prev := d.probeHandler
+	d.probeHandler = handler
+	// Firmware filters out probe request events by default.
+	fwmask := d.firmwareEventMask(&d.usermask)
+	err = d.setFirmwareEventMask(&fwmask)
+	if err != nil {
+		d.probeHandler = prev
+		return err
+	}
+	if handler != nil {
+		d.eventmask.Enable(whd.EvPROBREQ_MSG)
+		d.eventmask.Enable(whd.EvPROBREQ_MSG_RX)
+	} else {
+		d.eventmask.Disable(whd.EvPROBREQ_MSG)
+		d.eventmask.Disable(whd.EvPROBREQ_MSG_RX)
+	}
+	return nil
+}
+
+// rxProbeRequest processes PROBREQ_MSG event data which is made up of a
+// wl_event_rx_frame_data header followed by the 802.11 probe request frame.
+func (d *Device) rxProbeRequest(data []byte) error {
+	if d.probeHandler == nil {
+		return nil
+	}
+	// wl_event_rx_frame_data is in network order: version, chanspec, rssi, mactime, rate.
+	const (
+		rxFrameDataLen = 16
+		mgmtHeaderLen  = 24
+		fcProbeRequest = 0x40 // Management frame type, probe request subtype.
+	)
+	if len(data) < rxFrameDataLen+mgmtHeaderLen {
+		return errProbeRequestShort
+	}
+	chanspec := binary.BigEndian.Uint16(data[2:4])
+	pr := ProbeRequest{
+		Channel: uint8(chanspec & whd.WL_CHANSPEC_CHAN_MASK),
+		RSSI:    int16(int32(binary.BigEndian.Uint32(data[4:8]))),
+		MACTime: binary.BigEndian.Uint32(data[8:12]),
+		Time:    time.Now(),
+	}
+	frame := data[rxFrameDataLen:]
+	if frame[0] != fcProbeRequest {
+		return nil // Not a probe request.
+	}
+	copy(pr.Source[:], frame[10:16])
+	// Look for SSID information element.
+	ies := frame[mgmtHeaderLen:]
+	for len(ies) >= 2 {
+		id, ielen := ies[0], int(ies[1])
+		if 2+ielen > len(ies) {
+			break
+		}
+		if id == 0 && ielen <= 32 {
+			pr.ssidLen = uint8(ielen)
+			copy(pr.ssid[:], ies[2:2+ielen])
+			break
+		}
+		ies = ies[2+ielen:]
+	}
+	d.probeHandler(&pr)
+	return nil
+}
@@ -0,0 +1,82 @@
+package cyw43439
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+// probeRequestData returns PROBREQ_MSG event data for a frame with frame control fc
+// sent by src on channel ch. An empty ssid is a wildcard probe request.
+func probeRequestData(fc byte, src [6]byte, ch uint8, rssi int32, ssid string) []byte {
+	order := binary.BigEndian
+	// wl_event_rx_frame_data: version, chanspec, rssi, mactime, rate.
+	data := order.AppendUint16(nil, 2)
+	data = order.AppendUint16(data, whd.WL_CHANSPEC_BAND_2G|whd.WL_CHANSPEC_BW_20|whd.WL_CHANSPEC_CTL_SB_NONE|uint16(ch))
+	data = order.AppendUint32(data, uint32(rssi))
+	data = order.AppendUint32(data, 123456)
+	data = order.AppendUint32(data, 2)
+	// 802.11 management header: frame control, duration, destination, source, BSSID, sequence.
+	bcast := [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	data = append(data, fc, 0, 0, 0)
+	data = append(data, bcast[:]...)
+	data = append(data, src[:]...)
+	data = append(data, bcast[:]...)
+	data = append(data, 0, 0)
+	// Supported rates element precedes the SSID in some stations.
+	data = append(data, 1, 1, 0x82)
+	data = append(data, 0, byte(len(ssid)))
+	return append(data, ssid...)
+}
+
+func TestProbeRequest(t *testing.T) {
+	dev, chip := newTestDevice(t)
+	var got []ProbeRequest
+	handler := func(pr *ProbeRequest) { got = append(got, *pr) }
+	src := [6]byte{0x02, 0xbb, 0, 0, 0, 1}
+	queue := func(data []byte) {
+		chip.QueueEvent(whd.IF_STA, whd.EventMessage{EventType: whd.EvPROBREQ_MSG, Addr: src}, data)
+	}
+
+	// Disabled in the firmware by default.
+	queue(probeRequestData(0x40, src, 6, -55, "home"))
+	pollAll(t, dev, chip)
+
+	err := dev.SetProbeRequestHandler(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue(probeRequestData(0x40, src, 6, -55, "home"))
+	queue(probeRequestData(0x40, src, 11, -70, ""))
+	queue(probeRequestData(0x50, src, 11, -70, "")) // Probe response.
+	pollAll(t, dev, chip)
+	if len(got) != 2 {
+		t.Fatalf("want 2 probe requests, got %d", len(got))
+	}
+	pr := got[0]
+	if pr.Source != src || pr.SSID() != "home" || pr.Channel != 6 || pr.RSSI != -55 || pr.MACTime != 123456 || pr.Time.IsZero() {
+		t.Errorf("got probe request %+v ssid %q", pr, pr.SSID())
+	}
+	if pr = got[1]; pr.SSID() != "" || pr.Channel != 11 || pr.RSSI != -70 {
+		t.Errorf("got wildcard probe request %+v ssid %q", pr, pr.SSID())
+	}
+
+	got = got[:0]
+	queue(probeRequestData(0x40, src, 6, -55, "home")[:30])
+	_, err = dev.PollOne()
+	if !errors.Is(err, errProbeRequestShort) {
+		t.Errorf("want short probe request error, got %v", err)
+	}
+
+	err = dev.SetProbeRequestHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue(probeRequestData(0x40, src, 6, -55, "home"))
+	pollAll(t, dev, chip)
+	if len(got) != 0 {
+		t.Errorf("got %d probe requests, want none", len(got))
+	}
+}
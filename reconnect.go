@@ -0,0 +1,166 @@
+package cyw43439
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var errReconnectExhausted = errors.New("reconnect attempts exhausted")
+
+const (
+	defaultReconnectMinBackoff = time.Second
+	defaultReconnectMaxBackoff = time.Minute
+)
+
+// ReconnectConfig configures the station mode reconnect supervisor enabled with [Device.EnableReconnect].
+type ReconnectConfig struct {
+	// MinBackoff is the time waited after link loss before the first rejoin attempt.
+	// The wait is doubled after every failed attempt. Default is 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff caps the time waited between rejoin attempts. Default is 1 minute.
+	MaxBackoff time.Duration
+	// MaxAttempts is the amount of consecutive failed rejoin attempts after which
+	// the supervisor gives up until the next successful [Device.Join]. Zero means unlimited attempts.
+	MaxAttempts int
+}
+
+// ReconnectStatus describes the state of the reconnect supervisor.
+type ReconnectStatus struct {
+	// Enabled is true if the supervisor was enabled with [Device.EnableReconnect].
+	Enabled bool
+	// Reconnecting is true while the link is lost and the supervisor is attempting to rejoin.
+	Reconnecting bool
+	// Attempts is the amount of failed rejoin attempts since the link was lost.
+	Attempts int
+	// LastErr is the error of the last failed rejoin attempt. If MaxAttempts
+	// was reached it also reports the supervisor gave up.
+	LastErr error
+}
+
+type reconnectState struct {
+	cfg          ReconnectConfig
+	enabled      bool
+	reconnecting bool
+	hasParams    bool
+	ssid         string
+	opts         JoinOptions
+	attempts     int
+	backoff      time.Duration
+	next         time.Time
+	lastErr      error
+}
+
+// EnableReconnect enables the station mode reconnect supervisor. When the link to the
+// network joined with [Device.Join] is lost the supervisor rejoins the network with
+// the same parameters, waiting with exponential backoff between failed attempts.
+//
+// The supervisor runs inside [Device.PollOne], so PollOne must be called periodically.
+// A rejoin attempt blocks PollOne until the attempt succeeds or times out.
+// Calling [Device.Leave] stops the supervisor from rejoining until the next Join.
+func (d *Device) EnableReconnect(cfg ReconnectConfig) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	if cfg.MinBackoff < 0 || cfg.MaxBackoff < 0 || cfg.MaxAttempts < 0 {
+		return errors.New("invalid reconnect config")
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = defaultReconnectMinBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = defaultReconnectMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	d.info("reconnect:enable", slog.Duration("minbackoff", cfg.MinBackoff), slog.Duration("maxbackoff", cfg.MaxBackoff), slog.Int("maxattempts", cfg.MaxAttempts))
+	d.reconn.cfg = cfg
+	d.reconn.enabled = true
+	return nil
+}
+
+// DisableReconnect disables the reconnect supervisor. A rejoin in progress is abandoned.
+func (d *Device) DisableReconnect() {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		d.logerr("cyw:disablereconnect", slog.String("err", err.Error()))
+		return
+	}
+	d.reconn.enabled = false
+	d.reconn.reconnecting = false
+}
+
+// ReconnectStatus returns the current status of the reconnect supervisor.
+func (d *Device) ReconnectStatus() ReconnectStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ReconnectStatus{
+		Enabled:      d.reconn.enabled,
+		Reconnecting: d.reconn.reconnecting,
+		Attempts:     d.reconn.attempts,
+		LastErr:      d.reconn.lastErr,
+	}
+}
+
+// reconnect performs a single rejoin attempt and schedules the next attempt on failure.
+func (d *Device) reconnect() {
+	rc := &d.reconn
+	d.info("reconnect:attempt", slog.String("ssid", rc.ssid), slog.Int("attempt", rc.attempts+1))
+	err := d.join(rc.ssid, rc.opts)
+	if err == nil {
+		d.info("reconnect:success", slog.Int("attempts", rc.attempts+1))
+		rc.reconnecting = false
+		rc.attempts = 0
+		rc.lastErr = nil
+		return
+	}
+	rc.attempts++
+	rc.lastErr = err
+	d.warn("reconnect:fail", slog.Int("attempts", rc.attempts), slog.String("err", err.Error()))
+	if rc.cfg.MaxAttempts > 0 && rc.attempts >= rc.cfg.MaxAttempts {
+		rc.reconnecting = false
+		rc.lastErr = errjoin(errReconnectExhausted, err)
+		return
+	}
+	rc.next = time.Now().Add(rc.backoff)
+	rc.backoff = min(2*rc.backoff, rc.cfg.MaxBackoff)
+}
+
+// remember stores the parameters of a user initiated join.
+func (rc *reconnectState) remember(ssid string, opts JoinOptions) {
+	rc.hasParams = true
+	rc.ssid = ssid
+	rc.opts = opts
+	rc.reconnecting = false
+	rc.attempts = 0
+	rc.lastErr = nil
+}
+
+// forget clears join parameters so the supervisor does not rejoin.
+func (rc *reconnectState) forget() {
+	rc.hasParams = false
+	rc.ssid = ""
+	rc.opts = JoinOptions{}
+	rc.reconnecting = false
+}
+
+// linkLost schedules rejoin attempts after the link goes down.
+func (rc *reconnectState) linkLost(now time.Time) {
+	if !rc.enabled || !rc.hasParams || rc.reconnecting {
+		return
+	}
+	rc.reconnecting = true
+	rc.attempts = 0
+	rc.backoff = rc.cfg.MinBackoff
+	rc.next = now.Add(rc.backoff)
+	rc.backoff = min(2*rc.backoff, rc.cfg.MaxBackoff)
+}
+
+// due returns true if a rejoin attempt should be performed.
+func (rc *reconnectState) due(now time.Time) bool {
+	return rc.enabled && rc.reconnecting && rc.hasParams && !now.Before(rc.next)
+}
@@ -0,0 +1,117 @@
+package cyw43439
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+func TestReconnect(t *testing.T) {
+	const (
+		minBackoff = 20 * time.Millisecond
+		failures   = 2
+	)
+	var rejoins []time.Time
+	var rejecting bool
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{
+		Networks: []cywemu.Network{testWPA2Net},
+		OnIoctl: func(io *cywemu.Ioctl) bool {
+			if !rejecting || io.Cmd != whd.WLC_SET_SSID {
+				return false
+			}
+			rejoins = append(rejoins, time.Now())
+			if len(rejoins) > failures {
+				return false
+			}
+			io.Status = 1 // Fail the first rejoin attempts.
+			return true
+		},
+	}, testConfig())
+	err := dev.EnableReconnect(ReconnectConfig{MinBackoff: minBackoff, MaxBackoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: testWPA2Net.Passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejecting = true
+	lost := time.Now()
+	chip.Disconnect(3)
+	pollAll(t, dev, chip)
+	if dev.IsLinkUp() || !dev.ReconnectStatus().Reconnecting {
+		t.Fatal("supervisor not reconnecting after link loss")
+	}
+	// Backoff doubles after every failed attempt.
+	wantBackoff := []time.Duration{2 * minBackoff}
+	for attempts := 0; attempts < failures; attempts++ {
+		for len(rejoins) == attempts && time.Since(lost) < time.Second {
+			time.Sleep(time.Millisecond)
+			pollAll(t, dev, chip)
+		}
+		status := dev.ReconnectStatus()
+		if status.Attempts != attempts+1 || status.LastErr == nil {
+			t.Fatalf("want %d failed attempts, got %+v", attempts+1, status)
+		}
+		wantBackoff = append(wantBackoff, 2*wantBackoff[attempts])
+		if dev.reconn.backoff != wantBackoff[attempts+1] {
+			t.Errorf("attempt %d: backoff %s, want %s", attempts+1, dev.reconn.backoff, wantBackoff[attempts+1])
+		}
+	}
+	if gap1, gap2 := rejoins[0].Sub(lost), rejoins[1].Sub(rejoins[0]); gap1 < minBackoff || gap2 < 2*minBackoff {
+		t.Errorf("rejoin attempts not backed off: %s, %s", gap1, gap2)
+	}
+
+	for !dev.IsLinkUp() && time.Since(lost) < time.Second {
+		time.Sleep(time.Millisecond)
+		pollAll(t, dev, chip)
+	}
+	if !dev.IsLinkUp() {
+		t.Fatal("supervisor did not reconnect")
+	}
+	if n, ok := chip.Associated(); !ok || n.SSID != testWPA2Net.SSID {
+		t.Error("chip not associated after reconnect")
+	}
+	if status := dev.ReconnectStatus(); status.Reconnecting || status.Attempts != 0 || status.LastErr != nil {
+		t.Errorf("unexpected status after reconnect %+v", status)
+	}
+}
+
+func TestReconnectLeave(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet)
+	err := dev.EnableReconnect(ReconnectConfig{MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.Join(testOpenNet.SSID, JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave stops the supervisor from rejoining.
+	err = dev.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	pollAll(t, dev, chip)
+	if dev.IsLinkUp() || dev.ReconnectStatus().Reconnecting {
+		t.Error("supervisor rejoined after leave")
+	}
+
+	// Disabled supervisor does not rejoin.
+	err = dev.Join(testOpenNet.SSID, JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.DisableReconnect()
+	chip.Disconnect(3)
+	pollAll(t, dev, chip)
+	time.Sleep(5 * time.Millisecond)
+	pollAll(t, dev, chip)
+	if dev.IsLinkUp() || dev.ReconnectStatus().Enabled {
+		t.Error("disabled supervisor rejoined")
+	}
+}
@@ -0,0 +1,268 @@
+package cyw43439
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/soypat/cyw43439/whd"
+)
+
+var (
+	errScanInProgress = errors.New("scan already in progress")
+	errScanTimeout    = errors.New("scan timeout")
+	errScanAborted    = errors.New("scan aborted by firmware")
+	errScanChannel    = errors.New("invalid scan channel")
+)
+
+const (
+	// scanTimeout is the maximum time a scan may take before it is considered failed.
+	scanTimeout = 10 * time.Second
+	// maxScanDedup is the amount of BSSIDs remembered during a scan for deduplication.
+	// Access points found after the limit is reached may be reported more than once.
+	maxScanDedup = 32
+	// maxChannel2G is the highest 2.4GHz channel number, channels start at 1.
+	maxChannel2G = 14
+)
+
+// ScanSecurity is a bitfield of security protocols advertised by an access point.
+type ScanSecurity uint8
+
+const (
+	// ScanSecurityWEP is set for networks with privacy enabled and no WPA/RSN information.
+	ScanSecurityWEP ScanSecurity = whd.SCAN_AUTH_WEP
+	// ScanSecurityWPA is set for networks advertising WPA.
+	ScanSecurityWPA ScanSecurity = whd.SCAN_AUTH_WPA
+	// ScanSecurityWPA2 is set for networks advertising WPA2 pre-shared key.
+	ScanSecurityWPA2 ScanSecurity = whd.SCAN_AUTH_WPA2
+	// ScanSecurityWPA3 is set for networks advertising WPA3 SAE.
+	ScanSecurityWPA3 ScanSecurity = whd.SCAN_AUTH_SAE
+)
+
+// IsOpen returns true if the network requires no authentication.
+func (s ScanSecurity) IsOpen() bool { return s == 0 }
+
+func (s ScanSecurity) String() (str string) {
+	if s == 0 {
+		return "open"
+	}
+	if s&ScanSecurityWEP != 0 {
+		str += "WEP "
+	}
+	if s&ScanSecurityWPA != 0 {
+		str += "WPA "
+	}
+	if s&ScanSecurityWPA2 != 0 {
+		str += "WPA2 "
+	}
+	if s&ScanSecurityWPA3 != 0 {
+		str += "WPA3 "
+	}
+	return str[:len(str)-1]
+}
+
+// JoinAuth returns the authentication method best suited to join a network
+// advertising the security protocols in s.
+func (s ScanSecurity) JoinAuth() JoinAuth {
+	switch {
+	case s&ScanSecurityWPA2 != 0 && s&ScanSecurityWPA3 != 0:
+		return JoinAuthWPA2WPA3
+	case s&ScanSecurityWPA3 != 0:
+		return JoinAuthWPA3
+	case s&ScanSecurityWPA2 != 0:
+		return JoinAuthWPA2
+	case s&ScanSecurityWPA != 0:
+		return JoinAuthWPA
+	}
+	return JoinAuthOpen
+}
+
+// ScanOptions configures a WiFi scan performed with [Device.Scan].
+type ScanOptions struct {
+	// Passive selects passive scanning: the device listens for beacons
+	// instead of transmitting probe requests. Passive scans take longer.
+	Passive bool
+	// SSID restricts the scan to access points with this name. Needed to find hidden networks.
+	// If empty all networks are scanned.
+	SSID string
+	// BSSID restricts the scan to a single access point. The zero value matches any access point.
+	BSSID [6]byte
+	// Channels restricts the scan to the listed 2.4GHz channels. If nil all channels are scanned.
+	Channels []uint8
+}
+
+// ScanResult describes an access point found during a scan.
+type ScanResult struct {
+	// BSSID is the MAC address of the access point.
+	BSSID [6]byte
+	// Channel is the 2.4GHz channel the access point operates on.
+	Channel uint8
+	// RSSI is the received signal strength in dBm.
+	RSSI int16
+	// Security is the set of security protocols advertised by the access point.
+	Security ScanSecurity
+	ssidLen  uint8
+	ssid     [32]byte
+}
+
+// SSID returns the network name of the access point. Hidden networks may report an empty SSID.
+func (sr *ScanResult) SSID() string { return string(sr.ssid[:sr.ssidLen]) }
+
+type scanState struct {
+	onResult func(ScanResult)
+	active   bool
+	done     bool
+	status   whd.EStatus
+	syncID   uint16
+	nseen    uint8
+	seen     [maxScanDedup][6]byte
+}
+
+// Scan performs a WiFi scan for access points and calls onResult for every
+// access point found. Each BSSID is reported once. Scan blocks until the scan completes.
+// onResult is called with the device lock held so it must not call methods on the Device.
+func (d *Device) Scan(opts ScanOptions, onResult func(ScanResult)) error {
+	return d.ScanContext(context.Background(), opts, onResult)
+}
+
+// ScanContext is like [Device.Scan] but aborts the scan and returns the context's
+// error if ctx is canceled or its deadline is exceeded before the scan completes.
+func (d *Device) ScanContext(ctx context.Context, opts ScanOptions, onResult func(ScanResult)) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	d.ctx = ctx
+	defer d.clearContext()
+	return d.scan(opts, onResult)
+}
+
+func (d *Device) scan(opts ScanOptions, onResult func(ScanResult)) (err error) {
+	if d.scanst.active {
+		return errScanInProgress
+	}
+	if len(opts.SSID) > 32 {
+		return errors.New("ssid too long")
+	} else if len(opts.Channels) > whd.SCAN_MAX_CHANNELS {
+		return errors.New("too many scan channels")
+	}
+	d.info("scan:start", slog.Bool("passive", opts.Passive), slog.Int("nchan", len(opts.Channels)))
+	start := time.Now()
+	// reference: cyw43_ll_wifi_scan
+	params := whd.ScanOptions{
+		Version:     whd.ESCAN_REQ_VERSION,
+		Action:      whd.WL_SCAN_ACTION_START,
+		SyncID:      d.scanst.syncID + 1,
+		SSIDLength:  uint32(len(opts.SSID)),
+		BSSID:       opts.BSSID,
+		BSSType:     whd.WL_BSS_TYPE_ANY,
+		ScanType:    whd.WL_SCAN_TYPE_ACTIVE,
+		NProbes:     -1,
+		ActiveTime:  -1,
+		PassiveTime: -1,
+		HomeTime:    -1,
+		ChannelNum:  int32(len(opts.Channels)),
+	}
+	if opts.Passive {
+		params.ScanType = whd.WL_SCAN_TYPE_PASSIVE
+	}
+	if params.BSSID == [6]byte{} {
+		params.BSSID = [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+	copy(params.SSID[:], opts.SSID)
+	for i, ch := range opts.Channels {
+		if ch == 0 || ch > maxChannel2G {
+			return errScanChannel
+		}
+		params.ChannelList[i] = whd.ChanSpec20(ch)
+	}
+	var buf [72 + 2*whd.SCAN_MAX_CHANNELS]byte
+	n := params.Put(_busOrder, buf[:])
+
+	d.scanst = scanState{
+		onResult: onResult,
+		active:   true,
+		syncID:   params.SyncID,
+	}
+	d.eventmask.Enable(whd.EvESCAN_RESULT)
+	defer func() {
+		d.eventmask.Disable(whd.EvESCAN_RESULT)
+		d.scanst.active = false
+		d.scanst.onResult = nil
+	}()
+	err = d.set_iovar_n("escan", whd.IF_STA, buf[:n])
+	if err != nil {
+		return err
+	}
+
+	timeout := d.cfg.ScanTimeout
+	if timeout <= 0 {
+		timeout = scanTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for !d.scanst.done {
+		if time.Since(deadline) >= 0 {
+			return errScanTimeout
+		}
+		if err = d.sleep(10 * time.Millisecond); err != nil {
+			// Abort the scan in progress.
+			params.Action = whd.WL_SCAN_ACTION_ABORT
+			n = params.Put(_busOrder, buf[:])
+			d.set_iovar_n("escan", whd.IF_STA, buf[:n])
+			return err
+		}
+		err = d.check_status(d._sendIoctlBuf[:])
+		if err != nil {
+			return err
+		}
+	}
+	d.info("scan:done", slog.Int("found", int(d.scanst.nseen)), slog.Duration("took", time.Since(start)))
+	if d.scanst.status != whd.EStatusSuccess {
+		return errScanAborted
+	}
+	return nil
+}
+
+// rxScanResult processes ESCAN_RESULT event data.
+func (d *Device) rxScanResult(status whd.EStatus, data []byte) error {
+	if !d.scanst.active {
+		return nil // Stale result from an aborted scan.
+	}
+	// wl_escan_result_t starts with buflen, version and sync_id. Results of a
+	// scan aborted by timeout or context cancellation may arrive during the next scan.
+	if len(data) >= 10 && _busOrder.Uint16(data[8:10]) != d.scanst.syncID {
+		return nil
+	}
+	if status != whd.EStatusPartial {
+		// Any other status marks the end of the scan.
+		d.scanst.done = true
+		d.scanst.status = status
+		return nil
+	}
+	sr, err := whd.ParseScanResult(_busOrder, data)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(d.scanst.nseen); i++ {
+		if d.scanst.seen[i] == sr.BSSID {
+			return nil // Already reported.
+		}
+	}
+	if d.scanst.nseen < maxScanDedup {
+		d.scanst.seen[d.scanst.nseen] = sr.BSSID
+		d.scanst.nseen++
+	}
+	if d.scanst.onResult != nil {
+		d.scanst.onResult(ScanResult{
+			BSSID:    sr.BSSID,
+			Channel:  uint8(sr.Channel),
+			RSSI:     sr.RSSI,
+			Security: ScanSecurity(sr.AuthMode),
+			ssidLen:  sr.SSIDLength,
+			ssid:     sr.SSID,
+		})
+	}
+	return nil
+}
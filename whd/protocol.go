@@ -5,7 +5,6 @@ import (
 	"errors"
 	"io"
 	"runtime"
-	"unsafe"
 
 	"github.com/soypat/seqs/eth"
 )
@@ -172,6 +171,8 @@ type AsyncEvent struct {
 }
 
 // ParseAsyncEvent c-ref:BigEndian
+// order is used for the event header only, escan result data is in device order
+// (little endian), see [ParseScanResult].
 // reference: cyw43_ll_parse_async_event
 func ParseAsyncEvent(order binary.ByteOrder, buf []byte) (ev AsyncEvent, err error) {
 	if len(buf) < 48 {
@@ -184,11 +185,8 @@ func ParseAsyncEvent(order binary.ByteOrder, buf []byte) (ev AsyncEvent, err err
 	const ifaceOffset = 12 + 4 + 30
 	ev.Interface = buf[ifaceOffset]
 	if ev.EventType == CYW43_EV_ESCAN_RESULT && ev.Status == CYW43_STATUS_PARTIAL {
-		const sizeStruct = unsafe.Sizeof(ev)
-		if len(buf) < int(sizeStruct) {
-			return ev, io.ErrShortBuffer
-		}
-		ev.u, err = ParseScanResult(order, buf[48:])
+		// Scan result data is in device order, unlike the event header.
+		ev.u, err = ParseScanResult(binary.LittleEndian, buf[48:])
 	}
 	return ev, err
 }
@@ -197,81 +195,139 @@ func (ev *AsyncEvent) EventScanResult() *EventScanResult {
 	return &ev.u
 }
 
-type evscanresult struct {
-	Version      uint32   // 0:4
-	Length       uint32   // 4:8
-	BSSID        [6]byte  // 8:14
-	BeaconPeriod uint16   // 14:16
-	SSIDLength   uint8    // 16:17
-	Capability   uint16   // 17:19
-	SSID         [32]byte //
-	RatesetCount uint32
-	RatesetRates [16]uint8
-	ChanSpec     uint16
-	AtimWindow   uint16
-	DtimPeriod   uint8
-	RSSI         int16
-	PHYNoise     int8
-	NCap         uint8
-	NBSSCap      uint32
-	CtlCh        uint8
-	_            [1]uint32
-	Flags        uint8
-	_            [3]uint8
-	BasicMCS     [16]uint8
-	IEOffset     uint16
-	IELength     uint32
-	SNR          int16
-}
-
 // EventScanResult holds wifi scan results.
 type EventScanResult struct {
-	_ [5]uint32
 	// Access point MAC address.
 	BSSID [6]uint8
-	_     [2]uint16
+	// Beacon interval in time units (1024µs).
+	BeaconPeriod uint16
+	// 802.11 capability information field.
+	Capability uint16
 	// Length of access point name.
 	SSIDLength uint8
 	// WLAN access point name.
-	SSID    [32]byte
-	_       [5]uint32
+	SSID [32]byte
+	// Channel is the control channel of the access point.
 	Channel uint16
-	_       uint16
-	// Wifi auth mode. See CYW43_AUTH_*.
+	// ChanSpec is the raw chanspec the BSS was found on.
+	ChanSpec uint16
+	// Wifi auth mode. Bitfield of SCAN_AUTH_* values. Zero means open network.
 	AuthMode uint8
-	// Signal strength.
+	// Signal strength in dBm.
 	RSSI int16
+	// PHY noise floor in dBm.
+	PHYNoise int8
 }
 
+// Offsets of wl_bss_info_t fields relative to start of BSS info.
+const (
+	bssiVersion      = 0
+	bssiLength       = 4
+	bssiBSSID        = 8
+	bssiBeaconPeriod = 14
+	bssiCapability   = 16
+	bssiSSIDLength   = 18
+	bssiSSID         = 19
+	bssiChanSpec     = 72
+	bssiRSSI         = 78
+	bssiPHYNoise     = 80
+	bssiIEOffset     = 116
+	bssiIELength     = 120
+	bssiSize         = 128
+	// escanResultHeaderLen is size of wl_escan_result_t header fields before BSS info (buflen, version, sync_id, bss_count).
+	escanResultHeaderLen = 12
+)
+
+// ParseScanResult parses a wl_escan_result_t as received in the data of a
+// ESCAN_RESULT async event with PARTIAL status. Only the first BSS info is parsed.
+// The escan result is in device order (little endian), unlike the event message header.
+//
 // reference: cyw43_ll_wifi_parse_scan_result
 func ParseScanResult(order binary.ByteOrder, buf []byte) (sr EventScanResult, err error) {
-	type scanresult struct {
-		buflen   uint32
-		version  uint32
-		syncid   uint16
-		bssCount uint16
-		bss      evscanresult
+	if len(buf) < escanResultHeaderLen+bssiSize {
+		return sr, io.ErrShortBuffer
 	}
-	if len(buf) > int(unsafe.Sizeof(scanresult{})) {
+	bss := buf[escanResultHeaderLen:]
+	bssLen := order.Uint32(bss[bssiLength:])
+	ieOffset := uint32(order.Uint16(bss[bssiIEOffset:]))
+	ieLength := order.Uint32(bss[bssiIELength:])
+	if ieOffset > bssLen || ieLength > bssLen-ieOffset {
+		return sr, errIEEndExceedsBSS
+	} else if ieOffset+ieLength > uint32(len(bss)) {
 		return sr, io.ErrShortBuffer
 	}
-	ptr := unsafe.Pointer(&buf[0])
-	if uintptr(ptr)%4 != 0 {
-		return sr, errBufferUnaligned
+	copy(sr.BSSID[:], bss[bssiBSSID:])
+	sr.BeaconPeriod = order.Uint16(bss[bssiBeaconPeriod:])
+	sr.Capability = order.Uint16(bss[bssiCapability:])
+	sr.SSIDLength = min(bss[bssiSSIDLength], 32)
+	copy(sr.SSID[:], bss[bssiSSID:bssiSSID+int(sr.SSIDLength)])
+	sr.ChanSpec = order.Uint16(bss[bssiChanSpec:])
+	sr.Channel = sr.ChanSpec & WL_CHANSPEC_CHAN_MASK
+	sr.RSSI = int16(order.Uint16(bss[bssiRSSI:]))
+	sr.PHYNoise = int8(bss[bssiPHYNoise])
+
+	// Parse information elements to determine security type.
+	ies := bss[ieOffset : ieOffset+ieLength]
+	for len(ies) >= 2 {
+		ieType := ies[0]
+		ieLen := int(ies[1])
+		if 2+ieLen > len(ies) {
+			break
+		}
+		ie := ies[2 : 2+ieLen]
+		switch {
+		case ieType == DOT11_IE_ID_RSN:
+			sr.AuthMode |= rsnAuthMode(ie)
+		case ieType == DOT11_IE_ID_VENDOR_SPECIFIC && len(ie) >= 4 && string(ie[:4]) == WPA_OUI_TYPE1:
+			sr.AuthMode |= SCAN_AUTH_WPA
+		}
+		ies = ies[2+ieLen:]
 	}
-	scan := (*scanresult)(ptr)
-	if uint32(scan.bss.IEOffset)+scan.bss.IELength > scan.bss.Length {
-		return sr, errIEEndExceedsBSS
+	if sr.Capability&DOT11_CAP_PRIVACY != 0 && sr.AuthMode == 0 {
+		sr.AuthMode |= SCAN_AUTH_WEP
 	}
-	// TODO(soypat): lots of stuff missing here.
-	return *(*EventScanResult)(unsafe.Pointer(&scan.bss)), nil
+	return sr, nil
 }
 
-// ScanOptions are wifi scan options.
+// rsnAuthMode returns SCAN_AUTH_WPA2 and/or SCAN_AUTH_SAE depending on
+// the AKM suites advertised in the RSN information element body.
+// A malformed or truncated element is assumed to be WPA2.
+func rsnAuthMode(rsn []byte) (mode uint8) {
+	const (
+		akmOffset = 2 + 4 // version + group cipher suite.
+		akmPSK    = 2
+		akmSAE    = 8
+	)
+	if len(rsn) < akmOffset+2 {
+		return SCAN_AUTH_WPA2 // Malformed or truncated, assume WPA2.
+	}
+	// RSN fields are little endian as per 802.11.
+	npairwise := int(binary.LittleEndian.Uint16(rsn[akmOffset:]))
+	if akmOffset+2+4*npairwise+2 > len(rsn) {
+		return SCAN_AUTH_WPA2 // Pairwise cipher count exceeds the element.
+	}
+	akms := rsn[akmOffset+2+4*npairwise:]
+	nakm := int(binary.LittleEndian.Uint16(akms))
+	akms = akms[2:]
+	for i := 0; i < nakm && len(akms) >= 4; i++ {
+		if akms[3] == akmSAE {
+			mode |= SCAN_AUTH_SAE
+		} else {
+			mode |= SCAN_AUTH_WPA2
+		}
+		akms = akms[4:]
+	}
+	if mode == 0 {
+		mode = SCAN_AUTH_WPA2
+	}
+	return mode
+}
+
+// ScanOptions are wifi scan options, a.k.a wl_escan_params_t.
 type ScanOptions struct {
 	Version uint32
 	Action  uint16
-	_       uint16
+	SyncID  uint16
 	// 0=all
 	SSIDLength uint32
 	// SSID Name.
@@ -284,8 +340,42 @@ type ScanOptions struct {
 	ActiveTime  int32
 	PassiveTime int32
 	HomeTime    int32
+	// Number of valid entries in ChannelList. Zero scans all channels.
 	ChannelNum  int32
-	ChannelList [1]uint16
+	ChannelList [SCAN_MAX_CHANNELS]uint16
+}
+
+// Put writes the escan parameters to b and returns the amount of bytes written,
+// which is padded to a multiple of 4. b must be at least 72+2*SCAN_MAX_CHANNELS bytes long.
+func (so *ScanOptions) Put(order binary.ByteOrder, b []byte) int {
+	const chanOffset = 72
+	nch := int(so.ChannelNum)
+	if nch < 0 || nch > len(so.ChannelList) {
+		nch = 0
+	}
+	n := chanOffset + 2*max(nch, 1)
+	n = (n + 3) &^ 3
+	_ = b[n-1]
+	order.PutUint32(b[0:], so.Version)
+	order.PutUint16(b[4:], so.Action)
+	order.PutUint16(b[6:], so.SyncID)
+	order.PutUint32(b[8:], so.SSIDLength)
+	copy(b[12:44], so.SSID[:])
+	copy(b[44:50], so.BSSID[:])
+	b[50] = byte(so.BSSType)
+	b[51] = byte(so.ScanType)
+	order.PutUint32(b[52:], uint32(so.NProbes))
+	order.PutUint32(b[56:], uint32(so.ActiveTime))
+	order.PutUint32(b[60:], uint32(so.PassiveTime))
+	order.PutUint32(b[64:], uint32(so.HomeTime))
+	order.PutUint32(b[68:], uint32(nch))
+	for i := chanOffset; i < n; i++ {
+		b[i] = 0
+	}
+	for i := 0; i < nch; i++ {
+		order.PutUint16(b[chanOffset+2*i:], so.ChannelList[i])
+	}
+	return n
 }
 
 type DownloadHeader struct {
@@ -368,6 +458,32 @@ func DecodeEventHeader(order binary.ByteOrder, buf []byte) (ev EventHeader) {
 	return ev
 }
 
+// Put puts all 10 bytes of the event header in buf.
+func (ev *EventHeader) Put(order binary.ByteOrder, buf []byte) {
+	_ = buf[9]
+	order.PutUint16(buf, ev.Subtype)
+	order.PutUint16(buf[2:], ev.Length)
+	buf[4] = ev.Version
+	copy(buf[5:8], ev.OUI[:])
+	order.PutUint16(buf[8:], ev.UserSubtype)
+}
+
+// Put puts all 48 bytes of the event message in buf.
+func (ev *EventMessage) Put(order binary.ByteOrder, buf []byte) {
+	_ = buf[47]
+	order.PutUint16(buf, ev.Version)
+	order.PutUint16(buf[2:], ev.Flags)
+	order.PutUint32(buf[4:], uint32(ev.EventType))
+	order.PutUint32(buf[8:], ev.Status)
+	order.PutUint32(buf[12:], ev.Reason)
+	order.PutUint32(buf[16:], ev.AuthType)
+	order.PutUint32(buf[20:], ev.DataLen)
+	copy(buf[24:30], ev.Addr[:])
+	copy(buf[30:46], ev.IFName[:])
+	buf[46] = ev.IFIdx
+	buf[47] = ev.BSSCfgIdx
+}
+
 func DecodeEventMessage(order binary.ByteOrder, buf []byte) (ev EventMessage) {
 	_ = buf[47]
 	ev.Version = order.Uint16(buf)
@@ -10,55 +10,71 @@ func _() {
 	var x [1]struct{}
 	_ = x[WLC_UP-2]
 	_ = x[WLC_DOWN-3]
+	_ = x[WLC_GET_RATE-12]
 	_ = x[WLC_SET_INFRA-20]
 	_ = x[WLC_SET_AUTH-22]
 	_ = x[WLC_GET_BSSID-23]
 	_ = x[WLC_GET_SSID-25]
 	_ = x[WLC_SET_SSID-26]
+	_ = x[WLC_GET_CHANNEL-29]
 	_ = x[WLC_SET_CHANNEL-30]
 	_ = x[WLC_DISASSOC-52]
 	_ = x[WLC_GET_ANTDIV-63]
 	_ = x[WLC_SET_ANTDIV-64]
+	_ = x[WLC_SET_BCNPRD-76]
 	_ = x[WLC_SET_DTIMPRD-78]
 	_ = x[WLC_GET_PM-85]
 	_ = x[WLC_SET_PM-86]
+	_ = x[WLC_SET_MONITOR-108]
 	_ = x[WLC_SET_GMODE-110]
 	_ = x[WLC_SET_AP-118]
+	_ = x[WLC_GET_RSSI-127]
 	_ = x[WLC_SET_WSEC-134]
+	_ = x[WLC_GET_PHY_NOISE-135]
 	_ = x[WLC_SET_BAND-142]
 	_ = x[WLC_GET_ASSOCLIST-159]
+	_ = x[WLC_GET_UP-162]
 	_ = x[WLC_SET_WPA_AUTH-165]
+	_ = x[WLC_SCB_DEAUTHENTICATE_FOR_REASON-201]
 	_ = x[WLC_SET_VAR-263]
 	_ = x[WLC_GET_VAR-262]
 	_ = x[WLC_SET_WSEC_PMK-268]
 }
 
-const _SDPCMCommand_name = "UPDOWNSET_INFRASET_AUTHGET_BSSIDGET_SSIDSET_SSIDSET_CHANNELDISASSOCGET_ANTDIVSET_ANTDIVSET_DTIMPRDGET_PMSET_PMSET_GMODESET_APSET_WSECSET_BANDGET_ASSOCLISTSET_WPA_AUTHGET_VARSET_VARSET_WSEC_PMK"
+const _SDPCMCommand_name = "UPDOWNGET_RATESET_INFRASET_AUTHGET_BSSIDGET_SSIDSET_SSIDGET_CHANNELSET_CHANNELDISASSOCGET_ANTDIVSET_ANTDIVSET_BCNPRDSET_DTIMPRDGET_PMSET_PMSET_MONITORSET_GMODESET_APGET_RSSISET_WSECGET_PHY_NOISESET_BANDGET_ASSOCLISTGET_UPSET_WPA_AUTHSCB_DEAUTHENTICATE_FOR_REASONGET_VARSET_VARSET_WSEC_PMK"
 
 var _SDPCMCommand_map = map[SDPCMCommand]string{
 	2:   _SDPCMCommand_name[0:2],
 	3:   _SDPCMCommand_name[2:6],
-	20:  _SDPCMCommand_name[6:15],
-	22:  _SDPCMCommand_name[15:23],
-	23:  _SDPCMCommand_name[23:32],
-	25:  _SDPCMCommand_name[32:40],
-	26:  _SDPCMCommand_name[40:48],
-	30:  _SDPCMCommand_name[48:59],
-	52:  _SDPCMCommand_name[59:67],
-	63:  _SDPCMCommand_name[67:77],
-	64:  _SDPCMCommand_name[77:87],
-	78:  _SDPCMCommand_name[87:98],
-	85:  _SDPCMCommand_name[98:104],
-	86:  _SDPCMCommand_name[104:110],
-	110: _SDPCMCommand_name[110:119],
-	118: _SDPCMCommand_name[119:125],
-	134: _SDPCMCommand_name[125:133],
-	142: _SDPCMCommand_name[133:141],
-	159: _SDPCMCommand_name[141:154],
-	165: _SDPCMCommand_name[154:166],
-	262: _SDPCMCommand_name[166:173],
-	263: _SDPCMCommand_name[173:180],
-	268: _SDPCMCommand_name[180:192],
+	12:  _SDPCMCommand_name[6:14],
+	20:  _SDPCMCommand_name[14:23],
+	22:  _SDPCMCommand_name[23:31],
+	23:  _SDPCMCommand_name[31:40],
+	25:  _SDPCMCommand_name[40:48],
+	26:  _SDPCMCommand_name[48:56],
+	29:  _SDPCMCommand_name[56:67],
+	30:  _SDPCMCommand_name[67:78],
+	52:  _SDPCMCommand_name[78:86],
+	63:  _SDPCMCommand_name[86:96],
+	64:  _SDPCMCommand_name[96:106],
+	76:  _SDPCMCommand_name[106:116],
+	78:  _SDPCMCommand_name[116:127],
+	85:  _SDPCMCommand_name[127:133],
+	86:  _SDPCMCommand_name[133:139],
+	108: _SDPCMCommand_name[139:150],
+	110: _SDPCMCommand_name[150:159],
+	118: _SDPCMCommand_name[159:165],
+	127: _SDPCMCommand_name[165:173],
+	134: _SDPCMCommand_name[173:181],
+	135: _SDPCMCommand_name[181:194],
+	142: _SDPCMCommand_name[194:202],
+	159: _SDPCMCommand_name[202:215],
+	162: _SDPCMCommand_name[215:221],
+	165: _SDPCMCommand_name[221:233],
+	201: _SDPCMCommand_name[233:262],
+	262: _SDPCMCommand_name[262:269],
+	263: _SDPCMCommand_name[269:276],
+	268: _SDPCMCommand_name[276:288],
 }
 
 func (i SDPCMCommand) String() string {
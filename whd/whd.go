@@ -87,6 +87,11 @@ const (
 	DL_HEADER_LEN    = 12 // DownloadHeader size.
 )
 
+// BDC header flags.
+const (
+	BDC_FLAG2_IF_MASK = 0x0f // Interface index in BDCHeader.Flags2.
+)
+
 const (
 	SDIO_FUNCTION2_WATERMARK    = 0x10008
 	SDIO_BACKPLANE_ADDRESS_LOW  = 0x1000a
@@ -216,29 +221,37 @@ const (
 type SDPCMCommand uint32
 
 const (
-	WLC_UP            SDPCMCommand = 2
-	WLC_DOWN          SDPCMCommand = 3
-	WLC_SET_INFRA     SDPCMCommand = 20
-	WLC_SET_AUTH      SDPCMCommand = 22
-	WLC_GET_BSSID     SDPCMCommand = 23
-	WLC_GET_SSID      SDPCMCommand = 25
-	WLC_SET_SSID      SDPCMCommand = 26
-	WLC_SET_CHANNEL   SDPCMCommand = 30
-	WLC_DISASSOC      SDPCMCommand = 52
-	WLC_GET_ANTDIV    SDPCMCommand = 63
-	WLC_SET_ANTDIV    SDPCMCommand = 64
-	WLC_SET_DTIMPRD   SDPCMCommand = 78
-	WLC_GET_PM        SDPCMCommand = 85
-	WLC_SET_PM        SDPCMCommand = 86
-	WLC_SET_GMODE     SDPCMCommand = 110
-	WLC_SET_AP        SDPCMCommand = 118
-	WLC_SET_WSEC      SDPCMCommand = 134
-	WLC_SET_BAND      SDPCMCommand = 142
-	WLC_GET_ASSOCLIST SDPCMCommand = 159
-	WLC_SET_WPA_AUTH  SDPCMCommand = 165
-	WLC_SET_VAR       SDPCMCommand = 263
-	WLC_GET_VAR       SDPCMCommand = 262
-	WLC_SET_WSEC_PMK  SDPCMCommand = 268
+	WLC_UP                            SDPCMCommand = 2
+	WLC_DOWN                          SDPCMCommand = 3
+	WLC_GET_RATE                      SDPCMCommand = 12
+	WLC_SET_INFRA                     SDPCMCommand = 20
+	WLC_SET_AUTH                      SDPCMCommand = 22
+	WLC_GET_BSSID                     SDPCMCommand = 23
+	WLC_GET_SSID                      SDPCMCommand = 25
+	WLC_SET_SSID                      SDPCMCommand = 26
+	WLC_GET_CHANNEL                   SDPCMCommand = 29
+	WLC_SET_CHANNEL                   SDPCMCommand = 30
+	WLC_DISASSOC                      SDPCMCommand = 52
+	WLC_GET_ANTDIV                    SDPCMCommand = 63
+	WLC_SET_ANTDIV                    SDPCMCommand = 64
+	WLC_SET_BCNPRD                    SDPCMCommand = 76
+	WLC_SET_DTIMPRD                   SDPCMCommand = 78
+	WLC_GET_PM                        SDPCMCommand = 85
+	WLC_SET_PM                        SDPCMCommand = 86
+	WLC_SET_MONITOR                   SDPCMCommand = 108
+	WLC_SET_GMODE                     SDPCMCommand = 110
+	WLC_SET_AP                        SDPCMCommand = 118
+	WLC_GET_RSSI                      SDPCMCommand = 127
+	WLC_SET_WSEC                      SDPCMCommand = 134
+	WLC_GET_PHY_NOISE                 SDPCMCommand = 135
+	WLC_SET_BAND                      SDPCMCommand = 142
+	WLC_GET_ASSOCLIST                 SDPCMCommand = 159
+	WLC_GET_UP                        SDPCMCommand = 162
+	WLC_SET_WPA_AUTH                  SDPCMCommand = 165
+	WLC_SCB_DEAUTHENTICATE_FOR_REASON SDPCMCommand = 201
+	WLC_SET_VAR                       SDPCMCommand = 263
+	WLC_GET_VAR                       SDPCMCommand = 262
+	WLC_SET_WSEC_PMK                  SDPCMCommand = 268
 )
 
 func (cmd SDPCMCommand) IsValid() bool {
@@ -247,7 +260,9 @@ func (cmd SDPCMCommand) IsValid() bool {
 		cmd == WLC_GET_ANTDIV || cmd == WLC_SET_ANTDIV || cmd == WLC_SET_DTIMPRD || cmd == WLC_GET_PM ||
 		cmd == WLC_SET_PM || cmd == WLC_SET_GMODE || cmd == WLC_SET_AP || cmd == WLC_SET_WSEC || cmd == WLC_SET_BAND ||
 		cmd == WLC_GET_ASSOCLIST || cmd == WLC_SET_WPA_AUTH || cmd == WLC_SET_VAR || cmd == WLC_GET_VAR ||
-		cmd == WLC_SET_WSEC_PMK
+		cmd == WLC_SET_WSEC_PMK || cmd == WLC_GET_RATE || cmd == WLC_GET_CHANNEL || cmd == WLC_GET_RSSI ||
+		cmd == WLC_GET_PHY_NOISE || cmd == WLC_SCB_DEAUTHENTICATE_FOR_REASON ||
+		cmd == WLC_SET_BCNPRD || cmd == WLC_SET_MONITOR || cmd == WLC_GET_UP
 }
 
 // SDIO bus specifics
@@ -309,6 +324,40 @@ const (
 	WPA_OUI_TYPE1               = "\x00\x50\xF2\x01"
 )
 
+// EventScanResult.AuthMode bits.
+// Reference: cyw43_ll_wifi_parse_scan_result
+const (
+	SCAN_AUTH_WEP  = 1 << 0 // Privacy capability bit set without WPA/RSN IEs.
+	SCAN_AUTH_WPA  = 1 << 1 // WPA vendor specific IE present.
+	SCAN_AUTH_WPA2 = 1 << 2 // RSN IE present with non-SAE key management.
+	SCAN_AUTH_SAE  = 1 << 3 // RSN IE advertises SAE key management (WPA3).
+)
+
+// Escan (enhanced scan) parameters for the "escan" iovar.
+const (
+	ESCAN_REQ_VERSION    = 1
+	WL_SCAN_ACTION_START = 1
+	WL_SCAN_ACTION_ABORT = 3
+	WL_SCAN_TYPE_ACTIVE  = 0
+	WL_SCAN_TYPE_PASSIVE = 1
+	WL_BSS_TYPE_ANY      = 2
+	SCAN_MAX_CHANNELS    = 14 // 2.4GHz channels 1..14.
+)
+
+// Chanspec encoding (d11ac format) used by the firmware to specify channels.
+const (
+	WL_CHANSPEC_CHAN_MASK   = 0x00ff
+	WL_CHANSPEC_BW_MASK     = 0x3800
+	WL_CHANSPEC_BW_20       = 0x1000
+	WL_CHANSPEC_CTL_SB_NONE = 0x0000
+	WL_CHANSPEC_BAND_2G     = 0x0000
+)
+
+// ChanSpec20 returns the 20MHz 2.4GHz band chanspec for the given channel.
+func ChanSpec20(channel uint8) uint16 {
+	return uint16(channel) | WL_CHANSPEC_BW_20 | WL_CHANSPEC_CTL_SB_NONE | WL_CHANSPEC_BAND_2G
+}
+
 // const SLEEP_MAX (50)
 
 // Multicast registered group addresses
@@ -567,9 +616,9 @@ const (
 // WPA Authentication modes for WLC_SET_WPA_AUTH ioctl.
 // Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/consts.rs#L732-L735
 const (
-	WPA_AUTH_DISABLED    uint32 = 0x0000
-	WPA_AUTH_WPA_PSK     uint32 = 0x0004
-	WPA_AUTH_WPA2_PSK    uint32 = 0x0080
+	WPA_AUTH_DISABLED     uint32 = 0x0000
+	WPA_AUTH_WPA_PSK      uint32 = 0x0004
+	WPA_AUTH_WPA2_PSK     uint32 = 0x0080
 	WPA_AUTH_WPA3_SAE_PSK uint32 = 0x40000
 )
 
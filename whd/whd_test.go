@@ -27,3 +27,132 @@ func TestParseAsyncEvent(t *testing.T) {
 		t.Error("bad reason")
 	}
 }
+
+func TestParseScanResult(t *testing.T) {
+	const ssid = "pico-net"
+	// RSN IE advertising WPA2-PSK and SAE (WPA3 transition mode).
+	rsn := []byte{DOT11_IE_ID_RSN, 24,
+		1, 0, // Version.
+		0x00, 0x0f, 0xac, 4, // Group cipher CCMP.
+		1, 0, 0x00, 0x0f, 0xac, 4, // One pairwise cipher CCMP.
+		2, 0, 0x00, 0x0f, 0xac, 2, 0x00, 0x0f, 0xac, 8, // Two AKMs: PSK, SAE.
+		0, 0, // Capabilities.
+	}
+	var buf [escanResultHeaderLen + bssiSize + 32]byte
+	bss := buf[escanResultHeaderLen:]
+	order := binary.LittleEndian
+	order.PutUint32(bss[bssiLength:], uint32(bssiSize+len(rsn)))
+	copy(bss[bssiBSSID:], []byte{1, 2, 3, 4, 5, 6})
+	order.PutUint16(bss[bssiCapability:], DOT11_CAP_PRIVACY)
+	bss[bssiSSIDLength] = byte(len(ssid))
+	copy(bss[bssiSSID:], ssid)
+	order.PutUint16(bss[bssiChanSpec:], ChanSpec20(11))
+	var rssi int16 = -54
+	order.PutUint16(bss[bssiRSSI:], uint16(rssi))
+	order.PutUint16(bss[bssiIEOffset:], bssiSize)
+	order.PutUint32(bss[bssiIELength:], uint32(len(rsn)))
+	copy(bss[bssiSize:], rsn)
+
+	sr, err := ParseScanResult(order, buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.BSSID != [6]byte{1, 2, 3, 4, 5, 6} {
+		t.Error("bad bssid", sr.BSSID)
+	}
+	if string(sr.SSID[:sr.SSIDLength]) != ssid {
+		t.Error("bad ssid", string(sr.SSID[:sr.SSIDLength]))
+	}
+	if sr.Channel != 11 {
+		t.Error("bad channel", sr.Channel)
+	}
+	if sr.RSSI != rssi {
+		t.Error("bad rssi", sr.RSSI)
+	}
+	if sr.AuthMode != SCAN_AUTH_WPA2|SCAN_AUTH_SAE {
+		t.Error("bad auth mode", sr.AuthMode)
+	}
+
+	// IEs that extend past the BSS length must be rejected.
+	order.PutUint32(bss[bssiLength:], bssiSize)
+	_, err = ParseScanResult(order, buf[:])
+	if err == nil {
+		t.Error("expected error for IE past BSS end")
+	}
+	// IE length wrapping around the offset must be rejected.
+	order.PutUint32(bss[bssiLength:], uint32(bssiSize+len(rsn)))
+	order.PutUint32(bss[bssiIELength:], 1<<32-bssiSize) // Offset+length wraps to zero.
+	_, err = ParseScanResult(order, buf[:])
+	if err == nil {
+		t.Error("expected error for wrapping IE length")
+	}
+
+	// Truncated RSN element on a private network is still WPA2, not WEP.
+	order.PutUint32(bss[bssiIELength:], 4)
+	bss[bssiSize+1] = 2
+	sr, err = ParseScanResult(order, buf[:])
+	if err != nil {
+		t.Fatal(err)
+	} else if sr.AuthMode != SCAN_AUTH_WPA2 {
+		t.Error("bad auth mode for truncated RSN", sr.AuthMode)
+	}
+}
+
+func TestParseAsyncEventScanResult(t *testing.T) {
+	const ssid = "pico-net"
+	var buf [48 + escanResultHeaderLen + bssiSize]byte
+	// Event header is big endian, scan result is in device order.
+	binary.BigEndian.PutUint32(buf[4:], uint32(CYW43_EV_ESCAN_RESULT))
+	binary.BigEndian.PutUint32(buf[8:], CYW43_STATUS_PARTIAL)
+	bss := buf[48+escanResultHeaderLen:]
+	binary.LittleEndian.PutUint32(bss[bssiLength:], bssiSize)
+	bss[bssiSSIDLength] = byte(len(ssid))
+	copy(bss[bssiSSID:], ssid)
+	binary.LittleEndian.PutUint16(bss[bssiChanSpec:], ChanSpec20(6))
+	binary.LittleEndian.PutUint16(bss[bssiIEOffset:], bssiSize)
+
+	ev, err := ParseAsyncEvent(binary.BigEndian, buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := ev.EventScanResult()
+	if string(sr.SSID[:sr.SSIDLength]) != ssid {
+		t.Errorf("bad ssid %q", sr.SSID[:sr.SSIDLength])
+	}
+	if sr.Channel != 6 {
+		t.Error("bad channel", sr.Channel)
+	}
+}
+
+func TestRSNAuthMode(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		rsn  []byte
+		want uint8
+	}{
+		{
+			name: "psk",
+			rsn:  []byte{1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 2},
+			want: SCAN_AUTH_WPA2,
+		},
+		{
+			name: "sae",
+			rsn:  []byte{1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 8},
+			want: SCAN_AUTH_SAE,
+		},
+		{
+			name: "truncated",
+			rsn:  []byte{1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac},
+			want: SCAN_AUTH_WPA2,
+		},
+		{
+			name: "oversized pairwise count",
+			rsn:  []byte{1, 0, 0x00, 0x0f, 0xac, 4, 0xff, 0xff, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 2},
+			want: SCAN_AUTH_WPA2,
+		},
+	} {
+		if got := rsnAuthMode(test.rsn); got != test.want {
+			t.Errorf("%s: got auth mode %#x, want %#x", test.name, got, test.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ package cyw43439
 // https://github.com/embassy-rs/embassy/blob/26870082427b64d3ca42691c55a2cded5eadc548/cyw43/src/control.rs
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"net"
@@ -15,8 +16,23 @@ import (
 )
 
 var (
-	errJoinSetSSID = errors.New("join:SET_SSID failed")
-	errJoinGeneric = errors.New("join:failed")
+	errNotAssociated = errors.New("not associated")
+
+	errInvalidCountry     = errors.New("invalid country code")
+	errCountryUnsupported = errors.New("country not supported by CLM")
+)
+
+const (
+	// leaveTimeout is the maximum time Leave waits for the link down events.
+	leaveTimeout = time.Second
+	// defaultCountry is the worldwide regulatory domain.
+	defaultCountry = "XX"
+	// defaultJoinTimeout is the maximum time waited for a join to complete.
+	defaultJoinTimeout = 10 * time.Second
+	// upTimeout is the maximum time waited for the radio to come up after WLC_UP.
+	upTimeout = 200 * time.Millisecond
+	// countryTimeout is the maximum time waited for a country change to be applied.
+	countryTimeout = 200 * time.Millisecond
 )
 
 // JoinAuth specifies the authentication method for joining a WiFi network.
@@ -42,6 +58,26 @@ type JoinOptions struct {
 	CipherTKIP bool
 	// Passphrase is the WiFi password.
 	Passphrase string
+	// BSSID pins the join to the access point with this MAC address. Useful when several
+	// access points share an SSID. The zero value joins any access point with the SSID.
+	BSSID [6]byte
+	// Channels restricts the join scan to the listed 2.4GHz channels. Joining is
+	// considerably faster when the channel of the access point is known. Empty scans all channels.
+	Channels []uint8
+	// Hidden must be set to join networks that do not broadcast their SSID. The
+	// join scan then actively probes for the SSID.
+	Hidden bool
+	// Timeout is the maximum time the join may take. Zero selects [Config.JoinTimeout].
+	Timeout time.Duration
+	// Progress is called with every stage the join goes through. Optional.
+	// It is called with the device lock held so it must not call methods on the Device.
+	Progress func(JoinPhase)
+}
+
+// targeted returns true if the join is restricted by BSSID, channel or hidden SSID
+// and must use extended join parameters.
+func (opts *JoinOptions) targeted() bool {
+	return opts.BSSID != [6]byte{} || len(opts.Channels) > 0 || opts.Hidden
 }
 
 func (d *Device) clmLoad(clm string) error {
@@ -94,11 +130,16 @@ func (d *Device) initControl(clm string) error {
 			return errors.New("cyw bt init failed: " + err.Error())
 		}
 	}
-
+	start := time.Now()
 	err := d.clmLoad(clm)
 	if err != nil {
 		return err
 	}
+	d.timings.CLM = time.Since(start)
+	start = time.Now()
+	// Every ioctl below waits for the firmware's response so no waiting between them is needed.
+	// Independent iovars are not batched: the firmware serves a single outstanding CDC ioctl,
+	// as the reference drivers assume, and each one completes within a millisecond.
 	// Disable tx gloming which transfers multiple packets in one request.
 	// 'glom' is short for "conglomerate" which means "gather together into
 	// a compact mass".
@@ -110,79 +151,113 @@ func (d *Device) initControl(clm string) error {
 	d.get_iovar_n("cur_etheraddr", whd.IF_STA, d.mac[:6])
 	d.debug("MAC", slog.String("mac", d.hwaddr().String()))
 	if d.mode&modeWifi != 0 {
-		countryInfo := whd.CountryInfo("XX", 0)
-		d.set_iovar_n("country", whd.IF_STA, countryInfo[:])
-
-		// set country takes some time, next ioctls fail if we don't wait.
-		time.Sleep(100 * time.Millisecond)
+		if d.cfg.Country != "" {
+			err = d.setCountry(d.cfg.Country, d.cfg.CountryRev)
+			if err != nil {
+				return err
+			}
+		} else if err = d.setCountry(defaultCountry, 0); err != nil {
+			d.logerr("initControl:country", slog.String("err", err.Error()))
+		}
+		if err = d.ctxErr(); err != nil {
+			return err
+		}
 
 		// Set Antenna to chip antenna.
 		d.set_ioctl(whd.WLC_SET_ANTDIV, whd.IF_STA, 0)
-
+		// Disable tx glomming again after the country change as the reference drivers do.
 		d.set_iovar("bus:txglom", whd.IF_STA, 0)
-		time.Sleep(100 * time.Millisecond)
-
 		d.set_iovar("ampdu_ba_wsize", whd.IF_STA, 8)
-		time.Sleep(100 * time.Millisecond)
-
 		d.set_iovar("ampdu_mpdu", whd.IF_STA, 4)
-		time.Sleep(100 * time.Millisecond)
 
 		// Ignore uninteresting/spammy events.
-		var evts eventMask
-		for i := range evts.events {
-			evts.events[i] = 0xff
-		}
-		evts.Disable(whd.EvRADIO)
-		evts.Disable(whd.EvIF)
-		evts.Disable(whd.EvPROBREQ_MSG)
-		evts.Disable(whd.EvPROBREQ_MSG_RX)
-		evts.Disable(whd.EvPROBRESP_MSG)
-		evts.Disable(whd.EvROAM)
-		buf := make([]byte, evts.Size())
-		evts.Put(buf)
-		d.set_iovar_n("bsscfg:event_msgs", whd.IF_STA, buf)
-
-		time.Sleep(100 * time.Millisecond)
-
-		// Set wifi up.
-		d.doIoctlSet(whd.WLC_UP, whd.IF_STA, nil)
+		evts := defaultFirmwareEventMask()
+		d.setFirmwareEventMask(&evts)
 
-		time.Sleep(100 * time.Millisecond)
+		// Set wifi up and confirm the radio is up before configuring it.
+		d.doIoctlSet(whd.WLC_UP, whd.IF_STA, nil)
+		err = d.waitUp()
+		if err != nil {
+			return err
+		}
 
 		d.set_ioctl(whd.WLC_SET_GMODE, whd.IF_STA, 1) // Set GMODE=auto
 		d.set_ioctl(whd.WLC_SET_BAND, whd.IF_STA, 0)  // Set BAND=any
-
-		time.Sleep(100 * time.Millisecond)
 	}
 	if modeBluetooth&d.mode != 0 {
 		// TODO: flash bt firmware here?
 	}
+	d.timings.Control = time.Since(start)
 	return nil
 }
 
+// waitUp polls the firmware until it reports the radio is up after WLC_UP.
+func (d *Device) waitUp() error {
+	deadline := time.Now().Add(upTimeout)
+	for {
+		up, err := d.get_ioctl(whd.WLC_GET_UP, whd.IF_STA)
+		if err == nil && up != 0 {
+			return nil
+		} else if time.Since(deadline) >= 0 {
+			return errjoin(errors.New("timeout waiting for WLC_UP"), err)
+		}
+		if err = d.sleep(time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
 func (d *Device) hwaddr() net.HardwareAddr {
 	return net.HardwareAddr(d.mac[:6])
 }
 
-func (d *Device) set_power_management(mode powerManagementMode) error {
+// SetPowerManagement sets the power management mode of the device.
+// It can be changed at any time after [Device.Init].
+func (d *Device) SetPowerManagement(mode PowerManagementMode) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	return d.set_power_management(mode)
+}
+
+// SetPowerManagementParams sets a custom fast power save mode (PM2) with the given parameters.
+// Use [PowerManagementMode.Params] to start off of a preset.
+func (d *Device) SetPowerManagementParams(params PowerManagementParams) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	return d.set_power_management_params(params)
+}
+
+func (d *Device) set_power_management(mode PowerManagementMode) error {
 	d.debug("set_power_management", slog.String("mode", mode.String()))
 	if !mode.IsValid() {
 		return errors.New("invalid power management mode")
 	}
 	mode_num := mode.mode()
 	if mode_num == 2 {
-		d.set_iovar("pm2_sleep_ret", whd.IF_STA, uint32(mode.sleep_ret_ms()))
-		d.set_iovar("bcn_li_bcn", whd.IF_STA, uint32(mode.beacon_period()))
-		d.set_iovar("bcn_li_dtim", whd.IF_STA, uint32(mode.dtim_period()))
-		d.set_iovar("assoc_listen", whd.IF_STA, uint32(mode.assoc()))
+		return d.set_power_management_params(mode.Params())
 	}
 	return d.set_ioctl(whd.WLC_SET_PM, whd.IF_STA, uint32(mode_num))
 }
 
+func (d *Device) set_power_management_params(params PowerManagementParams) error {
+	d.debug("set_power_management_params", slog.Int("sleep_ret", int(params.SleepRetMs)), slog.Int("bcn", int(params.BeaconPeriod)),
+		slog.Int("dtim", int(params.DTIMPeriod)), slog.Int("assoc", int(params.AssocListen)))
+	d.set_iovar("pm2_sleep_ret", whd.IF_STA, uint32(params.SleepRetMs))
+	d.set_iovar("bcn_li_bcn", whd.IF_STA, uint32(params.BeaconPeriod))
+	d.set_iovar("bcn_li_dtim", whd.IF_STA, uint32(params.DTIMPeriod))
+	d.set_iovar("assoc_listen", whd.IF_STA, uint32(params.AssocListen))
+	return d.set_ioctl(whd.WLC_SET_PM, whd.IF_STA, 2)
+}
+
 // join_open connects to an open (unencrypted) WiFi network.
 // Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs#L316-L321
-func (d *Device) join_open(ssid string) error {
+func (d *Device) join_open(ssid string, options *JoinOptions) error {
 	d.debug("join_open", slog.String("ssid", ssid))
 	if len(ssid) > 32 {
 		return errors.New("ssid too long")
@@ -194,58 +269,73 @@ func (d *Device) join_open(ssid string) error {
 	d.set_ioctl(whd.WLC_SET_AUTH, whd.IF_STA, 0)
 	d.set_ioctl(whd.WLC_SET_WPA_AUTH, whd.IF_STA, whd.WPA_AUTH_DISABLED)
 
-	return d.wait_for_join(ssid, false) // open network
+	return d.wait_for_join(ssid, options, false) // open network
 }
 
 // wait_for_join waits for the join operation to complete.
 // For open networks (secureNetwork=false), success is indicated by SET_SSID with status=0.
 // For secure networks (secureNetwork=true), success is indicated by PSK_SUP with status=6 (KEYED).
+// On failure a [JoinError] describing the cause is returned.
 // Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs#L389-L440
-func (d *Device) wait_for_join(ssid string, secureNetwork bool) (err error) {
+func (d *Device) wait_for_join(ssid string, options *JoinOptions, secureNetwork bool) (err error) {
 	d.secureNetwork = secureNetwork
 	// Reset flags for new join attempt. ref: runner.rs:120-122
 	d.authOK = false
 	d.joinOK = false
 	d.keyExchangeOK = false
 
-	d.eventmask.Enable(whd.EvSET_SSID)
-	d.eventmask.Enable(whd.EvAUTH)
-	d.eventmask.Enable(whd.EvJOIN)
+	joinEvents := [...]whd.AsyncEventType{whd.EvSET_SSID, whd.EvAUTH, whd.EvJOIN, whd.EvASSOC, whd.EvREASSOC}
+	for _, ev := range joinEvents {
+		d.eventmask.Enable(ev)
+	}
 	if secureNetwork {
 		d.eventmask.Enable(whd.EvPSK_SUP)
 	}
-
-	err = d.setSSID(ssid)
+	// Association events are only needed to report progress and failures.
+	defer d.eventmask.Disable(whd.EvASSOC)
+	defer d.eventmask.Disable(whd.EvREASSOC)
+	d.joinst.start(secureNetwork, options.Progress)
+	defer func() { d.joinst.active = false }()
+
+	if options.targeted() {
+		err = d.joinExtended(ssid, options)
+	} else {
+		err = d.setSSID(ssid)
+	}
 	if err != nil {
 		return err
 	}
 	// Poll for async events.
-	deadline := time.Now().Add(10 * time.Second)
-	keepGoing := true
-	for keepGoing {
-		time.Sleep(270 * time.Millisecond)
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = d.cfg.JoinTimeout
+	}
+	if timeout <= 0 {
+		timeout = defaultJoinTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for d.state == linkStateDown && !d.joinst.terminal && time.Until(deadline) > 0 {
+		if err = d.sleep(10 * time.Millisecond); err != nil {
+			// Abort the join in progress.
+			d.logerr("join:abort", slog.String("err", err.Error()))
+			d.doIoctlSet(whd.WLC_DISASSOC, whd.IF_STA, nil)
+			d.state = linkStateDown
+			return err
+		}
 		err = d.check_status(d._sendIoctlBuf[:])
 		if err != nil {
 			return err
 		}
-		// Keep trying while state is still Down (waiting for events).
-		keepGoing = d.state == linkStateDown && time.Until(deadline) > 0
 	}
-	switch d.state {
-	case linkStateUp:
+	if d.state == linkStateUp {
 		// Begin listening in for link change/down events.
 		d.eventmask.Enable(whd.EvLINK)
 		d.eventmask.Enable(whd.EvDISASSOC)
 		d.eventmask.Enable(whd.EvDEAUTH)
-
-	case linkStateFailed:
-		err = errJoinSetSSID
-	default:
-		if d.state == linkStateDown {
-			err = errJoinGeneric // Timed out without resolving.
-		} else {
-			err = errJoinGeneric
-		}
+	}
+	err = d.joinst.result(d.state)
+	if err != nil {
+		d.logerr("join:fail", slog.String("ssid", ssid), slog.String("err", err.Error()))
 	}
 	return err
 }
@@ -262,7 +352,7 @@ func (p *passphraseInfo) Put(order binary.ByteOrder, b []byte) {
 	copy(b[4:68], p.passphrase[:])
 }
 
-func (d *Device) setPassphrase(pass string) error {
+func (d *Device) setPassphrase(pass string, iface whd.IoctlInterface) error {
 	if len(pass) > 64 {
 		return errors.New("passphrase too long")
 	}
@@ -276,12 +366,12 @@ func (d *Device) setPassphrase(pass string) error {
 	var buf [68]byte
 	pfi.Put(_busOrder, buf[:])
 
-	return d.doIoctlSet(whd.WLC_SET_WSEC_PMK, whd.IF_STA, buf[:])
+	return d.doIoctlSet(whd.WLC_SET_WSEC_PMK, iface, buf[:])
 }
 
 // setSaePassword sets the SAE (WPA3) password via "sae_password" iovar.
 // Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs#L362-L370
-func (d *Device) setSaePassword(pass string) error {
+func (d *Device) setSaePassword(pass string, iface whd.IoctlInterface) error {
 	if len(pass) > 128 {
 		return errors.New("sae password too long")
 	}
@@ -290,7 +380,7 @@ func (d *Device) setSaePassword(pass string) error {
 	_busOrder.PutUint16(buf[0:2], uint16(len(pass)))
 	copy(buf[2:], pass)
 	// Send full 130-byte struct to match embassy-rs. ref: control.rs:362-369, structs.rs:400-404
-	return d.set_iovar_n("sae_password", whd.IF_STA, buf[:])
+	return d.set_iovar_n("sae_password", iface, buf[:])
 }
 
 type ssidInfo struct {
@@ -321,6 +411,59 @@ func (d *Device) setSSID(ssid string) error {
 	return d.doIoctlSet(whd.WLC_SET_SSID, whd.IF_STA, buf[:])
 }
 
+// joinExtended starts the connect procedure through the "join" iovar with
+// wl_extjoin_params_t, which restricts the join scan to a BSSID and channel list.
+// Reference: _legacy_cyrw/cyw43439_wifi.go wifiJoin.
+func (d *Device) joinExtended(ssid string, options *JoinOptions) error {
+	if len(ssid) > 32 {
+		return errors.New("ssid too long")
+	} else if len(options.Channels) > whd.SCAN_MAX_CHANNELS {
+		return errScanChannel
+	}
+	const (
+		scanParamsOff  = 36                 // After wlc_ssid_t.
+		assocParamsOff = scanParamsOff + 20 // After wl_join_scan_params_t.
+		chanspecOff    = assocParamsOff + 12
+		negative1      = 0xffff_ffff // Firmware default.
+	)
+	var buf [chanspecOff + 2*whd.SCAN_MAX_CHANNELS]byte
+	info := ssidInfo{length: uint32(len(ssid))}
+	copy(info.ssid[:], ssid)
+	info.put(_busOrder, buf[:scanParamsOff])
+
+	// wl_join_scan_params_t: scan type, nprobes, active, passive and home time.
+	buf[scanParamsOff] = whd.WL_SCAN_TYPE_ACTIVE
+	var nprobes uint32 = negative1
+	if options.Hidden {
+		nprobes = 2 // Send directed probe requests for the hidden SSID.
+	}
+	_busOrder.PutUint32(buf[scanParamsOff+4:], nprobes)
+	_busOrder.PutUint32(buf[scanParamsOff+8:], negative1)
+	_busOrder.PutUint32(buf[scanParamsOff+12:], negative1)
+	_busOrder.PutUint32(buf[scanParamsOff+16:], negative1)
+
+	// wl_join_assoc_params_t: BSSID, BSSID count, chanspec count and list.
+	bssid := options.BSSID
+	if bssid == [6]byte{} {
+		bssid = [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff} // Any BSSID.
+	}
+	copy(buf[assocParamsOff:], bssid[:])
+	for i, ch := range options.Channels {
+		if ch == 0 || ch > maxChannel2G {
+			return errScanChannel
+		}
+		_busOrder.PutUint16(buf[chanspecOff+2*i:], whd.ChanSpec20(ch))
+	}
+	_busOrder.PutUint32(buf[assocParamsOff+8:], uint32(len(options.Channels)))
+	n := chanspecOff + 2*len(options.Channels)
+	if len(options.Channels) == 0 {
+		n += 2 // chanspec_list is a one element array in the firmware struct.
+	}
+	d.debug("joinExtended", slog.String("bssid", net.HardwareAddr(bssid[:]).String()), slog.Int("nch", len(options.Channels)), slog.Bool("hidden", options.Hidden))
+	d.state = linkStateDown
+	return d.set_iovar_n("join", whd.IF_STA, buf[:n])
+}
+
 type ssidInfoWithIndex struct {
 	index uint32
 	info  ssidInfo
@@ -337,6 +480,7 @@ func (d *Device) setSSIDWithIndex(ssid string, index uint32) error {
 	}
 
 	var infoIndex = ssidInfoWithIndex{
+		index: index,
 		info: ssidInfo{
 			length: uint32(len(ssid)),
 		},
@@ -354,17 +498,126 @@ func (d *Device) IsLinkUp() bool {
 	return d.state == linkStateUp
 }
 
+// LinkInfo describes the access point the device is associated with and the link quality.
+type LinkInfo struct {
+	// BSSID is the MAC address of the access point.
+	BSSID [6]byte
+	// Channel is the channel the device operates on.
+	Channel uint8
+	// RSSI is the received signal strength of the access point in dBm.
+	RSSI int16
+	// Noise is the noise floor in dBm.
+	Noise int16
+	// TxRate is the current transmit PHY rate in kbps.
+	TxRate  uint32
+	ssidLen uint8
+	ssid    [32]byte
+}
+
+// SSID returns the network name of the associated access point.
+func (li *LinkInfo) SSID() string { return string(li.ssid[:li.ssidLen]) }
+
+// LinkInfo returns information on the access point the device is associated
+// with in station mode and the current link quality. Returns an error if not associated.
+func (d *Device) LinkInfo() (LinkInfo, error) {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return LinkInfo{}, err
+	}
+	return d.linkInfo()
+}
+
+func (d *Device) linkInfo() (li LinkInfo, err error) {
+	if d.state != linkStateUp {
+		return li, errNotAssociated
+	}
+	var buf [36]byte
+	// wlc_ssid_t: uint32 length followed by 32 byte SSID.
+	_, err = d.doIoctlGet(whd.WLC_GET_SSID, whd.IF_STA, buf[:36])
+	if err != nil {
+		return li, err
+	}
+	ssidLen := _busOrder.Uint32(buf[:4])
+	if ssidLen > 32 {
+		return li, errors.New("invalid ssid length")
+	}
+	li.ssidLen = uint8(ssidLen)
+	copy(li.ssid[:], buf[4:4+ssidLen])
+
+	clear(buf[:])
+	_, err = d.doIoctlGet(whd.WLC_GET_BSSID, whd.IF_STA, buf[:6])
+	if err != nil {
+		return li, err
+	}
+	copy(li.BSSID[:], buf[:6])
+	if li.BSSID == [6]byte{} {
+		return li, errNotAssociated
+	}
+
+	li.Channel, err = d.staChannel()
+	if err != nil {
+		return li, err
+	}
+
+	// scb_val_t: int32 value followed by station address. Zero address selects the associated AP.
+	clear(buf[:])
+	_, err = d.doIoctlGet(whd.WLC_GET_RSSI, whd.IF_STA, buf[:12])
+	if err != nil {
+		return li, err
+	}
+	li.RSSI = int16(int32(_busOrder.Uint32(buf[:4])))
+
+	noise, err := d.get_ioctl(whd.WLC_GET_PHY_NOISE, whd.IF_STA)
+	if err != nil {
+		return li, err
+	}
+	li.Noise = int16(int32(noise))
+
+	rate, err := d.get_ioctl(whd.WLC_GET_RATE, whd.IF_STA)
+	if err != nil {
+		return li, err
+	}
+	li.TxRate = rate * 500 // Rate is reported in 500kbps units.
+	return li, nil
+}
+
+// staChannel returns the channel the station interface operates on.
+func (d *Device) staChannel() (uint8, error) {
+	// channel_info_t: hw_channel, target_channel, scan_channel.
+	var buf [12]byte
+	_, err := d.doIoctlGet(whd.WLC_GET_CHANNEL, whd.IF_STA, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return uint8(_busOrder.Uint32(buf[:4])), nil
+}
+
 // Join connects to a WiFi network using the specified options.
 // For WPA2/WPA3 networks, provide a passphrase in options.
 // For open networks, use JoinAuth=JoinAuthOpen with empty passphrase.
 //
 // Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs see `pub async fn join`
 func (d *Device) Join(ssid string, options JoinOptions) error {
+	return d.JoinContext(context.Background(), ssid, options)
+}
+
+// JoinContext is like [Device.Join] but aborts the join and returns the context's
+// error if ctx is canceled or its deadline is exceeded before the join completes.
+func (d *Device) JoinContext(ctx context.Context, ssid string, options JoinOptions) error {
 	err := d.acquire(modeWifi)
 	defer d.release()
 	if err != nil {
 		return err
 	}
+	d.ctx = ctx
+	defer d.clearContext()
+	// Remember join parameters for the reconnect supervisor.
+	d.reconn.remember(ssid, options)
+	return d.join(ssid, options)
+}
+
+func (d *Device) join(ssid string, options JoinOptions) error {
 	if options.Auth == joinAuthUndefined || options.Auth > JoinAuthWPA2WPA3 {
 		options.Auth = JoinAuthOpen
 		if options.Passphrase != "" {
@@ -372,7 +625,7 @@ func (d *Device) Join(ssid string, options JoinOptions) error {
 		}
 	}
 	if options.Auth == JoinAuthOpen {
-		return d.join_open(ssid)
+		return d.join_open(ssid, &options)
 	}
 	d.info("join", slog.String("ssid", ssid), slog.Int("auth", int(options.Auth)), slog.Int("passlen", len(options.Passphrase)))
 	if err := d.set_iovar("ampdu_ba_wsize", whd.IF_STA, 8); err != nil {
@@ -400,13 +653,15 @@ func (d *Device) Join(ssid string, options JoinOptions) error {
 		return err
 	}
 
-	time.Sleep(100 * time.Millisecond)
+	if err := d.sleep(100 * time.Millisecond); err != nil {
+		return err
+	}
 
 	// Set passphrase for WPA/WPA2.
 	// Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs#L346-L360
 	if options.Auth == JoinAuthWPA || options.Auth == JoinAuthWPA2 || options.Auth == JoinAuthWPA2WPA3 {
 		time.Sleep(3 * time.Millisecond) // Embassy: Timer::after_millis(3)
-		if err := d.setPassphrase(options.Passphrase); err != nil {
+		if err := d.setPassphrase(options.Passphrase, whd.IF_STA); err != nil {
 			return err
 		}
 	}
@@ -415,7 +670,7 @@ func (d *Device) Join(ssid string, options JoinOptions) error {
 	// Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs#L362-L370
 	if options.Auth == JoinAuthWPA3 || options.Auth == JoinAuthWPA2WPA3 {
 		time.Sleep(3 * time.Millisecond) // Embassy: Timer::after_millis(3)
-		if err := d.setSaePassword(options.Passphrase); err != nil {
+		if err := d.setSaePassword(options.Passphrase, whd.IF_STA); err != nil {
 			return err
 		}
 	}
@@ -462,7 +717,59 @@ func (d *Device) Join(ssid string, options JoinOptions) error {
 		return err
 	}
 
-	return d.wait_for_join(ssid, true) // secure network
+	return d.wait_for_join(ssid, &options, true) // secure network
+}
+
+// Leave disassociates the device from the network joined with [Device.Join]
+// and waits for the firmware to report the link down. After Leave returns
+// the device is ready to join another network.
+//
+// Reference: https://github.com/embassy-rs/embassy/blob/main/cyw43/src/control.rs see `pub async fn leave`
+func (d *Device) Leave() error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	return d.leave()
+}
+
+func (d *Device) leave() error {
+	d.info("leave", slog.Bool("linkup", d.state == linkStateUp))
+	wasUp := d.state == linkStateUp
+	err := d.doIoctlSet(whd.WLC_DISASSOC, whd.IF_STA, nil)
+	if err != nil {
+		return err
+	}
+	var waitErr error
+	if wasUp {
+		// DISASSOC and LINK events are enabled while the link is up; they bring link state down.
+		deadline := time.Now().Add(leaveTimeout)
+		for d.state == linkStateUp && time.Until(deadline) > 0 {
+			if waitErr = d.sleep(10 * time.Millisecond); waitErr != nil {
+				break // Disassociation was requested, bring link state down regardless.
+			}
+			err = d.check_status(d._sendIoctlBuf[:])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	prevState := d.state
+	d.authOK = false
+	d.joinOK = false
+	d.keyExchangeOK = false
+	d.state = linkStateDown
+	if prevState == linkStateUp {
+		d.warn("leave:no link down event")
+		d.notifyLinkState(prevState, &whd.EventMessage{}, nil)
+	}
+	d.reconn.forget()
+	// Stop listening for link change/down events enabled on join.
+	d.eventmask.Disable(whd.EvLINK)
+	d.eventmask.Disable(whd.EvDISASSOC)
+	d.eventmask.Disable(whd.EvDEAUTH)
+	return waitErr
 }
 
 // JoinWPA2 connects to a WPA2 WiFi network. If pass is empty, connects to an open network.
@@ -472,82 +779,404 @@ func (d *Device) JoinWPA2(ssid, pass string) error {
 	return d.Join(ssid, JoinOptions{Passphrase: pass})
 }
 
+// StartAP starts an access point on the given channel. If pass is empty the
+// network is open, otherwise WPA/WPA2 with AES is used.
+// Use [Device.StartAPWithOptions] for more control over the access point.
 func (d *Device) StartAP(ssid, pass string, channel uint8) error {
+	opts := APOptions{Passphrase: pass, Channel: channel}
+	if pass != "" {
+		opts.Auth = JoinAuthWPA // WPA/WPA2 mixed mode.
+	}
+	return d.StartAPWithOptions(ssid, opts)
+}
+
+// APOptions configures an access point started with [Device.StartAPWithOptions].
+type APOptions struct {
+	// Auth is the security of the access point. Implementation will choose WPA2 if
+	// passphrase is set or Open if no passphrase is set. [JoinAuthWPA] selects WPA/WPA2 mixed mode
+	// and [JoinAuthWPA2WPA3] selects WPA3 transition mode.
+	Auth JoinAuth
+	// Passphrase is the network password. Must be 8 to 63 characters long for WPA/WPA2.
+	Passphrase string
+	// Channel is the 2.4GHz channel the access point operates on. Zero selects channel 3.
+	Channel uint8
+	// Hidden disables SSID broadcast in beacons. Stations must know the SSID to join.
+	Hidden bool
+	// BeaconPeriod is the beacon interval in time units (1.024ms). Zero selects the firmware default of 100.
+	BeaconPeriod uint16
+	// DTIMPeriod is the amount of beacon intervals between delivery of buffered broadcast traffic.
+	// Zero selects the firmware default.
+	DTIMPeriod uint8
+	// MaxAssociations limits the amount of associated stations. Zero selects the firmware default.
+	MaxAssociations uint8
+	// MulticastRate is the rate used for multicast and broadcast frames in kbps.
+	// Must be a multiple of 500. Zero selects 11Mbps.
+	MulticastRate uint32
+	// Concurrent starts the access point alongside station mode (AP+STA) on the
+	// [whd.IF_AP] interface. The station interface keeps operating and may join networks.
+	// Use [Device.SendEthIface] and [Device.RecvEthHandleIface] with [whd.IF_AP] for AP traffic.
+	// The radio is shared so the access point follows the station's channel.
+	Concurrent bool
+}
+
+// StartAPWithOptions starts an access point with the given options.
+// Use [Device.StopAP] to tear the access point down.
+func (d *Device) StartAPWithOptions(ssid string, opts APOptions) error {
+	return d.StartAPContext(context.Background(), ssid, opts)
+}
+
+// StartAPContext is like [Device.StartAPWithOptions] but returns the context's error
+// if ctx is canceled or its deadline is exceeded before the access point is started.
+func (d *Device) StartAPContext(ctx context.Context, ssid string, opts APOptions) error {
 	err := d.acquire(modeWifi)
 	defer d.release()
 	if err != nil {
 		return err
 	}
+	d.ctx = ctx
+	defer d.clearContext()
+	return d.startAP(ssid, opts)
+}
 
-	security := whd.CYW43_AUTH_OPEN
-	if pass != "" {
-		if len(pass) < whd.CYW43_MIN_PSK_LEN || len(pass) > whd.CYW43_MAX_PSK_LEN {
-			return errors.New("Passphrase is too short or too long")
-		}
-		security = whd.CYW43_AUTH_WPA2_AES_PSK
-	}
-
-	// Temporarily set wifi down
-	if err := d.doIoctlSet(whd.WLC_DOWN, whd.IF_STA, nil); err != nil {
+// StopAP stops the access point and returns the device to station mode.
+func (d *Device) StopAP() error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
 		return err
 	}
+	if !d.apUp {
+		return errAPDown
+	}
+	return d.stopAP()
+}
 
-	// Turn off APSTA mode
-	if err := d.set_iovar("apsta", whd.IF_STA, 0); err != nil {
-		return err
+func (d *Device) startAP(ssid string, opts APOptions) error {
+	if len(ssid) > 32 {
+		return errors.New("ssid too long")
+	}
+	if opts.Auth == joinAuthUndefined || opts.Auth > JoinAuthWPA2WPA3 {
+		opts.Auth = JoinAuthOpen
+		if opts.Passphrase != "" {
+			opts.Auth = JoinAuthWPA2
+		}
+	}
+	if opts.Channel == 0 {
+		opts.Channel = defaultAPChannel
+	}
+	if opts.MulticastRate == 0 {
+		opts.MulticastRate = 11000
+	} else if opts.MulticastRate%500 != 0 {
+		return errors.New("multicast rate not multiple of 500kbps")
+	}
+	if opts.Auth == JoinAuthOpen && opts.Passphrase != "" {
+		return errors.New("passphrase set for open network")
+	} else if opts.Auth != JoinAuthOpen && opts.Auth != JoinAuthWPA3 &&
+		(len(opts.Passphrase) < whd.CYW43_MIN_PSK_LEN || len(opts.Passphrase) > whd.CYW43_MAX_PSK_LEN) {
+		return errors.New("Passphrase is too short or too long")
+	} else if opts.Auth == JoinAuthWPA3 && opts.Passphrase == "" {
+		return errors.New("empty sae password")
 	}
+	d.info("startAP", slog.String("ssid", ssid), slog.Int("auth", int(opts.Auth)), slog.Int("channel", int(opts.Channel)),
+		slog.Bool("hidden", opts.Hidden), slog.Bool("concurrent", opts.Concurrent))
+
+	// In concurrent mode the AP is a second BSS (bsscfg 1) on the AP interface
+	// while the station keeps operating on bsscfg 0. Reference: pico-sdk cyw43_ll_wifi_ap_init.
+	iface := whd.IF_STA
+	var bsscfg uint32
+	if opts.Concurrent {
+		iface = whd.IF_AP
+		bsscfg = 1
+		if d.state == linkStateUp {
+			// Radio is shared, AP must operate on the station's channel.
+			ch, err := d.staChannel()
+			if err != nil {
+				return err
+			}
+			opts.Channel = ch
+		}
+	} else {
+		// Temporarily set wifi down
+		if err := d.doIoctlSet(whd.WLC_DOWN, whd.IF_STA, nil); err != nil {
+			return err
+		}
 
-	// Set wifi up again
-	if err := d.doIoctlSet(whd.WLC_UP, whd.IF_STA, nil); err != nil {
-		return err
+		// Turn off APSTA mode
+		if err := d.set_iovar("apsta", whd.IF_STA, 0); err != nil {
+			return err
+		}
+
+		// Set wifi up again
+		if err := d.doIoctlSet(whd.WLC_UP, whd.IF_STA, nil); err != nil {
+			return err
+		}
+
+		// Turn on AP mode
+		if err := d.set_ioctl(whd.WLC_SET_AP, whd.IF_STA, 1); err != nil {
+			return err
+		}
 	}
 
-	// Turn on AP mode
-	if err := d.set_ioctl(whd.WLC_SET_AP, whd.IF_STA, 1); err != nil {
+	// Set SSID
+	if err := d.setSSIDWithIndex(ssid, bsscfg); err != nil {
 		return err
 	}
 
-	// Set SSID
-	if err := d.setSSIDWithIndex(ssid, 0); err != nil {
+	// Hide SSID from beacons.
+	if err := d.set_iovar2("bsscfg:closednet", whd.IF_STA, bsscfg, b2u32(opts.Hidden)); err != nil {
 		return err
 	}
 
 	// Set channel number
-	if err := d.set_ioctl(whd.WLC_SET_CHANNEL, whd.IF_STA, uint32(channel)); err != nil {
+	if err := d.set_ioctl(whd.WLC_SET_CHANNEL, whd.IF_STA, uint32(opts.Channel)); err != nil {
 		return err
 	}
 
+	if opts.BeaconPeriod != 0 {
+		if err := d.set_ioctl(whd.WLC_SET_BCNPRD, iface, uint32(opts.BeaconPeriod)); err != nil {
+			return err
+		}
+	}
+	if opts.DTIMPeriod != 0 {
+		if err := d.set_ioctl(whd.WLC_SET_DTIMPRD, iface, uint32(opts.DTIMPeriod)); err != nil {
+			return err
+		}
+	}
+	if opts.MaxAssociations != 0 {
+		if err := d.set_iovar("maxassoc", iface, uint32(opts.MaxAssociations)); err != nil {
+			return err
+		}
+	}
+
 	// Set security
-	if err := d.set_iovar2("bsscfg:wsec", whd.IF_STA, 0, uint32(security)&0xff); err != nil {
+	var wsec, wpaAuth uint32
+	mfp := whd.MFP_NONE
+	switch opts.Auth {
+	case JoinAuthWPA:
+		wsec, wpaAuth = whd.WSEC_AES, whd.WPA_AUTH_WPA_PSK|whd.WPA_AUTH_WPA2_PSK
+	case JoinAuthWPA2:
+		wsec, wpaAuth = whd.WSEC_AES, whd.WPA_AUTH_WPA2_PSK
+	case JoinAuthWPA2WPA3:
+		wsec, wpaAuth, mfp = whd.WSEC_AES, whd.WPA_AUTH_WPA2_PSK|whd.WPA_AUTH_WPA3_SAE_PSK, whd.MFP_CAPABLE
+	case JoinAuthWPA3:
+		wsec, wpaAuth, mfp = whd.WSEC_AES, whd.WPA_AUTH_WPA3_SAE_PSK, whd.MFP_REQUIRED
+	}
+	if err := d.set_iovar2("bsscfg:wsec", whd.IF_STA, bsscfg, wsec); err != nil {
 		return err
 	}
 
-	if security != whd.CYW43_AUTH_OPEN {
-		// wpa_auth = WPA2_AUTH_PSK | WPA_AUTH_PSK
-		if err := d.set_iovar2("bsscfg:wpa_auth", whd.IF_STA, 0,
-			whd.CYW43_WPA_AUTH_PSK|whd.CYW43_WPA2_AUTH_PSK); err != nil {
+	if opts.Auth != JoinAuthOpen {
+		if err := d.set_iovar2("bsscfg:wpa_auth", whd.IF_STA, bsscfg, wpaAuth); err != nil {
 			return err
 		}
-		time.Sleep(100 * time.Millisecond)
-		// Set passphrase
-		if err := d.setPassphrase(pass); err != nil {
+		// Management frame protection is required by WPA3.
+		if err := d.set_iovar("mfp", iface, mfp); err != nil {
+			return err
+		}
+		if err := d.sleep(100 * time.Millisecond); err != nil {
 			return err
 		}
+		// Set passphrase
+		if opts.Auth != JoinAuthWPA3 {
+			if err := d.setPassphrase(opts.Passphrase, iface); err != nil {
+				return err
+			}
+		}
+		if opts.Auth == JoinAuthWPA3 || opts.Auth == JoinAuthWPA2WPA3 {
+			if err := d.setSaePassword(opts.Passphrase, iface); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Change mutlicast rate from 1 Mbps to 11 Mbps
-	if err := d.set_iovar("2g_mrate", whd.IF_STA, 11000000/500000); err != nil {
+	// Change mutlicast rate from default 1 Mbps.
+	if err := d.set_iovar("2g_mrate", iface, opts.MulticastRate/500); err != nil {
 		return err
 	}
 
 	// Start AP (bss = BSS_UP)
-	if err := d.set_iovar2("bss", whd.IF_STA, 0, 1); err != nil {
+	if err := d.set_iovar2("bss", whd.IF_STA, bsscfg, 1); err != nil {
 		return err
 	}
+	d.apUp = true
+	d.apConcurrent = opts.Concurrent
+	d.apChannel = opts.Channel
+	d.enableAPEvents(true)
+	if !opts.Concurrent {
+		// Link is up once the BSS is up, enables sending ethernet frames.
+		prevState := d.state
+		d.state = linkStateUp
+		d.notifyLinkState(prevState, &whd.EventMessage{}, nil)
+	}
+	return nil
+}
 
+// stopAP brings down the BSS started by startAP and returns the chip to station mode.
+func (d *Device) stopAP() error {
+	d.info("stopAP", slog.Bool("concurrent", d.apConcurrent))
+	if d.apConcurrent {
+		// Stop AP BSS, station keeps operating.
+		if err := d.set_iovar2("bss", whd.IF_STA, 1, 0); err != nil {
+			return err
+		}
+		d.apUp = false
+		d.apConcurrent = false
+		d.enableAPEvents(false)
+		return nil
+	}
+	// Stop AP (bss = BSS_DOWN)
+	if err := d.set_iovar2("bss", whd.IF_STA, 0, 0); err != nil {
+		return err
+	}
+	if err := d.doIoctlSet(whd.WLC_DOWN, whd.IF_STA, nil); err != nil {
+		return err
+	}
+	if err := d.set_ioctl(whd.WLC_SET_AP, whd.IF_STA, 0); err != nil {
+		return err
+	}
+	// Restore APSTA mode set in initControl.
+	if err := d.set_iovar("apsta", whd.IF_STA, 1); err != nil {
+		return err
+	}
+	if err := d.doIoctlSet(whd.WLC_UP, whd.IF_STA, nil); err != nil {
+		return err
+	}
+	d.apUp = false
+	d.enableAPEvents(false)
+	prevState := d.state
+	d.state = linkStateDown
+	d.notifyLinkState(prevState, &whd.EventMessage{}, nil)
 	return nil
 }
 
+// followSTAChannel moves the concurrent mode access point to the station's
+// channel. The radio is shared so both interfaces must operate on the same channel.
+func (d *Device) followSTAChannel() error {
+	d.apFollowPending = false
+	if !d.apUp || !d.apConcurrent || d.state != linkStateUp {
+		return nil
+	}
+	ch, err := d.staChannel()
+	if err != nil {
+		return err
+	}
+	if ch == d.apChannel {
+		return nil
+	}
+	d.info("ap:follow-channel", slog.Int("from", int(d.apChannel)), slog.Int("to", int(ch)))
+	// Restart AP BSS on the new channel.
+	if err := d.set_iovar2("bss", whd.IF_STA, 1, 0); err != nil {
+		return err
+	}
+	if err := d.set_ioctl(whd.WLC_SET_CHANNEL, whd.IF_STA, uint32(ch)); err != nil {
+		return err
+	}
+	if err := d.set_iovar2("bss", whd.IF_STA, 1, 1); err != nil {
+		return err
+	}
+	d.apChannel = ch
+	return nil
+}
+
+// SetCountry sets the regulatory domain of the device which determines the allowed
+// channels and transmit power. code is a two letter uppercase ISO 3166 country code, i.e: "US", "JP", "DE"
+// and rev is the regulatory revision in the loaded CLM, 0 selects the default revision.
+// Returns an error if the country is not supported by the CLM.
+// Changing the country while connected may cause the link to drop.
+func (d *Device) SetCountry(code string, rev uint8) error {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return err
+	}
+	return d.setCountry(code, rev)
+}
+
+// Country returns the country code and regulatory revision currently set in the device.
+func (d *Device) Country() (code string, rev uint8, err error) {
+	err = d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return "", 0, err
+	}
+	var info [12]byte
+	_, err = d.get_iovar_n("country", whd.IF_STA, info[:])
+	if err != nil {
+		return "", 0, err
+	}
+	return string(info[8:10]), uint8(_busOrder.Uint32(info[4:8])), nil
+}
+
+// setCountry sets the regulatory domain of the device and verifies it was applied
+// by reading it back. rev of 0 selects the default revision.
+func (d *Device) setCountry(code string, rev uint8) error {
+	info := whd.CountryInfo(code, rev)
+	if info[0] == 0 {
+		return errInvalidCountry
+	}
+	d.info("setCountry", slog.String("country", code), slog.Int("rev", int(rev)))
+	err := d.set_iovar_n("country", whd.IF_STA, info[:])
+	if err != nil {
+		return errjoin(errCountryUnsupported, err)
+	}
+	// Setting the country takes some time, ioctls fail until it is applied.
+	// Poll the country readback to confirm instead of waiting a fixed time.
+	// Firmware may fall back to another regulatory domain if the CLM does not contain the country.
+	var got [12]byte
+	deadline := time.Now().Add(countryTimeout)
+	for {
+		_, err = d.get_iovar_n("country", whd.IF_STA, got[:])
+		if err == nil {
+			break
+		} else if time.Since(deadline) >= 0 {
+			return err
+		}
+		if err = d.sleep(2 * time.Millisecond); err != nil {
+			return err
+		}
+	}
+	gotRev := _busOrder.Uint32(got[4:8])
+	if [2]byte(got[8:10]) != [2]byte(info[8:10]) || (rev != 0 && gotRev != uint32(rev)) {
+		d.logerr("setCountry:mismatch", slog.String("got", string(got[8:10])), slog.Uint64("gotrev", uint64(gotRev)))
+		return errCountryUnsupported
+	}
+	return nil
+}
+
+// AllowedChannels appends the 2.4GHz channels allowed by the current
+// regulatory domain to dst and returns the result. See [Device.SetCountry].
+func (d *Device) AllowedChannels(dst []uint8) ([]uint8, error) {
+	err := d.acquire(modeWifi)
+	defer d.release()
+	if err != nil {
+		return dst, err
+	}
+	// wl_uint32_list_t: uint32 count followed by count chanspecs.
+	const maxChanspecs = 64
+	var buf [4 + 4*maxChanspecs]byte
+	_, err = d.get_iovar_n("chanspecs", whd.IF_STA, buf[:])
+	if err != nil {
+		return dst, err
+	}
+	count := _busOrder.Uint32(buf[:4])
+	if count > maxChanspecs {
+		return dst, errors.New("too many chanspecs")
+	}
+	var seen uint32 // Bitset of channels already appended.
+	for i := uint32(0); i < count; i++ {
+		chanspec := _busOrder.Uint32(buf[4+4*i:])
+		if chanspec&whd.WL_CHANSPEC_BW_MASK != whd.WL_CHANSPEC_BW_20 {
+			continue // 40MHz chanspecs repeat channels.
+		}
+		ch := uint8(chanspec & whd.WL_CHANSPEC_CHAN_MASK)
+		if ch < 32 && seen&(1<<ch) == 0 {
+			seen |= 1 << ch
+			dst = append(dst, ch)
+		}
+	}
+	return dst, nil
+}
+
 // SetMcastList configures the CYW43439 WiFi chip to accept multicast ethernet
 // frames for the given MAC addresses. This is required for receiving multicast
 // traffic (e.g. mDNS) over WiFi, since the chip filters multicast at the hardware level.
@@ -0,0 +1,324 @@
+package cyw43439
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/soypat/cyw43439/cywemu"
+	"github.com/soypat/cyw43439/whd"
+)
+
+// countIoctls returns the amount of ioctls received by the chip with command cmd and, for iovars, variable name.
+func countIoctls(chip *cywemu.Chip, cmd whd.SDPCMCommand, name string) (n int) {
+	for _, io := range chip.Ioctls() {
+		if io.Cmd == cmd && io.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// lastIoctlValue returns the uint32 value set by the last ioctl with command cmd and, for iovars, variable name.
+func lastIoctlValue(t *testing.T, chip *cywemu.Chip, cmd whd.SDPCMCommand, name string) uint32 {
+	t.Helper()
+	ioctls := chip.Ioctls()
+	for i := len(ioctls) - 1; i >= 0; i-- {
+		io := &ioctls[i]
+		if io.Cmd == cmd && io.Name == name && len(io.Data) >= 4 {
+			return binary.LittleEndian.Uint32(io.Data)
+		}
+	}
+	t.Fatalf("ioctl %s %q not received", cmd.String(), name)
+	return 0
+}
+
+func TestLeave(t *testing.T) {
+	dev, chip := newTestDevice(t, testOpenNet, testWPA2Net)
+	// Leaving without a link is not an error.
+	err := dev.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countIoctls(chip, whd.WLC_DISASSOC, "") != 1 {
+		t.Error("disassociation not sent")
+	}
+
+	var kinds []EventKind
+	err = dev.SetEventHandler(func(ev Event) { kinds = append(kinds, ev.Kind) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.Join(testOpenNet.SSID, JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IsLinkUp() {
+		t.Error("link up after leave")
+	}
+	if _, ok := chip.Associated(); ok {
+		t.Error("chip still associated after leave")
+	}
+	if len(kinds) != 2 || kinds[0] != EventLinkUp || kinds[1] != EventLinkDown {
+		t.Errorf("got events %v, want [linkup linkdown]", kinds)
+	}
+	if _, err = dev.LinkInfo(); err == nil {
+		t.Error("link info available after leave")
+	}
+
+	// Device is ready to join another network.
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: testWPA2Net.Passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := chip.Associated(); !ok || n.SSID != testWPA2Net.SSID {
+		t.Fatal("not associated after rejoin")
+	}
+}
+
+func TestLeaveNoLinkEvent(t *testing.T) {
+	var swallow bool
+	dev, _ := newTestDeviceConfig(t, cywemu.Config{
+		Networks: []cywemu.Network{testOpenNet},
+		OnIoctl: func(io *cywemu.Ioctl) bool {
+			// Firmware acknowledges the disassociation without sending link events.
+			return swallow && io.Cmd == whd.WLC_DISASSOC
+		},
+	}, testConfig())
+	var kinds []EventKind
+	err := dev.SetEventHandler(func(ev Event) { kinds = append(kinds, ev.Kind) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dev.Join(testOpenNet.SSID, JoinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	swallow = true
+	err = dev.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IsLinkUp() {
+		t.Error("link up after leave")
+	}
+	if len(kinds) != 2 || kinds[1] != EventLinkDown {
+		t.Errorf("got events %v, want link down after leave timeout", kinds)
+	}
+}
+
+func TestLinkInfo(t *testing.T) {
+	const rate = 144 // 72Mbps in 500kbps units.
+	dev, _ := newTestDeviceConfig(t, cywemu.Config{
+		Networks: []cywemu.Network{testOpenNet, testWPA2Net},
+		OnIoctl: func(io *cywemu.Ioctl) bool {
+			if io.Cmd != whd.WLC_GET_RATE {
+				return false
+			}
+			io.Response = binary.LittleEndian.AppendUint32(nil, rate)
+			return true
+		},
+	}, testConfig())
+	_, err := dev.LinkInfo()
+	if !errors.Is(err, errNotAssociated) {
+		t.Fatalf("want not associated error, got %v", err)
+	}
+	err = dev.Join(testWPA2Net.SSID, JoinOptions{Passphrase: testWPA2Net.Passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	li, err := dev.LinkInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LinkInfo{BSSID: testWPA2Net.BSSID, Channel: testWPA2Net.Channel, RSSI: testWPA2Net.RSSI, Noise: -92, TxRate: 500 * rate}
+	if li.SSID() != testWPA2Net.SSID || li.BSSID != want.BSSID || li.Channel != want.Channel ||
+		li.RSSI != want.RSSI || li.Noise != want.Noise || li.TxRate != want.TxRate {
+		t.Errorf("got link info %+v, want %+v", li, want)
+	}
+}
+
+func TestPowerManagement(t *testing.T) {
+	cfg := testConfig()
+	cfg.PowerManagement = PMNone
+	dev, chip := newTestDeviceConfig(t, cywemu.Config{}, cfg)
+	if pm := lastIoctlValue(t, chip, whd.WLC_SET_PM, ""); pm != 0 {
+		t.Errorf("init set PM%d, want PM0", pm)
+	}
+
+	err := dev.SetPowerManagement(PMPerformance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := PMPerformance.Params()
+	for _, test := range []struct {
+		name string
+		want uint32
+	}{
+		{name: "pm2_sleep_ret", want: uint32(params.SleepRetMs)},
+		{name: "bcn_li_bcn", want: uint32(params.BeaconPeriod)},
+		{name: "bcn_li_dtim", want: uint32(params.DTIMPeriod)},
+		{name: "assoc_listen", want: uint32(params.AssocListen)},
+	} {
+		if got := lastIoctlValue(t, chip, whd.WLC_SET_VAR, test.name); got != test.want {
+			t.Errorf("%s=%d, want %d", test.name, got, test.want)
+		}
+	}
+	if pm := lastIoctlValue(t, chip, whd.WLC_SET_PM, ""); pm != 2 {
+		t.Errorf("performance mode set PM%d, want PM2", pm)
+	}
+
+	err = dev.SetPowerManagement(PMThroughputThrottling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pm := lastIoctlValue(t, chip, whd.WLC_SET_PM, ""); pm != 1 {
+		t.Errorf("throughput throttling set PM%d, want PM1", pm)
+	}
+
+	err = dev.SetPowerManagementParams(PowerManagementParams{SleepRetMs: 500, BeaconPeriod: 3, DTIMPeriod: 2, AssocListen: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastIoctlValue(t, chip, whd.WLC_SET_VAR, "pm2_sleep_ret"); got != 500 {
+		t.Errorf("custom pm2_sleep_ret=%d, want 500", got)
+	}
+	if pm := lastIoctlValue(t, chip, whd.WLC_SET_PM, ""); pm != 2 {
+		t.Errorf("custom params set PM%d, want PM2", pm)
+	}
+
+	if err = dev.SetPowerManagement(PMNone + 1); err == nil {
+		t.Error("invalid power management mode accepted")
+	}
+}
+
+func TestCountry(t *testing.T) {
+	cfg := testConfig()
+	cfg.Country = "DE"
+	dev, _ := newTestDeviceConfig(t, cywemu.Config{
+		OnIoctl: func(io *cywemu.Ioctl) bool {
+			switch {
+			case io.Cmd == whd.WLC_SET_VAR && io.Name == "country" && string(io.Data[:2]) == "ZZ":
+				return true // Not in CLM, firmware keeps the previous country.
+			case io.Cmd == whd.WLC_GET_VAR && io.Name == "chanspecs":
+				// Channels 1 to 11 at 20MHz and 40MHz chanspecs repeating channels.
+				list := binary.LittleEndian.AppendUint32(nil, 13)
+				for ch := uint8(1); ch <= 11; ch++ {
+					list = binary.LittleEndian.AppendUint32(list, uint32(whd.ChanSpec20(ch)))
+				}
+				list = binary.LittleEndian.AppendUint32(list, 3|0x1800)
+				list = binary.LittleEndian.AppendUint32(list, 11|0x1800)
+				io.Response = list
+				return true
+			}
+			return false
+		},
+	}, cfg)
+	code, _, err := dev.Country()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "DE" {
+		t.Errorf("init set country %q, want DE", code)
+	}
+
+	err = dev.SetCountry("JP", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, rev, err := dev.Country()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "JP" || rev != 2 {
+		t.Errorf("got country %s/%d, want JP/2", code, rev)
+	}
+
+	if err = dev.SetCountry("jp", 0); !errors.Is(err, errInvalidCountry) {
+		t.Errorf("want invalid country error, got %v", err)
+	}
+	if err = dev.SetCountry("ZZ", 0); !errors.Is(err, errCountryUnsupported) {
+		t.Errorf("want unsupported country error, got %v", err)
+	}
+
+	channels, err := dev.AllowedChannels(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 11 || channels[0] != 1 || channels[10] != 11 {
+		t.Errorf("got allowed channels %v, want 1 to 11", channels)
+	}
+}
+
+// lastIoctlData returns the data of the last ioctl with command cmd and, for iovars, variable name.
+func lastIoctlData(t *testing.T, chip *cywemu.Chip, cmd whd.SDPCMCommand, name string) []byte {
+	t.Helper()
+	ioctls := chip.Ioctls()
+	for i := len(ioctls) - 1; i >= 0; i-- {
+		if io := &ioctls[i]; io.Cmd == cmd && io.Name == name {
+			return io.Data
+		}
+	}
+	t.Fatalf("ioctl %s %q not received", cmd.String(), name)
+	return nil
+}
+
+func TestJoinTargeted(t *testing.T) {
+	near := cywemu.Network{SSID: "mesh", BSSID: [6]byte{0x02, 3, 3, 3, 3, 1}, Channel: 1, RSSI: -40}
+	far := cywemu.Network{SSID: "mesh", BSSID: [6]byte{0x02, 3, 3, 3, 3, 2}, Channel: 11, RSSI: -70}
+	hidden := cywemu.Network{SSID: "hidden-net", BSSID: [6]byte{0x02, 4, 4, 4, 4, 4}, Channel: 6, Passphrase: "password123", Hidden: true}
+	dev, chip := newTestDevice(t, near, far, hidden)
+	// wl_extjoin_params_t offsets.
+	const nprobesOff, bssidOff, nchOff, chanspecOff = 40, 56, 64, 68
+
+	for _, test := range []struct {
+		name string
+		ssid string
+		opts JoinOptions
+		want [6]byte
+	}{
+		{name: "strongest", ssid: "mesh", want: near.BSSID},
+		{name: "bssid", ssid: "mesh", opts: JoinOptions{BSSID: far.BSSID}, want: far.BSSID},
+		{name: "channels", ssid: "mesh", opts: JoinOptions{Channels: []uint8{6, 11}}, want: far.BSSID},
+		{name: "hidden", ssid: hidden.SSID, opts: JoinOptions{Hidden: true, Passphrase: hidden.Passphrase}, want: hidden.BSSID},
+	} {
+		njoin := countIoctls(chip, whd.WLC_SET_VAR, "join")
+		err := dev.Join(test.ssid, test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if n, ok := chip.Associated(); !ok || n.BSSID != test.want {
+			t.Errorf("%s: associated with %x, want %x", test.name, n.BSSID, test.want)
+		}
+		if extended := countIoctls(chip, whd.WLC_SET_VAR, "join") > njoin; extended != test.opts.targeted() {
+			t.Errorf("%s: extended join used=%v", test.name, extended)
+		}
+		if err := dev.Leave(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := lastIoctlData(t, chip, whd.WLC_SET_VAR, "join")
+	if nprobes := binary.LittleEndian.Uint32(data[nprobesOff:]); nprobes != 2 {
+		t.Errorf("hidden join with %d probes, want directed probes", int32(nprobes))
+	}
+	err := dev.Join("mesh", JoinOptions{Channels: []uint8{3, 6}})
+	var jerr *JoinError
+	if !errors.As(err, &jerr) || jerr.Reason != JoinFailNotFound {
+		t.Errorf("want network not found on other channels, got %v", err)
+	}
+	data = lastIoctlData(t, chip, whd.WLC_SET_VAR, "join")
+	if [6]byte(data[bssidOff:]) != [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff} || binary.LittleEndian.Uint32(data[nchOff:]) != 2 ||
+		binary.LittleEndian.Uint16(data[chanspecOff+2:]) != whd.ChanSpec20(6) {
+		t.Errorf("join parameters for any BSSID on channels 3 and 6: %x", data[bssidOff:])
+	}
+	for _, channels := range [][]uint8{{0}, {15}, make([]uint8, whd.SCAN_MAX_CHANNELS+1)} {
+		if err := dev.Join("mesh", JoinOptions{Channels: channels}); !errors.Is(err, errScanChannel) {
+			t.Errorf("join on channels %v: want invalid channel error, got %v", channels, err)
+		}
+	}
+}